@@ -0,0 +1,11 @@
+package capture
+
+import "time"
+
+// Packet is a captured packet.
+type Packet struct {
+	Time     time.Time
+	Data     []byte
+	Length   int  // length on the wire, Data may be shorter
+	Outgoing bool // sent by the host on the interface, on a tap that is towards the VM
+}
@@ -0,0 +1,141 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Handle is an AF_PACKET socket bound to one interface.
+type Handle struct {
+	fd      int
+	snapLen int
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// Open opens a packet socket on the interface. The socket is created with protocol
+// 0, so it receives nothing until it is bound to the interface and ETH_P_ALL, and
+// the filter, if any, is attached before that: no unfiltered packets and none of
+// other interfaces slip through. It needs CAP_NET_RAW.
+func Open(ifname string, snapLen int, filter []Instruction) (*Handle, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", ifname, err)
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			return nil, fmt.Errorf("opening packet socket, CAP_NET_RAW is needed: %w", err)
+		}
+		return nil, fmt.Errorf("opening packet socket: %w", err)
+	}
+	h := &Handle{fd: fd, snapLen: snapLen}
+	if len(filter) > 0 {
+		prog := make([]syscall.SockFilter, len(filter))
+		for i, ins := range filter {
+			prog[i] = syscall.SockFilter{Code: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+		}
+		err = syscall.AttachLsf(fd, prog) //nolint:staticcheck
+		if err != nil {
+			_ = h.Close()
+			return nil, fmt.Errorf("attaching filter: %w", err)
+		}
+	}
+	// wake up regularly so the context is honoured
+	tv := syscall.NsecToTimeval(int64(250 * time.Millisecond))
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		_ = h.Close()
+		return nil, fmt.Errorf("setting receive timeout: %w", err)
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: iface.Index})
+	if err != nil {
+		_ = h.Close()
+		return nil, fmt.Errorf("binding to %s: %w", ifname, err)
+	}
+	return h, nil
+}
+
+// OpenNamespace is Open for an interface in another network namespace, given by its
+// file like /run/netns/lab. The socket is opened on a thread that enters the namespace
+// and leaves it again, the socket stays bound to the interface in there. Entering a
+// namespace needs CAP_SYS_ADMIN.
+func OpenNamespace(namespace, ifname string, snapLen int, filter []Instruction) (*Handle, error) {
+	if sysSetns == 0 {
+		return nil, fmt.Errorf("capture in a namespace is not supported on %s", runtime.GOARCH)
+	}
+	target, err := os.Open(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("namespace: %w", err)
+	}
+	defer target.Close()
+	runtime.LockOSThread()
+	self, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("own namespace: %w", err)
+	}
+	defer self.Close()
+	err = setns(target)
+	if err != nil {
+		runtime.UnlockOSThread()
+		if errors.Is(err, syscall.EPERM) {
+			return nil, fmt.Errorf("entering namespace %s, CAP_SYS_ADMIN is needed: %w", namespace, err)
+		}
+		return nil, fmt.Errorf("entering namespace %s: %w", namespace, err)
+	}
+	h, err := Open(ifname, snapLen, filter)
+	if backErr := setns(self); backErr != nil {
+		// the thread stays locked, so it ends with the goroutine instead of running
+		// others in the wrong namespace
+		if h != nil {
+			_ = h.Close()
+		}
+		return nil, fmt.Errorf("leaving namespace %s: %w", namespace, backErr)
+	}
+	runtime.UnlockOSThread()
+	return h, err
+}
+
+func setns(f *os.File) error {
+	_, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), syscall.CLONE_NEWNET, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Capture reads packets and hands them to fn until the context is cancelled or fn fails.
+// The packet data is only valid until fn returns.
+func (h *Handle) Capture(ctx context.Context, fn func(Packet) error) error {
+	buf := make([]byte, h.snapLen)
+	for ctx.Err() == nil {
+		n, from, err := syscall.Recvfrom(h.fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return fmt.Errorf("reading packet: %w", err)
+		}
+		p := Packet{Time: time.Now(), Length: n, Data: buf[:min(n, len(buf))]}
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok {
+			p.Outgoing = ll.Pkttype == syscall.PACKET_OUTGOING
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handle) Close() error {
+	return syscall.Close(h.fd)
+}
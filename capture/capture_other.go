@@ -0,0 +1,28 @@
+//go:build !linux
+
+package capture
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Handle is not available on this OS.
+type Handle struct{}
+
+func Open(_ string, _ int, _ []Instruction) (*Handle, error) {
+	return nil, fmt.Errorf("packet capture is not supported on %s", runtime.GOOS)
+}
+
+func OpenNamespace(_, _ string, _ int, _ []Instruction) (*Handle, error) {
+	return nil, fmt.Errorf("packet capture is not supported on %s", runtime.GOOS)
+}
+
+func (h *Handle) Capture(_ context.Context, _ func(Packet) error) error {
+	return fmt.Errorf("packet capture is not supported on %s", runtime.GOOS)
+}
+
+func (h *Handle) Close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package capture reads packets from a network interface with an AF_PACKET socket.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Instruction is a classic BPF instruction.
+type Instruction struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+// CompileFilter compiles a pcap filter expression to BPF for Ethernet interfaces.
+// There is no filter compiler in Go, so tcpdump is asked to do it. It reads an empty
+// capture file, which means no privileges are needed.
+func CompileFilter(expr string) ([]Instruction, error) {
+	tcpdump, err := exec.LookPath("tcpdump")
+	if err != nil {
+		return nil, fmt.Errorf("tcpdump is needed to compile filters: %w", err)
+	}
+	f, err := os.CreateTemp("", "capture-*.pcap")
+	if err != nil {
+		return nil, fmt.Errorf("creating empty capture: %w", err)
+	}
+	defer os.Remove(f.Name())
+	// pcap file header: magic, version 2.4, timezone, sigfigs, snaplen, ethernet
+	hdr := []uint32{0xa1b2c3d4, 0x00040002, 0, 0, 262144, 1}
+	err = binary.Write(f, binary.LittleEndian, hdr)
+	_ = f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("writing empty capture: %w", err)
+	}
+	out, err := exec.Command(tcpdump, "-ddd", "-r", f.Name(), expr).Output()
+	if err != nil {
+		var stderr string
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("compiling filter %q: %w (%s)", expr, err, stderr)
+	}
+	return parseProgram(out)
+}
+
+// parseProgram parses the output of `tcpdump -ddd`: the number of instructions
+// followed by one instruction per line as decimal "code jt jf k".
+func parseProgram(out []byte) ([]Instruction, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty filter program")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		return nil, fmt.Errorf("bad instruction count: %w", err)
+	}
+	prog := make([]Instruction, 0, count)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("bad instruction %q", scanner.Text())
+		}
+		var v [4]uint64
+		for i, f := range fields {
+			v[i], err = strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad instruction %q: %w", scanner.Text(), err)
+			}
+		}
+		prog = append(prog, Instruction{Code: uint16(v[0]), Jt: uint8(v[1]), Jf: uint8(v[2]), K: uint32(v[3])})
+	}
+	if len(prog) != count {
+		return nil, fmt.Errorf("expected %d instructions, got %d", count, len(prog))
+	}
+	return prog, nil
+}
@@ -0,0 +1,30 @@
+package capture
+
+import "testing"
+
+// output of tcpdump -ddd ip
+const ipProgram = `4
+40 0 0 12
+21 0 1 2048
+6 0 0 262144
+6 0 0 0
+`
+
+func Test_parseProgram(t *testing.T) {
+	prog, err := parseProgram([]byte(ipProgram))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(prog) != 4 {
+		t.Fatalf("expected 4 instructions, got %d", len(prog))
+	}
+	want := Instruction{Code: 21, Jt: 0, Jf: 1, K: 2048}
+	if prog[1] != want {
+		t.Errorf("expected %+v, got %+v", want, prog[1])
+	}
+	for _, bad := range []string{"", "2\n6 0 0 0\n", "1\n6 0 0\n", "1\n6 0 x 0\n"} {
+		if _, err := parseProgram([]byte(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+package capture
+
+// sysSetns is missing from package syscall on amd64.
+const sysSetns = 308
@@ -0,0 +1,5 @@
+package capture
+
+import "syscall"
+
+const sysSetns = syscall.SYS_SETNS
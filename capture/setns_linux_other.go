@@ -0,0 +1,6 @@
+//go:build linux && !amd64 && !arm64
+
+package capture
+
+// sysSetns is not known here, OpenNamespace fails.
+const sysSetns = 0
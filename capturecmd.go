@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/capture"
+	"github.com/perbu/qemu-wrapper/pcapng"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// runCapture captures the traffic on an interface of a running VM as pcapng.
+func runCapture(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("capture", flag.ContinueOnError)
+	filter := flags.String("f", "", "pcap filter expression, compiled with tcpdump")
+	output := flags.String("w", "-", "output file, - for stdout")
+	fileSize := flags.Int64("C", 0, "rotate output files after this many megabytes")
+	fileCount := flags.Int("W", 0, "number of files in the ring buffer when rotating, 0 for no limit")
+	snapLen := flags.Int("s", 262144, "bytes to capture per packet")
+	count := flags.Int("c", 0, "stop after this many packets")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("usage: capture [-f filter] [-w file] [-C megabytes] [-W files] [-s snaplen] [-c count] <vm> [iface]")
+	}
+	if *fileSize > 0 && *output == "-" {
+		return fmt.Errorf("rotation needs an output file")
+	}
+	vm, iface := flags.Arg(0), flags.Arg(1)
+	if iface == "" {
+		iface = "net0"
+	}
+	st, err := loadState(vm)
+	if err != nil {
+		return err
+	}
+	tapName, err := st.tap(iface)
+	if err != nil {
+		return err
+	}
+	var prog []capture.Instruction
+	if *filter != "" {
+		prog, err = capture.CompileFilter(*filter)
+		if err != nil {
+			return err
+		}
+	}
+	var h *capture.Handle
+	if st.otherNamespace() {
+		h, err = capture.OpenNamespace(tuntap.NamespacePath(st.Namespace), tapName, *snapLen, prog)
+	} else {
+		h, err = capture.Open(tapName, *snapLen, prog)
+	}
+	if err != nil {
+		return fmt.Errorf("capture on %s: %w", tapName, err)
+	}
+	defer h.Close()
+
+	out := &ringWriter{
+		base:     *output,
+		maxSize:  *fileSize * 1000 * 1000,
+		maxFiles: *fileCount,
+		iface: pcapng.Interface{
+			Name:        tapName,
+			Description: fmt.Sprintf("%s %s", vm, iface),
+			SnapLen:     uint32(*snapLen),
+		},
+	}
+	defer out.Close()
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	// stdout may be the capture, so talk on stderr
+	_, _ = fmt.Fprintf(os.Stderr, "Capturing on %s (%s %s)\n", tapName, vm, iface)
+	packets := 0
+	err = h.Capture(ctx, func(p capture.Packet) error {
+		dir := pcapng.DirectionInbound
+		if p.Outgoing {
+			dir = pcapng.DirectionOutbound
+		}
+		if err := out.WritePacket(p, dir); err != nil {
+			return err
+		}
+		packets++
+		if *count > 0 && packets >= *count {
+			cancel()
+		}
+		return nil
+	})
+	_, _ = fmt.Fprintf(os.Stderr, "%d packets captured\n", packets)
+	return err
+}
+
+// ringWriter writes pcapng to stdout or to files. With a size limit it starts a new
+// file when the current one is full, and with a file limit it reuses the oldest.
+type ringWriter struct {
+	base     string
+	maxSize  int64
+	maxFiles int
+	iface    pcapng.Interface
+
+	index int
+	file  io.WriteCloser
+	size  int64
+	w     *pcapng.Writer
+}
+
+func (r *ringWriter) Write(p []byte) (int, error) {
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *ringWriter) WritePacket(p capture.Packet, dir pcapng.Direction) error {
+	if r.w == nil || (r.maxSize > 0 && r.size >= r.maxSize) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	return r.w.WritePacket(p.Time, p.Data, p.Length, dir)
+}
+
+// rotate opens the next file and writes the pcapng headers to it.
+func (r *ringWriter) rotate() error {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			return fmt.Errorf("closing capture file: %w", err)
+		}
+		r.index++
+		if r.maxFiles > 0 {
+			r.index %= r.maxFiles
+		}
+	}
+	switch {
+	case r.base == "-":
+		r.file = nopCloser{os.Stdout}
+	default:
+		f, err := os.Create(r.filename())
+		if err != nil {
+			return fmt.Errorf("creating capture file: %w", err)
+		}
+		r.file = f
+	}
+	r.size = 0
+	w, err := pcapng.NewWriter(r, "qemu-wrapper", r.iface)
+	if err != nil {
+		return err
+	}
+	r.w = w
+	return nil
+}
+
+// filename returns the name of the current file, numbered when rotating: capture.3.pcapng.
+func (r *ringWriter) filename() string {
+	if r.maxSize == 0 {
+		return r.base
+	}
+	ext := filepath.Ext(r.base)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(r.base, ext), r.index, ext)
+}
+
+func (r *ringWriter) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
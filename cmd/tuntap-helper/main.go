@@ -0,0 +1,39 @@
+// tuntap-helper is a small privileged service that creates taps and attaches them
+// to bridges on behalf of unprivileged users, as allowed by a policy file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/privhelper"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	err := run(ctx, os.Args)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	socket := flags.String("socket", privhelper.DefaultSocket, "unix socket to listen on")
+	policyFile := flags.String("policy", "/etc/tuntap-helper.policy", "policy file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	policy, err := privhelper.LoadPolicy(*policyFile)
+	if err != nil {
+		return fmt.Errorf("policy: %w", err)
+	}
+	srv := privhelper.NewServer(policy)
+	fmt.Printf("Listening on %s\n", *socket)
+	return srv.ListenAndServe(ctx, *socket)
+}
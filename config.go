@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// vmConfig is the optional per-VM configuration file given with -config.
+// Settings for network interfaces are keyed by the interface id, net0 being the first NIC.
+type vmConfig struct {
+	Interfaces  map[string]interfaceConfig  `json:"interfaces,omitempty"`
+	Bridges     map[string]bridgeConfig     `json:"bridges,omitempty"`
+	Impairments map[string]impairmentConfig `json:"impairments,omitempty"`
+	Mirrors     []mirrorConfig              `json:"mirrors,omitempty"`
+	Uplinks     []uplinkConfig              `json:"uplinks,omitempty"`
+	Forwards    []forwardConfig             `json:"forwards,omitempty"`
+	Namespace   *namespaceConfig            `json:"namespace,omitempty"`
+}
+
+// interfaceConfig holds the settings of a NIC.
+type interfaceConfig struct {
+	// MTU is set on the tap and announced to the guest as host_mtu.
+	MTU int `json:"mtu,omitempty"`
+	// Backend is empty for the backend given with -net, or macvtap.
+	Backend string `json:"backend,omitempty"`
+	// Parent is the link a macvtap is created on.
+	Parent string `json:"parent,omitempty"`
+	// Mode is the macvtap mode, bridge if empty.
+	Mode string `json:"mode,omitempty"`
+}
+
+// bridgeConfig holds the settings of a bridge the VM is attached to.
+type bridgeConfig struct {
+	MTU int `json:"mtu,omitempty"`
+	// Addresses are host addresses with prefix length, kept on the bridge while VMs use it.
+	Addresses []string `json:"addresses,omitempty"`
+	// NAT masquerades traffic from the bridge, towards NATInterface only if set.
+	NAT          bool   `json:"nat,omitempty"`
+	NATInterface string `json:"nat_interface,omitempty"`
+}
+
+func (c bridgeConfig) host() (tuntap.BridgeHost, error) {
+	h := tuntap.BridgeHost{NAT: c.NAT, NATInterface: c.NATInterface}
+	for _, a := range c.Addresses {
+		p, err := netip.ParsePrefix(a)
+		if err != nil {
+			return h, fmt.Errorf("address: %w", err)
+		}
+		h.Addresses = append(h.Addresses, p)
+	}
+	if c.NATInterface != "" && !c.NAT {
+		return h, fmt.Errorf("nat_interface without nat")
+	}
+	return h, nil
+}
+
+// impairmentConfig is the link impairment of an interface, see tuntap.Impairment.
+type impairmentConfig struct {
+	Delay     string  `json:"delay,omitempty"`
+	Jitter    string  `json:"jitter,omitempty"`
+	Loss      float64 `json:"loss,omitempty"`
+	Duplicate float64 `json:"duplicate,omitempty"`
+	Reorder   float64 `json:"reorder,omitempty"`
+	Corrupt   float64 `json:"corrupt,omitempty"`
+	Rate      string  `json:"rate,omitempty"`
+}
+
+func (c impairmentConfig) impairment() (tuntap.Impairment, error) {
+	imp := tuntap.Impairment{
+		Loss:      c.Loss,
+		Duplicate: c.Duplicate,
+		Reorder:   c.Reorder,
+		Corrupt:   c.Corrupt,
+		Rate:      c.Rate,
+	}
+	var err error
+	if c.Delay != "" {
+		imp.Delay, err = time.ParseDuration(c.Delay)
+		if err != nil {
+			return imp, fmt.Errorf("delay: %w", err)
+		}
+	}
+	if c.Jitter != "" {
+		imp.Jitter, err = time.ParseDuration(c.Jitter)
+		if err != nil {
+			return imp, fmt.Errorf("jitter: %w", err)
+		}
+	}
+	return imp, imp.Validate()
+}
+
+// loadConfig reads the VM configuration. Without a file the configuration is empty.
+func loadConfig(filename string) (*vmConfig, error) {
+	cfg := &vmConfig{}
+	if filename == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	err = json.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", filename, err)
+	}
+	for iface, c := range cfg.Interfaces {
+		if c.MTU != 0 && (c.MTU < 68 || c.MTU > 65535) {
+			return nil, fmt.Errorf("mtu %d of %s out of range", c.MTU, iface)
+		}
+		switch c.Backend {
+		case "":
+		case "macvtap":
+			if c.Parent == "" {
+				return nil, fmt.Errorf("macvtap %s has no parent link", iface)
+			}
+			if _, err := tuntap.ParseMacvtapMode(c.Mode); err != nil {
+				return nil, fmt.Errorf("%s: %w", iface, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown backend %q of %s", c.Backend, iface)
+		}
+	}
+	for bridge, c := range cfg.Bridges {
+		if _, err := c.host(); err != nil {
+			return nil, fmt.Errorf("bridge %s: %w", bridge, err)
+		}
+	}
+	for iface, c := range cfg.Impairments {
+		if _, err := c.impairment(); err != nil {
+			return nil, fmt.Errorf("impairment of %s: %w", iface, err)
+		}
+	}
+	for _, m := range cfg.Mirrors {
+		if m.Target == "" {
+			return nil, fmt.Errorf("mirror of %s has no target", m.Source)
+		}
+		if _, err := tuntap.ParseMirrorDirection(m.Direction); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range cfg.Uplinks {
+		if u.Interface == "" {
+			return nil, fmt.Errorf("uplink without interface")
+		}
+	}
+	if ns := cfg.Namespace; ns != nil {
+		if ns.Name == "" {
+			return nil, fmt.Errorf("namespace without name")
+		}
+		if ns.Veth != nil {
+			if _, err := ns.Veth.veth(); err != nil {
+				return nil, fmt.Errorf("namespace %s: %w", ns.Name, err)
+			}
+		}
+		// both are links of the host
+		if len(cfg.Uplinks) > 0 {
+			return nil, fmt.Errorf("uplinks can't be used with a namespace")
+		}
+		for iface, c := range cfg.Interfaces {
+			if c.Backend == "macvtap" {
+				return nil, fmt.Errorf("macvtap %s can't be used with a namespace", iface)
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for _, f := range cfg.Forwards {
+		if f.Name == "" || names[f.Name] {
+			return nil, fmt.Errorf("forwards need unique names, got %q", f.Name)
+		}
+		names[f.Name] = true
+		if f.Port == 0 {
+			return nil, fmt.Errorf("forward %s has no port", f.Name)
+		}
+		if f.Address != "" {
+			if _, err := netip.ParseAddr(f.Address); err != nil {
+				return nil, fmt.Errorf("forward %s: %w", f.Name, err)
+			}
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,37 @@
+package dhcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Listen opens the DHCP server port bound to the interface, so only requests
+// arriving on it are seen and broadcast replies leave through it.
+// Port 67 needs CAP_NET_BIND_SERVICE.
+func Listen(ifname string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+				if serr == nil {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+				}
+				if serr == nil {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", ":67")
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", ifname, err)
+	}
+	return conn, nil
+}
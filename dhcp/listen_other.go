@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func Listen(_ string) (net.PacketConn, error) {
+	return nil, fmt.Errorf("the dhcp server is not supported on %s", runtime.GOOS)
+}
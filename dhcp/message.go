@@ -0,0 +1,201 @@
+// Package dhcp is a small DHCPv4 server for the management bridges of a lab.
+// Addresses are handed out deterministically, derived from the VM name or the
+// MAC address, so a VM gets the same address every time it boots.
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// Message types, option 53.
+const (
+	Discover = 1
+	Offer    = 2
+	Request  = 3
+	Decline  = 4
+	Ack      = 5
+	Nak      = 6
+	Release  = 7
+	Inform   = 8
+)
+
+// Option codes used by the server.
+const (
+	OptSubnetMask    = 1
+	OptRouter        = 3
+	OptDNS           = 6
+	OptHostname      = 12
+	OptDomainName    = 15
+	OptRequestedIP   = 50
+	OptLeaseTime     = 51
+	OptMessageType   = 53
+	OptServerID      = 54
+	OptRenewalTime   = 58
+	OptRebindingTime = 59
+	OptClientID      = 61
+	OptTFTPServer    = 66
+	OptBootfile      = 67
+	OptEnd           = 255
+	optPad           = 0
+)
+
+const (
+	bootRequest  = 1
+	bootReply    = 2
+	headerLength = 236
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// Message is a DHCP message.
+type Message struct {
+	Op      byte
+	Hops    byte
+	XID     uint32
+	Secs    uint16
+	Flags   uint16
+	CIAddr  netip.Addr
+	YIAddr  netip.Addr
+	SIAddr  netip.Addr
+	GIAddr  netip.Addr
+	CHAddr  net.HardwareAddr
+	SName   string
+	File    string
+	Options map[byte][]byte
+}
+
+// Type returns the message type from option 53, 0 if missing.
+func (m *Message) Type() byte {
+	if v := m.Options[OptMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// OptionAddr returns an option holding a single IPv4 address.
+func (m *Message) OptionAddr(code byte) (netip.Addr, bool) {
+	v := m.Options[code]
+	if len(v) != 4 {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFrom4([4]byte(v)), true
+}
+
+// Parse decodes a DHCP message. Options spread over several instances are concatenated.
+func Parse(data []byte) (*Message, error) {
+	if len(data) < headerLength+len(magicCookie) {
+		return nil, fmt.Errorf("message too short, %d bytes", len(data))
+	}
+	if data[1] != 1 || data[2] != 6 {
+		return nil, fmt.Errorf("unsupported hardware type %d length %d", data[1], data[2])
+	}
+	if !slices.Equal(data[headerLength:headerLength+4], magicCookie) {
+		return nil, errors.New("missing magic cookie")
+	}
+	m := &Message{
+		Op:      data[0],
+		Hops:    data[3],
+		XID:     binary.BigEndian.Uint32(data[4:8]),
+		Secs:    binary.BigEndian.Uint16(data[8:10]),
+		Flags:   binary.BigEndian.Uint16(data[10:12]),
+		CIAddr:  netip.AddrFrom4([4]byte(data[12:16])),
+		YIAddr:  netip.AddrFrom4([4]byte(data[16:20])),
+		SIAddr:  netip.AddrFrom4([4]byte(data[20:24])),
+		GIAddr:  netip.AddrFrom4([4]byte(data[24:28])),
+		CHAddr:  net.HardwareAddr(slices.Clone(data[28:34])),
+		SName:   cString(data[44:108]),
+		File:    cString(data[108:236]),
+		Options: make(map[byte][]byte),
+	}
+	opts := data[headerLength+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == OptEnd {
+			break
+		}
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, fmt.Errorf("option %d truncated", code)
+		}
+		end := 2 + int(opts[1])
+		m.Options[code] = append(m.Options[code], opts[2:end]...)
+		opts = opts[end:]
+	}
+	return m, nil
+}
+
+// Marshal encodes the message. The message type goes first, the other options
+// follow in order of their code, long options are split over several instances.
+func (m *Message) Marshal() []byte {
+	buf := make([]byte, headerLength, 576)
+	buf[0] = m.Op
+	buf[1] = 1 // ethernet
+	buf[2] = 6
+	buf[3] = m.Hops
+	binary.BigEndian.PutUint32(buf[4:8], m.XID)
+	binary.BigEndian.PutUint16(buf[8:10], m.Secs)
+	binary.BigEndian.PutUint16(buf[10:12], m.Flags)
+	for i, a := range []netip.Addr{m.CIAddr, m.YIAddr, m.SIAddr, m.GIAddr} {
+		if a.Is4() {
+			b := a.As4()
+			copy(buf[12+4*i:], b[:])
+		}
+	}
+	copy(buf[28:44], m.CHAddr)
+	copy(buf[44:107], m.SName)
+	copy(buf[108:235], m.File)
+	buf = append(buf, magicCookie...)
+	codes := make([]int, 0, len(m.Options))
+	for code := range m.Options {
+		if code != OptMessageType && code != optPad && code != OptEnd {
+			codes = append(codes, int(code))
+		}
+	}
+	slices.Sort(codes)
+	if _, ok := m.Options[OptMessageType]; ok {
+		codes = append([]int{OptMessageType}, codes...)
+	}
+	for _, code := range codes {
+		v := m.Options[byte(code)]
+		for first := true; first || len(v) > 0; first = false {
+			n := min(len(v), 255)
+			buf = append(buf, byte(code), byte(n))
+			buf = append(buf, v[:n]...)
+			v = v[n:]
+		}
+	}
+	buf = append(buf, OptEnd)
+	// some clients drop replies shorter than a BOOTP message
+	for len(buf) < 300 {
+		buf = append(buf, optPad)
+	}
+	return buf
+}
+
+func cString(b []byte) string {
+	if i := slices.Index(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func addrsOption(addrs ...netip.Addr) []byte {
+	var v []byte
+	for _, a := range addrs {
+		b := a.As4()
+		v = append(v, b[:]...)
+	}
+	return v
+}
+
+func uint32Option(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
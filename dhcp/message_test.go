@@ -0,0 +1,64 @@
+package dhcp
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestMessage_roundtrip(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:12:34:56:78")
+	m := &Message{
+		Op:     bootRequest,
+		XID:    0xdeadbeef,
+		Flags:  0x8000,
+		CIAddr: netip.MustParseAddr("10.10.0.5"),
+		CHAddr: mac,
+		File:   "boot.cfg",
+		Options: map[byte][]byte{
+			OptMessageType: {Request},
+			OptHostname:    []byte("r1"),
+			224:            make([]byte, 300), // split over two instances
+		},
+	}
+	data := m.Marshal()
+	if data[headerLength+4] != OptMessageType {
+		t.Errorf("expected the message type first, got option %d", data[headerLength+4])
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got.XID != m.XID || got.Flags != m.Flags || got.CIAddr != m.CIAddr || got.File != m.File {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+	if got.CHAddr.String() != mac.String() {
+		t.Errorf("expected %s, got %s", mac, got.CHAddr)
+	}
+	if got.Type() != Request {
+		t.Errorf("expected type %d, got %d", Request, got.Type())
+	}
+	if len(got.Options[224]) != 300 {
+		t.Errorf("expected 300 bytes of option 224, got %d", len(got.Options[224]))
+	}
+	if !slices.Equal(got.Options[OptHostname], []byte("r1")) {
+		t.Errorf("expected hostname r1, got %q", got.Options[OptHostname])
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	if _, err := Parse(make([]byte, 100)); err == nil {
+		t.Errorf("expected error for short message")
+	}
+	m := (&Message{Op: bootRequest, CHAddr: make(net.HardwareAddr, 6)}).Marshal()
+	m[headerLength] = 0
+	if _, err := Parse(m); err == nil {
+		t.Errorf("expected error for missing cookie")
+	}
+	m = (&Message{Op: bootRequest, CHAddr: make(net.HardwareAddr, 6)}).Marshal()
+	m = append(m[:headerLength+4], 12, 10, 'x')
+	if _, err := Parse(m); err == nil {
+		t.Errorf("expected error for truncated option")
+	}
+}
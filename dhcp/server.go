@@ -0,0 +1,353 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const offerTimeout = time.Minute
+
+// Config is the configuration of a server on one network.
+type Config struct {
+	// ServerIP is the address of the server on the network, it must be in Subnet.
+	ServerIP netip.Addr
+	Subnet   netip.Prefix
+	// RangeStart and RangeEnd limit the pool for VMs without a reservation.
+	RangeStart netip.Addr
+	RangeEnd   netip.Addr
+	Router     netip.Addr // optional
+	DNS        []netip.Addr
+	Domain     string
+	LeaseTime  time.Duration
+	// Reservations are fixed addresses by VM name for its net0 interface,
+	// or by vm:iface for the other interfaces.
+	Reservations map[string]netip.Addr
+}
+
+// Validate checks that the addresses fit together.
+func (c Config) Validate() error {
+	if !c.Subnet.IsValid() || !c.Subnet.Addr().Is4() {
+		return fmt.Errorf("subnet %s is not an IPv4 prefix", c.Subnet)
+	}
+	for name, a := range map[string]netip.Addr{"server": c.ServerIP, "range start": c.RangeStart, "range end": c.RangeEnd} {
+		if !c.Subnet.Contains(a) {
+			return fmt.Errorf("%s %s not in %s", name, a, c.Subnet)
+		}
+	}
+	if c.RangeEnd.Less(c.RangeStart) {
+		return fmt.Errorf("range end %s before start %s", c.RangeEnd, c.RangeStart)
+	}
+	if c.Router.IsValid() && !c.Subnet.Contains(c.Router) {
+		return fmt.Errorf("router %s not in %s", c.Router, c.Subnet)
+	}
+	for vm, a := range c.Reservations {
+		if !c.Subnet.Contains(a) {
+			return fmt.Errorf("reservation %s of %s not in %s", a, vm, c.Subnet)
+		}
+		if a == c.Subnet.Masked().Addr() || a == c.broadcast() {
+			return fmt.Errorf("reservation %s of %s is not a host address of %s", a, vm, c.Subnet)
+		}
+	}
+	if c.ServerIP == c.broadcast() || c.Router == c.broadcast() {
+		return fmt.Errorf("the broadcast address %s of %s cannot be the server or router", c.broadcast(), c.Subnet)
+	}
+	if c.LeaseTime < time.Minute {
+		return fmt.Errorf("lease time %s too short", c.LeaseTime)
+	}
+	return nil
+}
+
+// broadcast returns the broadcast address of the subnet, invalid for /31 and /32
+// subnets which have none.
+func (c Config) broadcast() netip.Addr {
+	if c.Subnet.Bits() >= 31 {
+		return netip.Addr{}
+	}
+	a := c.Subnet.Masked().Addr().As4()
+	n := be32(a) | (1<<(32-c.Subnet.Bits()) - 1)
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
+
+// Lease is an address given to a client.
+type Lease struct {
+	MAC      string     `json:"mac"`
+	IP       netip.Addr `json:"ip"`
+	VM       string     `json:"vm,omitempty"`
+	Hostname string     `json:"hostname,omitempty"`
+	Expires  time.Time  `json:"expires"`
+	// Offered is true until the client has requested the address.
+	Offered bool `json:"offered,omitempty"`
+}
+
+// Server hands out leases on one network.
+type Server struct {
+	cfg    Config
+	mu     sync.Mutex
+	leases map[string]*Lease // by mac
+	now    func() time.Time
+	// VMName returns the name of the VM with the MAC address, the empty string if unknown.
+	VMName func(mac net.HardwareAddr) string
+	// Interface returns the interface id, like net1, of the VM NIC with the MAC address,
+	// the empty string if unknown, which counts as net0.
+	Interface func(mac net.HardwareAddr) string
+	// OnChange is called after a lease was granted, renewed or released.
+	OnChange func()
+	// Options returns extra options for the offer or ack of a lease, it may be nil.
+	Options func(req *Message, l Lease) map[byte][]byte
+}
+
+// NewServer creates a server. Leases from an earlier run may be passed in to keep them.
+func NewServer(cfg Config, leases []Lease) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:       cfg,
+		leases:    make(map[string]*Lease),
+		now:       time.Now,
+		VMName:    func(net.HardwareAddr) string { return "" },
+		Interface: func(net.HardwareAddr) string { return "" },
+	}
+	for _, l := range leases {
+		if !l.Offered {
+			l := l
+			s.leases[l.MAC] = &l
+		}
+	}
+	return s, nil
+}
+
+// Leases returns the current leases sorted by address.
+func (s *Server) Leases() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leases := make([]Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		leases = append(leases, *l)
+	}
+	slices.SortFunc(leases, func(a, b Lease) int { return a.IP.Compare(b.IP) })
+	return leases
+}
+
+// Serve answers requests on the connection until the context is cancelled.
+// Replies are broadcast, the client has no address to send them to yet.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dhcp read: %w", err)
+		}
+		req, err := Parse(buf[:n])
+		if err != nil {
+			log.Printf("dhcp: bad message from %s: %v", from, err)
+			continue
+		}
+		reply := s.Handle(req)
+		if reply == nil {
+			continue
+		}
+		to := broadcast
+		if req.GIAddr.IsValid() && !req.GIAddr.IsUnspecified() {
+			to = &net.UDPAddr{IP: req.GIAddr.AsSlice(), Port: 67}
+		}
+		_, err = conn.WriteTo(reply.Marshal(), to)
+		if err != nil {
+			log.Printf("dhcp: reply to %s: %v", req.CHAddr, err)
+		}
+	}
+}
+
+// Handle returns the reply to a request, nil if there is nothing to answer.
+func (s *Server) Handle(req *Message) *Message {
+	if req.Op != bootRequest || len(req.CHAddr) != 6 {
+		return nil
+	}
+	s.mu.Lock()
+	reply, changed := s.handle(req)
+	s.mu.Unlock()
+	if changed && s.OnChange != nil {
+		s.OnChange()
+	}
+	return reply
+}
+
+func (s *Server) handle(req *Message) (*Message, bool) {
+	mac := req.CHAddr.String()
+	switch req.Type() {
+	case Discover:
+		l, err := s.lease(req)
+		if err != nil {
+			log.Printf("dhcp: no address for %s: %v", mac, err)
+			return nil, false
+		}
+		if l.Offered {
+			l.Expires = s.now().Add(offerTimeout)
+		}
+		return s.reply(req, Offer, l), false
+	case Request:
+		if id, ok := req.OptionAddr(OptServerID); ok && id != s.cfg.ServerIP {
+			// the client picked another server
+			return nil, s.drop(mac, true)
+		}
+		want, ok := req.OptionAddr(OptRequestedIP)
+		if !ok {
+			want = req.CIAddr
+		}
+		l, err := s.lease(req)
+		if err != nil || l.IP != want {
+			s.drop(mac, true)
+			return s.reply(req, Nak, nil), false
+		}
+		l.Offered = false
+		l.Expires = s.now().Add(s.cfg.LeaseTime)
+		l.Hostname = string(req.Options[OptHostname])
+		return s.reply(req, Ack, l), true
+	case Release, Decline:
+		return nil, s.drop(mac, false)
+	case Inform:
+		return s.reply(req, Ack, nil), false
+	}
+	return nil, false
+}
+
+// drop removes the lease of the mac, only offers if offeredOnly is set.
+// It returns true if a lease was removed.
+func (s *Server) drop(mac string, offeredOnly bool) bool {
+	l, ok := s.leases[mac]
+	if !ok || offeredOnly && !l.Offered {
+		return false
+	}
+	delete(s.leases, mac)
+	return !l.Offered
+}
+
+// lease returns the lease of the client, creating an offer if it has none.
+func (s *Server) lease(req *Message) (*Lease, error) {
+	mac := req.CHAddr.String()
+	vm := s.VMName(req.CHAddr)
+	if l, ok := s.leases[mac]; ok && l.VM == vm {
+		return l, nil
+	}
+	ip, err := s.address(mac, nicKey(vm, s.Interface(req.CHAddr)))
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{MAC: mac, IP: ip, VM: vm, Offered: true}
+	s.leases[mac] = l
+	return l, nil
+}
+
+// nicKey names a VM NIC the way reservations do: the VM name for net0, vm:iface
+// for the others, and the empty string for clients that are not VMs.
+func nicKey(vm, iface string) string {
+	if vm == "" || iface == "" || iface == "net0" {
+		return vm
+	}
+	return vm + ":" + iface
+}
+
+// address picks the address for a client: the reservation of its VM NIC, or a free address
+// in the range starting at a position derived from the NIC, or the MAC address of
+// unknown clients, so the same client ends up with the same address.
+func (s *Server) address(mac, nic string) (netip.Addr, error) {
+	if ip, ok := s.cfg.Reservations[nic]; ok && nic != "" {
+		return ip, nil
+	}
+	key := mac
+	if nic != "" {
+		key = nic
+	}
+	start, end := s.cfg.RangeStart.As4(), s.cfg.RangeEnd.As4()
+	first, last := be32(start), be32(end)
+	size := last - first + 1
+	offset := crc32.ChecksumIEEE([]byte(key)) % size
+	now := s.now()
+	for i := uint32(0); i < size; i++ {
+		n := first + (offset+i)%size
+		ip := netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+		if s.inUse(ip, mac, now) {
+			continue
+		}
+		return ip, nil
+	}
+	return netip.Addr{}, errors.New("pool exhausted")
+}
+
+// inUse returns true if the address is taken by another client, reserved, the server's
+// or the network or broadcast address.
+func (s *Server) inUse(ip netip.Addr, mac string, now time.Time) bool {
+	if ip == s.cfg.ServerIP || ip == s.cfg.Router || ip == s.cfg.Subnet.Masked().Addr() || ip == s.cfg.broadcast() {
+		return true
+	}
+	for _, r := range s.cfg.Reservations {
+		if r == ip {
+			return true
+		}
+	}
+	for m, l := range s.leases {
+		if m != mac && l.IP == ip && l.Expires.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) reply(req *Message, typ byte, l *Lease) *Message {
+	r := &Message{
+		Op:      bootReply,
+		XID:     req.XID,
+		Flags:   req.Flags,
+		CIAddr:  req.CIAddr,
+		GIAddr:  req.GIAddr,
+		CHAddr:  req.CHAddr,
+		Options: map[byte][]byte{OptMessageType: {typ}, OptServerID: addrsOption(s.cfg.ServerIP)},
+	}
+	if typ == Nak {
+		return r
+	}
+	if l != nil {
+		r.YIAddr = l.IP
+		lease := uint32(s.cfg.LeaseTime / time.Second)
+		r.Options[OptLeaseTime] = uint32Option(lease)
+		r.Options[OptRenewalTime] = uint32Option(lease / 2)
+		r.Options[OptRebindingTime] = uint32Option(lease / 8 * 7)
+	}
+	mask := net.CIDRMask(s.cfg.Subnet.Bits(), 32)
+	r.Options[OptSubnetMask] = mask
+	if s.cfg.Router.IsValid() {
+		r.Options[OptRouter] = addrsOption(s.cfg.Router)
+	}
+	if len(s.cfg.DNS) > 0 {
+		r.Options[OptDNS] = addrsOption(s.cfg.DNS...)
+	}
+	if s.cfg.Domain != "" {
+		r.Options[OptDomainName] = []byte(s.cfg.Domain)
+	}
+	if l != nil && s.Options != nil {
+		for code, v := range s.Options(req, *l) {
+			r.Options[code] = v
+		}
+	}
+	return r
+}
+
+func be32(b [4]byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,189 @@
+package dhcp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer(Config{
+		ServerIP:     netip.MustParseAddr("10.10.0.1"),
+		Subnet:       netip.MustParsePrefix("10.10.0.0/24"),
+		RangeStart:   netip.MustParseAddr("10.10.0.100"),
+		RangeEnd:     netip.MustParseAddr("10.10.0.109"),
+		Router:       netip.MustParseAddr("10.10.0.1"),
+		LeaseTime:    time.Hour,
+		Reservations: map[string]netip.Addr{"r1": netip.MustParseAddr("10.10.0.11"), "r2:net1": netip.MustParseAddr("10.10.0.22")},
+	}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	names := map[string]string{"52:54:00:00:00:01": "r1", "52:54:00:00:00:02": "r2", "52:54:00:01:00:01": "r1", "52:54:00:01:00:02": "r2"}
+	s.VMName = func(mac net.HardwareAddr) string { return names[mac.String()] }
+	s.Interface = func(mac net.HardwareAddr) string {
+		if mac[3] == 1 {
+			return "net1"
+		}
+		return "net0"
+	}
+	return s
+}
+
+func request(typ byte, mac string, opts map[byte][]byte) *Message {
+	hw, _ := net.ParseMAC(mac)
+	m := &Message{Op: bootRequest, XID: 42, CHAddr: hw, Options: map[byte][]byte{OptMessageType: {typ}}}
+	for k, v := range opts {
+		m.Options[k] = v
+	}
+	return m
+}
+
+// bind runs a client through discover and request and returns the acked address.
+func bind(t *testing.T, s *Server, mac string) netip.Addr {
+	t.Helper()
+	offer := s.Handle(request(Discover, mac, nil))
+	if offer == nil || offer.Type() != Offer {
+		t.Fatalf("expected an offer for %s, got %v", mac, offer)
+	}
+	ack := s.Handle(request(Request, mac, map[byte][]byte{
+		OptRequestedIP: addrsOption(offer.YIAddr),
+		OptServerID:    addrsOption(netip.MustParseAddr("10.10.0.1")),
+	}))
+	if ack == nil || ack.Type() != Ack {
+		t.Fatalf("expected an ack for %s, got %v", mac, ack)
+	}
+	if ack.YIAddr != offer.YIAddr {
+		t.Errorf("expected ack for %s, got %s", offer.YIAddr, ack.YIAddr)
+	}
+	return ack.YIAddr
+}
+
+func TestServer_reservation(t *testing.T) {
+	s := testServer(t)
+	ip := bind(t, s, "52:54:00:00:00:01")
+	if ip != netip.MustParseAddr("10.10.0.11") {
+		t.Errorf("expected the reservation of r1, got %s", ip)
+	}
+	leases := s.Leases()
+	if len(leases) != 1 || leases[0].VM != "r1" || leases[0].Offered {
+		t.Errorf("expected a lease for r1, got %+v", leases)
+	}
+}
+
+func TestServer_reservationPerInterface(t *testing.T) {
+	s := testServer(t)
+	if ip := bind(t, s, "52:54:00:00:00:01"); ip != netip.MustParseAddr("10.10.0.11") {
+		t.Errorf("expected the reservation of r1, got %s", ip)
+	}
+	if ip := bind(t, s, "52:54:00:01:00:01"); !netip.MustParsePrefix("10.10.0.96/28").Contains(ip) {
+		t.Errorf("expected an address from the range for net1 of r1, got %s", ip)
+	}
+	if ip := bind(t, s, "52:54:00:01:00:02"); ip != netip.MustParseAddr("10.10.0.22") {
+		t.Errorf("expected the reservation of r2:net1, got %s", ip)
+	}
+	if ip := bind(t, s, "52:54:00:00:00:02"); !netip.MustParsePrefix("10.10.0.96/28").Contains(ip) {
+		t.Errorf("expected an address from the range for net0 of r2, got %s", ip)
+	}
+}
+
+func TestServer_deterministic(t *testing.T) {
+	ip := bind(t, testServer(t), "52:54:00:00:00:02")
+	again := bind(t, testServer(t), "52:54:00:00:00:02")
+	if ip != again {
+		t.Errorf("expected the same address on a new server, got %s and %s", ip, again)
+	}
+	unknown := bind(t, testServer(t), "52:54:00:00:00:99")
+	if !netip.MustParsePrefix("10.10.0.96/28").Contains(unknown) {
+		t.Errorf("expected an address from the range, got %s", unknown)
+	}
+}
+
+func TestServer_noDuplicates(t *testing.T) {
+	s := testServer(t)
+	seen := make(map[netip.Addr]bool)
+	for i := 0; i < 10; i++ {
+		ip := bind(t, s, net.HardwareAddr{0x52, 0x54, 0, 0, 1, byte(i)}.String())
+		if seen[ip] {
+			t.Errorf("address %s handed out twice", ip)
+		}
+		seen[ip] = true
+	}
+	if offer := s.Handle(request(Discover, "52:54:00:00:02:00", nil)); offer != nil {
+		t.Errorf("expected no offer from an exhausted pool, got %s", offer.YIAddr)
+	}
+}
+
+func TestServer_nakAndRelease(t *testing.T) {
+	s := testServer(t)
+	nak := s.Handle(request(Request, "52:54:00:00:00:01", map[byte][]byte{
+		OptRequestedIP: addrsOption(netip.MustParseAddr("10.10.0.50")),
+	}))
+	if nak == nil || nak.Type() != Nak {
+		t.Fatalf("expected a nak, got %v", nak)
+	}
+	bind(t, s, "52:54:00:00:00:01")
+	changed := false
+	s.OnChange = func() { changed = true }
+	s.Handle(request(Release, "52:54:00:00:00:01", nil))
+	if !changed || len(s.Leases()) != 0 {
+		t.Errorf("expected the lease to be released, got %+v", s.Leases())
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	s := testServer(t)
+	s.Options = func(req *Message, l Lease) map[byte][]byte {
+		return map[byte][]byte{OptBootfile: []byte(l.VM + ".cfg")}
+	}
+	offer := s.Handle(request(Discover, "52:54:00:00:00:01", nil))
+	if got := string(offer.Options[OptBootfile]); got != "r1.cfg" {
+		t.Errorf("expected bootfile r1.cfg in offer, got %q", got)
+	}
+	if inform := s.Handle(request(Inform, "52:54:00:00:00:01", nil)); inform.Options[OptBootfile] != nil {
+		t.Errorf("expected no bootfile without a lease, got %q", inform.Options[OptBootfile])
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	c := Config{
+		ServerIP:   netip.MustParseAddr("10.10.0.1"),
+		Subnet:     netip.MustParsePrefix("10.10.0.0/24"),
+		RangeStart: netip.MustParseAddr("10.10.0.100"),
+		RangeEnd:   netip.MustParseAddr("10.10.1.10"),
+		LeaseTime:  time.Hour,
+	}
+	if err := c.Validate(); err == nil {
+		t.Errorf("expected error for range outside the subnet")
+	}
+	c.RangeEnd = netip.MustParseAddr("10.10.0.255")
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	c.Reservations = map[string]netip.Addr{"r1": netip.MustParseAddr("10.10.0.255")}
+	if err := c.Validate(); err == nil {
+		t.Errorf("expected error for a reservation of the broadcast address")
+	}
+}
+
+func TestServer_noBroadcast(t *testing.T) {
+	// the lowest bit of the network part of 10.10.0.0/15 is set
+	s, err := NewServer(Config{
+		ServerIP:   netip.MustParseAddr("10.10.0.1"),
+		Subnet:     netip.MustParsePrefix("10.10.0.0/15"),
+		RangeStart: netip.MustParseAddr("10.11.255.254"),
+		RangeEnd:   netip.MustParseAddr("10.11.255.255"),
+		LeaseTime:  time.Hour,
+	}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ip := bind(t, s, "52:54:00:00:00:01"); ip != netip.MustParseAddr("10.11.255.254") {
+		t.Errorf("expected 10.11.255.254, got %s", ip)
+	}
+	if offer := s.Handle(request(Discover, "52:54:00:00:00:02", nil)); offer != nil {
+		t.Errorf("expected no offer of the broadcast address, got %s", offer.YIAddr)
+	}
+}
@@ -0,0 +1,42 @@
+package dhcp6
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Listen opens the DHCPv6 server port bound to the interface and joins the
+// All_DHCP_Relay_Agents_and_Servers group on it. Port 547 needs CAP_NET_BIND_SERVICE.
+func Listen(ifname string) (net.PacketConn, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	group := syscall.IPv6Mreq{Interface: uint32(iface.Index)}
+	copy(group.Multiaddr[:], net.ParseIP("ff02::1:2"))
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+				if serr == nil {
+					serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				}
+				if serr == nil {
+					serr = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, &group)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp6", "[::]:547")
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", ifname, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp6
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func Listen(_ string) (net.PacketConn, error) {
+	return nil, fmt.Errorf("the dhcpv6 server is not supported on %s", runtime.GOOS)
+}
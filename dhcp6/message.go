@@ -0,0 +1,107 @@
+// Package dhcp6 is a small stateful DHCPv6 server (RFC 8415) for the management
+// bridges of a lab. The address of a client is derived from its MAC address,
+// so a VM gets the same address every time it boots.
+package dhcp6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Message types.
+const (
+	Solicit            = 1
+	Advertise          = 2
+	Request            = 3
+	Confirm            = 4
+	Renew              = 5
+	Rebind             = 6
+	Reply              = 7
+	Release            = 8
+	Decline            = 9
+	InformationRequest = 11
+)
+
+// Option codes used by the server.
+const (
+	OptClientID    = 1
+	OptServerID    = 2
+	OptIANA        = 3
+	OptIAAddr      = 5
+	OptPreference  = 7
+	OptStatusCode  = 13
+	OptRapidCommit = 14
+	OptDNSServers  = 23
+	OptDomainList  = 24
+)
+
+// Status codes.
+const (
+	statusSuccess   = 0
+	statusNoAddrs   = 2
+	statusNotOnLink = 4
+)
+
+// Message is a DHCPv6 client or server message. Of an option sent several times
+// only the first is kept, clients send a single IA_NA.
+type Message struct {
+	Type    byte
+	XID     [3]byte
+	Options map[uint16][]byte
+}
+
+// Parse decodes a message.
+func Parse(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("message too short, %d bytes", len(data))
+	}
+	m := &Message{Type: data[0], XID: [3]byte(data[1:4])}
+	var err error
+	m.Options, err = parseOptions(data[4:])
+	return m, err
+}
+
+func parseOptions(b []byte) (map[uint16][]byte, error) {
+	opts := make(map[uint16][]byte)
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("option truncated")
+		}
+		code, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return nil, fmt.Errorf("option %d truncated", code)
+		}
+		if _, ok := opts[code]; !ok {
+			opts[code] = b[4 : 4+n]
+		}
+		b = b[4+n:]
+	}
+	return opts, nil
+}
+
+// Marshal encodes the message with the options in order of their code.
+func (m *Message) Marshal() []byte {
+	b := []byte{m.Type, m.XID[0], m.XID[1], m.XID[2]}
+	return appendOptions(b, m.Options)
+}
+
+func appendOptions(b []byte, opts map[uint16][]byte) []byte {
+	codes := make([]int, 0, len(opts))
+	for code := range opts {
+		codes = append(codes, int(code))
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		v := opts[uint16(code)]
+		b = binary.BigEndian.AppendUint16(b, uint16(code))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+func statusOption(code uint16, msg string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), msg...)
+}
@@ -0,0 +1,311 @@
+package dhcp6
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the configuration of a server on one network.
+type Config struct {
+	// Prefix holds the addresses, the MAC address of a client fills the last 48 bits.
+	Prefix    netip.Prefix
+	DNS       []netip.Addr
+	Domains   []string
+	LeaseTime time.Duration
+}
+
+// Validate checks the configuration.
+func (c Config) Validate() error {
+	if !c.Prefix.IsValid() || !c.Prefix.Addr().Is6() || c.Prefix.Addr().Is4In6() {
+		return fmt.Errorf("prefix %s is not IPv6", c.Prefix)
+	}
+	if c.Prefix.Bits() > 80 {
+		return fmt.Errorf("prefix %s is longer than /80, no room for the MAC addresses", c.Prefix)
+	}
+	for _, a := range c.DNS {
+		if !a.Is6() || a.Is4In6() {
+			return fmt.Errorf("dns server %s is not IPv6", a)
+		}
+	}
+	if c.LeaseTime < time.Minute {
+		return fmt.Errorf("lease time %s too short", c.LeaseTime)
+	}
+	return nil
+}
+
+// Address returns the address of the MAC address in the prefix, for 52:54:00:12:34:56
+// in fd00:10::/64 that is fd00:10::5254:12:3456.
+func (c Config) Address(mac net.HardwareAddr) netip.Addr {
+	b := c.Prefix.Masked().Addr().As16()
+	copy(b[10:], mac)
+	return netip.AddrFrom16(b)
+}
+
+// Server hands out addresses on one network. Leases use the type of the DHCPv4
+// server so both tables read the same.
+type Server struct {
+	cfg    Config
+	duid   []byte
+	mu     sync.Mutex
+	leases map[string]*dhcp.Lease // by mac
+	now    func() time.Time
+	// VMName returns the name of the VM with the MAC address, the empty string if unknown.
+	VMName func(mac net.HardwareAddr) string
+	// OnChange is called after a lease was granted, renewed or released.
+	OnChange func()
+}
+
+// NewServer creates a server identified by the MAC address of its interface.
+// Leases from an earlier run may be passed in to keep them.
+func NewServer(cfg Config, mac net.HardwareAddr, leases []dhcp.Lease) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:    cfg,
+		duid:   append([]byte{0, 3, 0, 1}, mac...), // DUID-LL, ethernet
+		leases: make(map[string]*dhcp.Lease),
+		now:    time.Now,
+		VMName: func(net.HardwareAddr) string { return "" },
+	}
+	for _, l := range leases {
+		l := l
+		s.leases[l.MAC] = &l
+	}
+	return s, nil
+}
+
+// Leases returns the current leases sorted by address.
+func (s *Server) Leases() []dhcp.Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leases := make([]dhcp.Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		leases = append(leases, *l)
+	}
+	slices.SortFunc(leases, func(a, b dhcp.Lease) int { return a.IP.Compare(b.IP) })
+	return leases
+}
+
+// Serve answers requests on the connection until the context is cancelled.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dhcp6 read: %w", err)
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		req, err := Parse(buf[:n])
+		if err != nil {
+			log.Printf("dhcp6: bad message from %s: %v", from, err)
+			continue
+		}
+		ip, _ := netip.AddrFromSlice(addr.IP)
+		reply := s.Handle(req, ip)
+		if reply == nil {
+			continue
+		}
+		_, err = conn.WriteTo(reply.Marshal(), from)
+		if err != nil {
+			log.Printf("dhcp6: reply to %s: %v", from, err)
+		}
+	}
+}
+
+// Handle returns the reply to a request from the address, nil if there is nothing to answer.
+func (s *Server) Handle(req *Message, from netip.Addr) *Message {
+	clientID := req.Options[OptClientID]
+	if clientID == nil && req.Type != InformationRequest {
+		return nil
+	}
+	if id, ok := req.Options[OptServerID]; ok && !bytes.Equal(id, s.duid) {
+		return nil // for another server
+	}
+	s.mu.Lock()
+	reply, changed := s.handle(req, clientMAC(clientID, from))
+	s.mu.Unlock()
+	if changed && s.OnChange != nil {
+		s.OnChange()
+	}
+	return reply
+}
+
+func (s *Server) handle(req *Message, mac net.HardwareAddr) (*Message, bool) {
+	r := &Message{Type: Reply, XID: req.XID, Options: map[uint16][]byte{OptServerID: s.duid}}
+	if id := req.Options[OptClientID]; id != nil {
+		r.Options[OptClientID] = id
+	}
+	if len(s.cfg.DNS) > 0 {
+		var v []byte
+		for _, a := range s.cfg.DNS {
+			b := a.As16()
+			v = append(v, b[:]...)
+		}
+		r.Options[OptDNSServers] = v
+	}
+	if len(s.cfg.Domains) > 0 {
+		r.Options[OptDomainList] = domainList(s.cfg.Domains)
+	}
+	ia := req.Options[OptIANA]
+	switch req.Type {
+	case Solicit:
+		if _, ok := req.Options[OptServerID]; ok {
+			return nil, false
+		}
+		_, rapid := req.Options[OptRapidCommit]
+		if !rapid {
+			r.Type = Advertise
+			r.Options[OptPreference] = []byte{255}
+		} else {
+			r.Options[OptRapidCommit] = []byte{}
+		}
+		if ia == nil || len(ia) < 12 {
+			r.Options[OptStatusCode] = statusOption(statusNoAddrs, "only addresses are handed out")
+			return r, false
+		}
+		if mac == nil {
+			r.Options[OptIANA] = s.iaStatus(ia, statusNoAddrs, "no MAC address in the client id")
+			return r, false
+		}
+		r.Options[OptIANA] = s.iaAddress(ia, s.cfg.Address(mac))
+		if rapid {
+			s.bind(mac)
+		}
+		return r, rapid
+	case Request, Renew, Rebind:
+		if req.Type != Rebind && req.Options[OptServerID] == nil {
+			return nil, false
+		}
+		if ia == nil || len(ia) < 12 {
+			return r, false
+		}
+		if mac == nil {
+			r.Options[OptIANA] = s.iaStatus(ia, statusNoAddrs, "no MAC address in the client id")
+			return r, false
+		}
+		r.Options[OptIANA] = s.iaAddress(ia, s.cfg.Address(mac))
+		s.bind(mac)
+		return r, true
+	case Confirm:
+		for _, a := range iaAddresses(ia) {
+			if !s.cfg.Prefix.Contains(a) {
+				r.Options[OptStatusCode] = statusOption(statusNotOnLink, "not on link")
+				return r, false
+			}
+		}
+		r.Options[OptStatusCode] = statusOption(statusSuccess, "")
+		return r, false
+	case Release, Decline:
+		if req.Options[OptServerID] == nil {
+			return nil, false
+		}
+		r.Options[OptStatusCode] = statusOption(statusSuccess, "")
+		if mac == nil {
+			return r, false
+		}
+		_, ok := s.leases[mac.String()]
+		delete(s.leases, mac.String())
+		return r, ok
+	case InformationRequest:
+		return r, false
+	}
+	return nil, false
+}
+
+// bind records or renews the lease of the client.
+func (s *Server) bind(mac net.HardwareAddr) {
+	l, ok := s.leases[mac.String()]
+	if !ok {
+		l = &dhcp.Lease{MAC: mac.String(), IP: s.cfg.Address(mac)}
+		s.leases[l.MAC] = l
+	}
+	l.VM = s.VMName(mac)
+	l.Expires = s.now().Add(s.cfg.LeaseTime)
+}
+
+// iaAddress returns an IA_NA holding the address, for the IAID of the request.
+func (s *Server) iaAddress(reqIA []byte, a netip.Addr) []byte {
+	lease := uint32(s.cfg.LeaseTime / time.Second)
+	ia := append([]byte(nil), reqIA[:4]...)
+	ia = binary.BigEndian.AppendUint32(ia, lease/2)   // T1
+	ia = binary.BigEndian.AppendUint32(ia, lease/5*4) // T2
+	addr := a.As16()
+	v := append(addr[:], 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(v[16:], lease) // preferred
+	binary.BigEndian.PutUint32(v[20:], lease) // valid
+	return appendOptions(ia, map[uint16][]byte{OptIAAddr: v})
+}
+
+func (s *Server) iaStatus(reqIA []byte, code uint16, msg string) []byte {
+	ia := append([]byte(nil), reqIA[:4]...)
+	ia = append(ia, make([]byte, 8)...)
+	return appendOptions(ia, map[uint16][]byte{OptStatusCode: statusOption(code, msg)})
+}
+
+// iaAddresses returns the addresses in an IA_NA.
+func iaAddresses(ia []byte) []netip.Addr {
+	if len(ia) < 12 {
+		return nil
+	}
+	var addrs []netip.Addr
+	for b := ia[12:]; len(b) >= 4; {
+		code, n := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			break
+		}
+		if code == OptIAAddr && n >= 16 {
+			addrs = append(addrs, netip.AddrFrom16([16]byte(b[4:20])))
+		}
+		b = b[4+n:]
+	}
+	return addrs
+}
+
+// clientMAC returns the MAC address of a client, from a link-layer DUID or else
+// from the EUI-64 link-local address it sent from. It returns nil if there is none.
+func clientMAC(duid []byte, from netip.Addr) net.HardwareAddr {
+	switch {
+	case len(duid) == 14 && binary.BigEndian.Uint16(duid) == 1 && binary.BigEndian.Uint16(duid[2:]) == 1:
+		return net.HardwareAddr(slices.Clone(duid[8:])) // DUID-LLT
+	case len(duid) == 10 && binary.BigEndian.Uint16(duid) == 3 && binary.BigEndian.Uint16(duid[2:]) == 1:
+		return net.HardwareAddr(slices.Clone(duid[4:])) // DUID-LL
+	}
+	b := from.As16()
+	if from.Is6() && from.IsLinkLocalUnicast() && b[11] == 0xff && b[12] == 0xfe {
+		return net.HardwareAddr{b[8] ^ 0x02, b[9], b[10], b[13], b[14], b[15]}
+	}
+	return nil
+}
+
+func domainList(domains []string) []byte {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.Trim(d, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
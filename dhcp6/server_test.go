@@ -0,0 +1,129 @@
+package dhcp6
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var serverMAC, _ = net.ParseMAC("02:00:00:00:00:01")
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer(Config{
+		Prefix:    netip.MustParsePrefix("fd00:10::/64"),
+		DNS:       []netip.Addr{netip.MustParseAddr("fd00:10::1")},
+		Domains:   []string{"lab.internal"},
+		LeaseTime: time.Hour,
+	}, serverMAC, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	s.VMName = func(mac net.HardwareAddr) string {
+		if mac.String() == "52:54:00:12:34:56" {
+			return "r1"
+		}
+		return ""
+	}
+	return s
+}
+
+// duidLLT is the client id of 52:54:00:12:34:56.
+var duidLLT = []byte{0, 1, 0, 1, 0x2c, 0x1a, 0x2b, 0x3c, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+var linkLocal = netip.MustParseAddr("fe80::5054:ff:fe12:3456")
+
+func request(typ byte, opts map[uint16][]byte) *Message {
+	m := &Message{Type: typ, XID: [3]byte{1, 2, 3}, Options: map[uint16][]byte{
+		OptClientID: duidLLT,
+		OptIANA:     {0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0},
+	}}
+	for k, v := range opts {
+		m.Options[k] = v
+	}
+	// the server only sees what goes over the wire
+	m, _ = Parse(m.Marshal())
+	return m
+}
+
+func TestServer_solicitRequest(t *testing.T) {
+	s := testServer(t)
+	adv := s.Handle(request(Solicit, nil), linkLocal)
+	if adv == nil || adv.Type != Advertise || adv.XID != [3]byte{1, 2, 3} {
+		t.Fatalf("expected an advertise, got %+v", adv)
+	}
+	want := netip.MustParseAddr("fd00:10::5254:12:3456")
+	if got := iaAddresses(adv.Options[OptIANA]); len(got) != 1 || got[0] != want {
+		t.Errorf("expected %s, got %v", want, got)
+	}
+	if binary.BigEndian.Uint32(adv.Options[OptIANA]) != 7 {
+		t.Errorf("expected the iaid of the request, got %x", adv.Options[OptIANA][:4])
+	}
+	if len(s.Leases()) != 0 {
+		t.Errorf("expected no lease before the request, got %v", s.Leases())
+	}
+	changed := false
+	s.OnChange = func() { changed = true }
+	reply := s.Handle(request(Request, map[uint16][]byte{OptServerID: adv.Options[OptServerID]}), linkLocal)
+	if reply == nil || reply.Type != Reply || len(iaAddresses(reply.Options[OptIANA])) != 1 {
+		t.Fatalf("expected a reply with an address, got %+v", reply)
+	}
+	leases := s.Leases()
+	if !changed || len(leases) != 1 || leases[0].IP != want || leases[0].VM != "r1" {
+		t.Errorf("expected a lease of %s for r1, got %+v", want, leases)
+	}
+	s.Handle(request(Release, map[uint16][]byte{OptServerID: adv.Options[OptServerID]}), linkLocal)
+	if len(s.Leases()) != 0 {
+		t.Errorf("expected the lease to be released, got %v", s.Leases())
+	}
+}
+
+func TestServer_rapidCommit(t *testing.T) {
+	s := testServer(t)
+	reply := s.Handle(request(Solicit, map[uint16][]byte{OptRapidCommit: {}}), linkLocal)
+	if reply == nil || reply.Type != Reply || reply.Options[OptRapidCommit] == nil {
+		t.Fatalf("expected a rapid commit reply, got %+v", reply)
+	}
+	if len(s.Leases()) != 1 {
+		t.Errorf("expected a lease, got %v", s.Leases())
+	}
+}
+
+func TestServer_otherServer(t *testing.T) {
+	s := testServer(t)
+	other := []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 2}
+	if reply := s.Handle(request(Request, map[uint16][]byte{OptServerID: other}), linkLocal); reply != nil {
+		t.Errorf("expected no reply to a request for another server, got %+v", reply)
+	}
+}
+
+func TestServer_confirm(t *testing.T) {
+	s := testServer(t)
+	ia := []byte{0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0, 0, OptIAAddr, 0, 24}
+	ia = append(ia, netip.MustParseAddr("fd00:99::1").AsSlice()...)
+	ia = append(ia, make([]byte, 8)...)
+	reply := s.Handle(request(Confirm, map[uint16][]byte{OptIANA: ia}), linkLocal)
+	if reply == nil || binary.BigEndian.Uint16(reply.Options[OptStatusCode]) != statusNotOnLink {
+		t.Errorf("expected not on link, got %+v", reply)
+	}
+}
+
+func TestClientMAC(t *testing.T) {
+	tests := []struct {
+		duid []byte
+		from netip.Addr
+		want string
+	}{
+		{duidLLT, netip.MustParseAddr("fe80::1"), "52:54:00:12:34:56"},
+		{[]byte{0, 3, 0, 1, 0x52, 0x54, 0, 0xab, 0xcd, 0xef}, netip.MustParseAddr("fe80::1"), "52:54:00:ab:cd:ef"},
+		{[]byte{0, 2, 0, 0, 0, 9, 1, 2, 3}, linkLocal, "52:54:00:12:34:56"},
+		{[]byte{0, 2, 0, 0, 0, 9, 1, 2, 3}, netip.MustParseAddr("fe80::1"), ""},
+	}
+	for _, tt := range tests {
+		if got := clientMAC(tt.duid, tt.from).String(); got != tt.want {
+			t.Errorf("%x from %s: expected %q, got %q", tt.duid, tt.from, tt.want, got)
+		}
+	}
+}
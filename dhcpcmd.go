@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/ztp"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// dhcpConfig is the configuration of the DHCP server on a bridge.
+type dhcpConfig struct {
+	Bridge string `json:"bridge"`
+	// Server is the address of the server, by default the address of the bridge in the subnet.
+	Server    string   `json:"server,omitempty"`
+	Subnet    string   `json:"subnet"`
+	Range     []string `json:"range"` // first and last address of the pool
+	Router    string   `json:"router,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+	Domain    string   `json:"domain,omitempty"`
+	LeaseTime string   `json:"lease_time,omitempty"`
+	// Reservations are fixed addresses by VM name for net0, or by vm:iface.
+	Reservations map[string]string `json:"reservations,omitempty"`
+	// ZTP serves day-0 configurations to the VMs, optional.
+	ZTP *ztpConfig `json:"ztp,omitempty"`
+	// TFTP serves boot images and takes config backups, optional.
+	TFTP *tftpConfig `json:"tftp,omitempty"`
+	// Syslog receives the logs of the VMs, optional.
+	Syslog *syslogConfig `json:"syslog,omitempty"`
+	// DNSServer resolves the names of the VMs, optional.
+	DNSServer *dnsConfig `json:"dns_server,omitempty"`
+	// IPv6 sends router advertisements and runs DHCPv6, optional.
+	IPv6 *ipv6Config `json:"ipv6,omitempty"`
+	// Netflow collects the flows the VMs export, optional.
+	Netflow *netflowConfig `json:"netflow,omitempty"`
+}
+
+func (c dhcpConfig) server() (dhcp.Config, error) {
+	var cfg dhcp.Config
+	var err error
+	cfg.Subnet, err = netip.ParsePrefix(c.Subnet)
+	if err != nil {
+		return cfg, fmt.Errorf("subnet: %w", err)
+	}
+	if len(c.Range) != 2 {
+		return cfg, fmt.Errorf("range must be the first and last address")
+	}
+	cfg.RangeStart, err = netip.ParseAddr(c.Range[0])
+	if err != nil {
+		return cfg, fmt.Errorf("range: %w", err)
+	}
+	cfg.RangeEnd, err = netip.ParseAddr(c.Range[1])
+	if err != nil {
+		return cfg, fmt.Errorf("range: %w", err)
+	}
+	if c.Server != "" {
+		cfg.ServerIP, err = netip.ParseAddr(c.Server)
+	} else {
+		cfg.ServerIP, err = interfaceAddr(c.Bridge, cfg.Subnet)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("server: %w", err)
+	}
+	if c.Router != "" {
+		cfg.Router, err = netip.ParseAddr(c.Router)
+		if err != nil {
+			return cfg, fmt.Errorf("router: %w", err)
+		}
+	}
+	for _, s := range c.DNS {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return cfg, fmt.Errorf("dns: %w", err)
+		}
+		cfg.DNS = append(cfg.DNS, a)
+	}
+	cfg.Domain = c.Domain
+	cfg.LeaseTime = time.Hour
+	if c.LeaseTime != "" {
+		cfg.LeaseTime, err = time.ParseDuration(c.LeaseTime)
+		if err != nil {
+			return cfg, fmt.Errorf("lease time: %w", err)
+		}
+	}
+	cfg.Reservations = make(map[string]netip.Addr)
+	for vm, s := range c.Reservations {
+		cfg.Reservations[vm], err = netip.ParseAddr(s)
+		if err != nil {
+			return cfg, fmt.Errorf("reservation of %s: %w", vm, err)
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// interfaceAddr returns the address of the interface in the subnet.
+func interfaceAddr(ifname string, subnet netip.Prefix) (netip.Addr, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("addresses of %s: %w", ifname, err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			ip, ok := netip.AddrFromSlice(ipnet.IP)
+			if ok && subnet.Contains(ip.Unmap()) {
+				return ip.Unmap(), nil
+			}
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%s has no address in %s", ifname, subnet)
+}
+
+// runDHCP runs the DHCP server on a bridge until the context is cancelled.
+func runDHCP(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dhcp", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: dhcp <config.json>")
+	}
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read dhcp config: %w", err)
+	}
+	var c dhcpConfig
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return fmt.Errorf("parse dhcp config: %w", err)
+	}
+	cfg, err := c.server()
+	if err != nil {
+		return err
+	}
+	leaseFile, err := leasePath(c.Bridge)
+	if err != nil {
+		return err
+	}
+	leases, err := loadLeases(leaseFile)
+	if err != nil {
+		return err
+	}
+	srv, err := dhcp.NewServer(cfg, leases)
+	if err != nil {
+		return err
+	}
+	srv.VMName = vmByMAC
+	srv.Interface = ifaceByMAC
+	srv.OnChange = func() {
+		if err := saveLeases(leaseFile, srv.Leases()); err != nil {
+			log.Printf("dhcp: %v", err)
+		}
+	}
+	var z *ztp.Server
+	if c.ZTP != nil {
+		z, err = startZTP(ctx, *c.ZTP, c.Bridge, srv, cfg.ServerIP)
+		if err != nil {
+			return err
+		}
+	}
+	if c.TFTP != nil || z != nil && z.Boot == "tftp" {
+		err = startTFTP(ctx, c.TFTP, srv, cfg.ServerIP, z)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Syslog != nil {
+		err = startSyslog(ctx, *c.Syslog, srv, cfg.ServerIP)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Netflow != nil {
+		err = startNetflow(ctx, *c.Netflow, srv, cfg.ServerIP)
+		if err != nil {
+			return err
+		}
+	}
+	allLeases := srv.Leases
+	if c.IPv6 != nil {
+		srv6, err := startIPv6(ctx, *c.IPv6, c.Bridge, c.Domain)
+		if err != nil {
+			return err
+		}
+		if srv6 != nil {
+			allLeases = func() []dhcp.Lease { return append(srv.Leases(), srv6.Leases()...) }
+		}
+	}
+	if c.DNSServer != nil {
+		err = startDNS(ctx, *c.DNSServer, allLeases, cfg.ServerIP)
+		if err != nil {
+			return err
+		}
+	}
+	conn, err := dhcp.Listen(c.Bridge)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("DHCP server %s on %s, leases in %s\n", cfg.ServerIP, c.Bridge, leaseFile)
+	return srv.Serve(ctx, conn)
+}
+
+// vmByMAC returns the name of the running VM with the MAC address on one of its NICs.
+func vmByMAC(mac net.HardwareAddr) string {
+	vm, _ := nicByMAC(mac)
+	return vm
+}
+
+// ifaceByMAC returns the interface id of the running VM's NIC with the MAC address.
+func ifaceByMAC(mac net.HardwareAddr) string {
+	_, iface := nicByMAC(mac)
+	return iface
+}
+
+func nicByMAC(mac net.HardwareAddr) (vm, iface string) {
+	states, err := listStates()
+	if err != nil {
+		log.Printf("dhcp: %v", err)
+		return "", ""
+	}
+	for _, st := range states {
+		for id, m := range st.MACs {
+			if strings.EqualFold(m, mac.String()) {
+				return st.Name, id
+			}
+		}
+	}
+	return "", ""
+}
+
+func leasePath(bridge string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, bridge+".leases"), nil
+}
+
+func loadLeases(filename string) ([]dhcp.Lease, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read leases: %w", err)
+	}
+	var leases []dhcp.Lease
+	err = json.Unmarshal(data, &leases)
+	if err != nil {
+		return nil, fmt.Errorf("parse leases %s: %w", filename, err)
+	}
+	return leases, nil
+}
+
+// saveLeases replaces the lease file, readers never see a partial file.
+func saveLeases(filename string, leases []dhcp.Lease) error {
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal leases: %w", err)
+	}
+	tmp := filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("write leases: %w", err)
+	}
+	return os.Rename(tmp, filename)
+}
+
+// runLeases prints the lease table of a bridge, or of all bridges with a DHCP server,
+// the DHCPv6 leases after the DHCPv4 ones.
+// With -vm only the addresses of that VM are printed, for use in scripts.
+func runLeases(args []string) error {
+	flags := flag.NewFlagSet("leases", flag.ContinueOnError)
+	vm := flags.String("vm", "", "print only the addresses of this VM")
+	v6 := flags.Bool("6", false, "with -vm, print the DHCPv6 addresses instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: leases [-vm name [-6]] [bridge]")
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	bridge := "*"
+	if flags.NArg() == 1 {
+		bridge = flags.Arg(0)
+	}
+	var files []string
+	for _, ext := range []string{".leases", ".leases6"} {
+		matches, err := filepath.Glob(filepath.Join(dir, bridge+ext))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no dhcp leases in %s", dir)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *vm == "" {
+		_, _ = fmt.Fprintln(w, "BRIDGE\tIP\tMAC\tVM\tHOSTNAME\tEXPIRES")
+	}
+	found := false
+	for _, f := range files {
+		if *vm != "" && *v6 != strings.HasSuffix(f, "6") {
+			continue
+		}
+		leases, err := loadLeases(f)
+		if err != nil {
+			return err
+		}
+		bridge := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f), "6"), ".leases")
+		for _, l := range leases {
+			switch {
+			case l.Offered:
+			case *vm == "":
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", bridge, l.IP, l.MAC, dash(l.VM), dash(l.Hostname), l.Expires.Format(time.DateTime))
+			case l.VM == *vm:
+				found = true
+				_, _ = fmt.Fprintln(w, l.IP)
+			}
+		}
+	}
+	if *vm != "" && !found {
+		return fmt.Errorf("%s has no lease", *vm)
+	}
+	return w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
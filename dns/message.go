@@ -0,0 +1,148 @@
+// Package dns is an authoritative DNS server for the zone of a lab, answering
+// the addresses of the VMs and the reverse lookups of those addresses.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Record types.
+const (
+	TypeA    = 1
+	TypeNS   = 2
+	TypeSOA  = 6
+	TypePTR  = 12
+	TypeAAAA = 28
+	TypeANY  = 255
+	classIN  = 1
+)
+
+// Response codes.
+const (
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+)
+
+const headerLength = 12
+
+// Question is the question of a query.
+type Question struct {
+	Name  string // lower case, without the trailing dot
+	Type  uint16
+	Class uint16
+}
+
+// record is a resource record with its data already encoded.
+type record struct {
+	name string
+	typ  uint16
+	ttl  uint32
+	data []byte
+}
+
+// parseQuery returns the id, the flags and the question of a query.
+func parseQuery(msg []byte) (uint16, uint16, Question, error) {
+	if len(msg) < headerLength {
+		return 0, 0, Question{}, errors.New("short message")
+	}
+	id := binary.BigEndian.Uint16(msg)
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return id, flags, Question{}, errors.New("expected one question")
+	}
+	name, off, err := readName(msg, headerLength)
+	if err != nil {
+		return id, flags, Question{}, err
+	}
+	if off+4 > len(msg) {
+		return id, flags, Question{}, errors.New("truncated question")
+	}
+	q := Question{
+		Name:  strings.ToLower(name),
+		Type:  binary.BigEndian.Uint16(msg[off:]),
+		Class: binary.BigEndian.Uint16(msg[off+2:]),
+	}
+	return id, flags, q, nil
+}
+
+// readName reads a name at off, following compression pointers, and returns the
+// offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad compression pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case n > 63 || off+1+n > len(msg):
+			return "", 0, fmt.Errorf("bad label length %d", n)
+		default:
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// response encodes the answer to a query. It is marked truncated if it does not
+// fit in limit bytes, then the records are left out.
+func response(id, flags uint16, q Question, rcode uint16, answers, authority []record, limit int) []byte {
+	// QR, the opcode and RD of the query, AA
+	flags = 0x8000 | flags&0x7900 | 0x0400 | rcode
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(answers)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(authority)))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = appendName(b, q.Name)
+	b = binary.BigEndian.AppendUint16(b, q.Type)
+	b = binary.BigEndian.AppendUint16(b, q.Class)
+	header := len(b)
+	for _, r := range append(answers, authority...) {
+		b = appendName(b, r.name)
+		b = binary.BigEndian.AppendUint16(b, r.typ)
+		b = binary.BigEndian.AppendUint16(b, classIN)
+		b = binary.BigEndian.AppendUint32(b, r.ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(r.data)))
+		b = append(b, r.data...)
+	}
+	if len(b) > limit {
+		b = b[:header]
+		binary.BigEndian.PutUint16(b[2:], flags|0x0200)
+		binary.BigEndian.PutUint32(b[6:], 0)
+	}
+	return b
+}
@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	udpLimit = 512
+	tcpLimit = 65535
+)
+
+// Server answers for one zone. Names outside the zone are refused, there is no recursion.
+type Server struct {
+	Zone string
+	TTL  uint32
+	// Hosts returns the addresses by host name, without the zone, in lower case.
+	// It is called for every query, so the answers follow the VMs as they come and go.
+	Hosts func() map[string][]netip.Addr
+}
+
+// NewServer returns a server for the zone.
+func NewServer(zone string, hosts func() map[string][]netip.Addr) *Server {
+	return &Server{Zone: strings.ToLower(strings.Trim(zone, ".")), TTL: 60, Hosts: hosts}
+}
+
+// ServeUDP answers queries on the connection until the context is cancelled.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dns read: %w", err)
+		}
+		reply := s.Answer(buf[:n], udpLimit)
+		if reply == nil {
+			continue
+		}
+		_, err = conn.WriteTo(reply, from)
+		if err != nil {
+			log.Printf("dns: reply to %s: %v", from, err)
+		}
+	}
+}
+
+// ServeTCP answers queries on the connections until the context is cancelled.
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("dns accept: %w", err)
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers length prefixed queries until the client is done.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		reply := s.Answer(msg, tcpLimit)
+		if reply == nil {
+			return
+		}
+		reply = append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...)
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// Answer returns the response to a query of at most limit bytes, nil if the message
+// is not worth answering.
+func (s *Server) Answer(msg []byte, limit int) []byte {
+	id, flags, q, err := parseQuery(msg)
+	if len(msg) < headerLength || flags&0x8000 != 0 {
+		return nil // too short to answer, or a response
+	}
+	if err != nil {
+		return response(id, flags, q, rcodeFormErr, nil, nil, limit)
+	}
+	if flags&0x7800 != 0 {
+		return response(id, flags, q, rcodeNotImp, nil, nil, limit)
+	}
+	rcode, answers := s.lookup(q)
+	var authority []record
+	if rcode == rcodeNXDomain || rcode == rcodeSuccess && len(answers) == 0 {
+		authority = []record{s.soa()}
+	}
+	return response(id, flags, q, rcode, answers, authority, limit)
+}
+
+// lookup returns the response code and the answers to a question.
+func (s *Server) lookup(q Question) (uint16, []record) {
+	if q.Class != classIN && q.Class != TypeANY {
+		return rcodeRefused, nil
+	}
+	if ip, ok := reverseAddr(q.Name); ok {
+		for host, addrs := range s.Hosts() {
+			if slices.Contains(addrs, ip) {
+				if q.Type != TypePTR && q.Type != TypeANY {
+					return rcodeSuccess, nil
+				}
+				return rcodeSuccess, []record{{q.Name, TypePTR, s.TTL, appendName(nil, s.fqdn(host))}}
+			}
+		}
+		return rcodeNXDomain, nil
+	}
+	if q.Name == s.Zone {
+		if q.Type == TypeSOA || q.Type == TypeANY {
+			return rcodeSuccess, []record{s.soa()}
+		}
+		return rcodeSuccess, nil
+	}
+	host, ok := strings.CutSuffix(q.Name, "."+s.Zone)
+	if !ok {
+		return rcodeRefused, nil
+	}
+	addrs, ok := s.Hosts()[host]
+	if !ok {
+		return rcodeNXDomain, nil
+	}
+	var answers []record
+	for _, a := range addrs {
+		switch {
+		case a.Is4() && (q.Type == TypeA || q.Type == TypeANY):
+			b := a.As4()
+			answers = append(answers, record{q.Name, TypeA, s.TTL, b[:]})
+		case a.Is6() && (q.Type == TypeAAAA || q.Type == TypeANY):
+			b := a.As16()
+			answers = append(answers, record{q.Name, TypeAAAA, s.TTL, b[:]})
+		}
+	}
+	return rcodeSuccess, answers
+}
+
+func (s *Server) fqdn(host string) string {
+	return host + "." + s.Zone
+}
+
+// soa returns the start of authority of the zone. The serial changes every
+// minute, nobody transfers the zone.
+func (s *Server) soa() record {
+	data := appendName(nil, "ns."+s.Zone)
+	data = appendName(data, "hostmaster."+s.Zone)
+	data = binary.BigEndian.AppendUint32(data, uint32(time.Now().Unix()/60))
+	for _, v := range []uint32{3600, 600, 86400, s.TTL} { // refresh, retry, expire, negative ttl
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	return record{s.Zone, TypeSOA, s.TTL, data}
+}
+
+// reverseAddr returns the address of a name in in-addr.arpa or ip6.arpa.
+func reverseAddr(name string) (netip.Addr, bool) {
+	if rest, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(rest, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+		slices.Reverse(labels)
+		a, err := netip.ParseAddr(strings.Join(labels, "."))
+		return a, err == nil
+	}
+	if rest, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(rest, ".")
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+		var b [16]byte
+		for i, n := range nibbles {
+			v, err := strconv.ParseUint(n, 16, 4)
+			if err != nil || len(n) != 1 {
+				return netip.Addr{}, false
+			}
+			b[15-i/2] |= byte(v) << (4 * (i % 2))
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
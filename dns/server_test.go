@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func testServer() *Server {
+	return NewServer("Lab.Internal.", func() map[string][]netip.Addr {
+		return map[string][]netip.Addr{
+			"r1": {netip.MustParseAddr("10.10.0.11"), netip.MustParseAddr("fd00:10::5054:ff:fe12:3456")},
+			"r2": {netip.MustParseAddr("10.10.0.12")},
+		}
+	})
+}
+
+func query(name string, qtype uint16) []byte {
+	b := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	b = appendName(b, name)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, classIN)
+}
+
+// parsed is the part of a response the tests look at.
+type parsed struct {
+	rcode     uint16
+	answers   int
+	authority int
+	data      []byte // of the first answer
+}
+
+func parse(t *testing.T, msg []byte) parsed {
+	t.Helper()
+	if len(msg) < headerLength || binary.BigEndian.Uint16(msg) != 0x1234 {
+		t.Fatalf("expected a response to query 0x1234, got %x", msg)
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8400 != 0x8400 || flags&0x0100 == 0 {
+		t.Errorf("expected an authoritative response with rd copied, got flags %04x", flags)
+	}
+	p := parsed{rcode: flags & 0xf, answers: int(binary.BigEndian.Uint16(msg[6:])), authority: int(binary.BigEndian.Uint16(msg[8:]))}
+	_, off, err := readName(msg, headerLength)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	off += 4
+	if p.answers > 0 {
+		_, off, err = readName(msg, off)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		p.data = msg[off+10 : off+10+length]
+	}
+	return p
+}
+
+func TestServer_Answer(t *testing.T) {
+	s := testServer()
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   uint16
+		answers int
+		data    []byte
+	}{
+		{"r1.lab.internal", TypeA, rcodeSuccess, 1, []byte{10, 10, 0, 11}},
+		{"R2.LAB.internal.", TypeA, rcodeSuccess, 1, []byte{10, 10, 0, 12}},
+		{"r1.lab.internal", TypeAAAA, rcodeSuccess, 1, netip.MustParseAddr("fd00:10::5054:ff:fe12:3456").AsSlice()},
+		{"r1.lab.internal", TypeANY, rcodeSuccess, 2, []byte{10, 10, 0, 11}},
+		{"r2.lab.internal", TypeAAAA, rcodeSuccess, 0, nil},
+		{"r3.lab.internal", TypeA, rcodeNXDomain, 0, nil},
+		{"lab.internal", TypeSOA, rcodeSuccess, 1, nil},
+		{"11.0.10.10.in-addr.arpa", TypePTR, rcodeSuccess, 1, appendName(nil, "r1.lab.internal")},
+		{"6.5.4.3.2.1.e.f.f.f.0.0.4.5.0.5.0.0.0.0.0.0.0.0.0.1.0.0.0.0.d.f.ip6.arpa", TypePTR, rcodeSuccess, 1, appendName(nil, "r1.lab.internal")},
+		{"99.0.10.10.in-addr.arpa", TypePTR, rcodeNXDomain, 0, nil},
+		{"example.com", TypeA, rcodeRefused, 0, nil},
+	}
+	for _, tt := range tests {
+		p := parse(t, s.Answer(query(tt.name, tt.qtype), udpLimit))
+		if p.rcode != tt.rcode || p.answers != tt.answers {
+			t.Errorf("%s %d: expected rcode %d with %d answers, got %d with %d", tt.name, tt.qtype, tt.rcode, tt.answers, p.rcode, p.answers)
+		}
+		if tt.data != nil && string(p.data) != string(tt.data) {
+			t.Errorf("%s %d: expected %x, got %x", tt.name, tt.qtype, tt.data, p.data)
+		}
+		if tt.answers == 0 && tt.rcode != rcodeRefused && p.authority != 1 {
+			t.Errorf("%s %d: expected the soa in the authority section, got %d records", tt.name, tt.qtype, p.authority)
+		}
+	}
+}
+
+func TestServer_truncated(t *testing.T) {
+	s := testServer()
+	msg := s.Answer(query("r1.lab.internal", TypeANY), 40)
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x0200 == 0 || binary.BigEndian.Uint16(msg[6:]) != 0 {
+		t.Errorf("expected a truncated response without answers, got %x", msg)
+	}
+}
+
+func TestServer_ServeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = testServer().ServeTCP(ctx, ln) }()
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	q := query("r2.lab.internal", TypeA)
+	_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(q))), q...))
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if p := parse(t, msg); string(p.data) != string([]byte{10, 10, 0, 12}) {
+		t.Errorf("expected 10.10.0.12, got %x", p.data)
+	}
+}
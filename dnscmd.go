@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/dns"
+	"log"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dnsConfig enables the DNS server for the VMs on the bridge of a DHCP server.
+type dnsConfig struct {
+	Zone string `json:"zone"`
+	// Listen are the addresses to answer on, by default the server address and 127.0.0.1.
+	Listen []string `json:"listen,omitempty"`
+	Port   int      `json:"port,omitempty"` // 53 by default
+	// IPv6Prefix gives the VMs AAAA records, the SLAAC address (EUI-64) of the MAC in the prefix.
+	IPv6Prefix string `json:"ipv6_prefix,omitempty"`
+}
+
+// hostLabel is what a DHCP client may call itself in the zone.
+var hostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// startDNS answers for the zone until the context is cancelled. The VMs that are
+// running and have a lease are in it by name, other clients by the host name they sent.
+func startDNS(ctx context.Context, c dnsConfig, leases func() []dhcp.Lease, serverIP netip.Addr) error {
+	if c.Zone == "" {
+		return fmt.Errorf("dns needs a zone")
+	}
+	if c.Port == 0 {
+		c.Port = 53
+	}
+	if len(c.Listen) == 0 {
+		c.Listen = []string{serverIP.String(), "127.0.0.1"}
+	}
+	var prefix netip.Prefix
+	if c.IPv6Prefix != "" {
+		var err error
+		prefix, err = netip.ParsePrefix(c.IPv6Prefix)
+		if err != nil || !prefix.Addr().Is6() || prefix.Bits() != 64 {
+			return fmt.Errorf("dns ipv6 prefix %q is not an IPv6 /64", c.IPv6Prefix)
+		}
+	}
+	s := dns.NewServer(c.Zone, func() map[string][]netip.Addr { return dnsHosts(leases(), prefix) })
+	for _, a := range c.Listen {
+		addr := net.JoinHostPort(a, strconv.Itoa(c.Port))
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("dns listen: %w", err)
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("dns listen: %w", err)
+		}
+		go func() {
+			if err := s.ServeUDP(ctx, conn); err != nil {
+				log.Printf("dns: %v", err)
+			}
+		}()
+		go func() {
+			if err := s.ServeTCP(ctx, ln); err != nil {
+				log.Printf("dns: %v", err)
+			}
+		}()
+	}
+	fmt.Printf("DNS server for %s on %s port %d\n", c.Zone, strings.Join(c.Listen, ", "), c.Port)
+	return nil
+}
+
+// dnsHosts returns the addresses by host name from the leases.
+func dnsHosts(leases []dhcp.Lease, prefix netip.Prefix) map[string][]netip.Addr {
+	running := make(map[string]bool)
+	states, err := listStates()
+	if err != nil {
+		log.Printf("dns: %v", err)
+	}
+	for _, st := range states {
+		running[st.Name] = true
+	}
+	hosts := make(map[string][]netip.Addr)
+	now := time.Now()
+	for _, l := range leases {
+		name := strings.ToLower(l.VM)
+		if l.VM == "" {
+			name = strings.ToLower(l.Hostname)
+		}
+		if l.Offered || l.Expires.Before(now) || !hostLabel.MatchString(name) || l.VM != "" && !running[l.VM] {
+			continue
+		}
+		hosts[name] = append(hosts[name], l.IP)
+		if mac, err := net.ParseMAC(l.MAC); err == nil && prefix.IsValid() && l.IP.Is4() {
+			hosts[name] = append(hosts[name], eui64(prefix, mac))
+		}
+	}
+	return hosts
+}
+
+// eui64 returns the SLAAC address of the MAC address in a /64 (RFC 4291 appendix A).
+func eui64(prefix netip.Prefix, mac net.HardwareAddr) netip.Addr {
+	b := prefix.Masked().Addr().As16()
+	b[8], b[9], b[10] = mac[0]^0x02, mac[1], mac[2]
+	b[11], b[12] = 0xff, 0xfe
+	b[13], b[14], b[15] = mac[3], mac[4], mac[5]
+	return netip.AddrFrom16(b)
+}
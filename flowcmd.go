@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/netflow"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const flowSuffix = ".flows.jsonl"
+
+// netflowConfig enables the flow collector on the bridge of a DHCP server.
+type netflowConfig struct {
+	Port int `json:"port,omitempty"` // 2055 by default, for all versions
+}
+
+// startNetflow collects flows on the server address until the context is cancelled,
+// and appends them as JSON lines to a file per exporting VM.
+func startNetflow(ctx context.Context, c netflowConfig, srv *dhcp.Server, serverIP netip.Addr) error {
+	if c.Port == 0 {
+		c.Port = 2055
+	}
+	addr := net.JoinHostPort(serverIP.String(), strconv.Itoa(c.Port))
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("netflow listen: %w", err)
+	}
+	files := newVMFiles(flowSuffix)
+	collector := netflow.NewCollector()
+	collector.OnFlow = func(f netflow.Flow) {
+		data, err := json.Marshal(f)
+		if err == nil {
+			err = files.write(leaseName(srv, f.Exporter), string(data))
+		}
+		if err != nil {
+			log.Printf("netflow: %v", err)
+		}
+	}
+	go func() {
+		if err := collector.Serve(ctx, conn); err != nil {
+			log.Printf("netflow: %v", err)
+		}
+		files.close()
+	}()
+	fmt.Printf("flow collector on %s\n", addr)
+	return nil
+}
+
+// talker is the traffic of a group of flows.
+type talker struct {
+	key     string
+	bytes   uint64
+	packets uint64
+	flows   int
+}
+
+// runFlows summarises the flows exported by a VM, the top talkers by bytes.
+func runFlows(args []string) error {
+	flags := flag.NewFlagSet("flows", flag.ContinueOnError)
+	top := flags.Int("n", 10, "number of talkers to print")
+	since := flags.Duration("since", 0, "only flows exported in this period, all if 0")
+	by := flags.String("by", "pair", "group by pair (source and destination), src or dst")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: flows [-n talkers] [-since duration] [-by pair|src|dst] <vm>")
+	}
+	if *top < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	key, ok := map[string]func(f netflow.Flow) string{
+		"pair": func(f netflow.Flow) string { return fmt.Sprintf("%s\t%s\t%s", f.Src, f.Dst, protoName(f.Proto)) },
+		"src":  func(f netflow.Flow) string { return f.Src.String() },
+		"dst":  func(f netflow.Flow) string { return f.Dst.String() },
+	}[*by]
+	if !ok {
+		return fmt.Errorf("cannot group by %q", *by)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	vm := flags.Arg(0)
+	file, err := os.Open(filepath.Join(dir, vm+flowSuffix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no flows from %s in %s", vm, dir)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	talkers := make(map[string]*talker)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var f netflow.Flow
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			continue
+		}
+		if *since > 0 && time.Since(f.Time) > *since {
+			continue
+		}
+		k := key(f)
+		t, ok := talkers[k]
+		if !ok {
+			t = &talker{key: k}
+			talkers[k] = t
+		}
+		t.bytes += f.Bytes
+		t.packets += f.Packets
+		t.flows++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	sorted := make([]*talker, 0, len(talkers))
+	for _, t := range talkers {
+		sorted = append(sorted, t)
+	}
+	slices.SortFunc(sorted, func(a, b *talker) int {
+		if a.bytes != b.bytes {
+			return cmp.Compare(b.bytes, a.bytes)
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := map[string]string{"pair": "SRC\tDST\tPROTO", "src": "SRC", "dst": "DST"}[*by]
+	_, _ = fmt.Fprintf(w, "%s\tBYTES\tPACKETS\tFLOWS\n", header)
+	for _, t := range sorted[:min(*top, len(sorted))] {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", t.key, t.bytes, t.packets, t.flows)
+	}
+	return w.Flush()
+}
+
+func protoName(p uint8) string {
+	switch p {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "icmpv6"
+	}
+	return strconv.Itoa(int(p))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/portfwd"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardConfig declares a TCP forward from a host port to the management address
+// of the VM, for reaching its services from other machines through the host.
+type forwardConfig struct {
+	Name string `json:"name"`
+	// Port is the port of the service in the VM.
+	Port uint16 `json:"port"`
+	// HostPort is allocated if zero, near the same port every time.
+	HostPort uint16 `json:"host_port,omitempty"`
+	// Listen is the host address, all addresses if empty.
+	Listen string `json:"listen,omitempty"`
+	// Address is the management address of the VM. If empty it is looked up per
+	// connection in the DHCP leases of the bridge, by the MAC address of net0.
+	Address string `json:"address,omitempty"`
+}
+
+// startForwards listens on the host ports of the forwards from the config and
+// relays connections to the VM until it stops.
+func (r *Runner) startForwards(ctx context.Context) error {
+	if len(r.config.Forwards) == 0 {
+		return nil
+	}
+	if r.backend != "tap" {
+		return fmt.Errorf("forwards need tap networking, network is %s", r.backend)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	r.addCleanup(func() error {
+		cancel()
+		wg.Wait()
+		return nil
+	})
+	for _, c := range r.config.Forwards {
+		port := c.HostPort
+		if port == 0 {
+			var err error
+			port, err = allocateHostPort(r.firmware + "forward " + c.Name)
+			if err != nil {
+				return fmt.Errorf("allocating port for %s: %w", c.Name, err)
+			}
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(c.Listen, strconv.Itoa(int(port))))
+		if err != nil {
+			return fmt.Errorf("forward %s: %w", c.Name, err)
+		}
+		f := portfwd.New(net.JoinHostPort(c.Address, strconv.Itoa(int(c.Port))))
+		if c.Address == "" {
+			f.Target = func() (string, error) {
+				ip, err := r.leaseIP()
+				if err != nil {
+					return "", fmt.Errorf("%s: %w", c.Name, err)
+				}
+				return netip.AddrPortFrom(ip, c.Port).String(), nil
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f.Serve(ctx, ln); err != nil {
+				log.Printf("forward %s: %v", c.Name, err)
+			}
+		}()
+		hostAddr := c.Listen
+		if hostAddr == "" {
+			hostAddr = "0.0.0.0"
+		}
+		r.forwards = append(r.forwards, forward{
+			Name:      c.Name,
+			HostAddr:  hostAddr,
+			HostPort:  port,
+			GuestAddr: c.Address,
+			GuestPort: c.Port,
+			Relayed:   true,
+		})
+	}
+	return nil
+}
+
+// leaseIP returns the address the DHCP server of the bridge has given the VM.
+func (r *Runner) leaseIP() (netip.Addr, error) {
+	filename, err := leasePath(r.bridge)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	leases, err := loadLeases(filename)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, l := range leases {
+		if strings.EqualFold(l.MAC, r.mac) && !l.Offered && l.Expires.After(time.Now()) {
+			return l.IP, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%s has no lease on %s", r.name, r.bridge)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+)
+
+// applyImpairments applies the impairments from the config to the taps of the VM.
+// They are cleared on teardown, before the taps go away.
+func (r *Runner) applyImpairments() error {
+	for iface, c := range r.config.Impairments {
+		tapName, ok := r.taps[iface]
+		if !ok {
+			fmt.Printf("Not impairing %s, it has no tap device (network %s)\n", iface, r.backend)
+			continue
+		}
+		imp, err := c.impairment()
+		if err != nil {
+			return fmt.Errorf("impairment of %s: %w", iface, err)
+		}
+		err = r.tt.SetImpairment(tapName, imp)
+		if err != nil {
+			return fmt.Errorf("impairing %s: %w", iface, err)
+		}
+		fmt.Printf("Impaired %s (%s): %s\n", iface, tapName, imp)
+		r.addCleanup(func() error {
+			return r.tt.ClearImpairment(tapName)
+		})
+	}
+	return nil
+}
+
+// runImpair inspects or changes the impairment of an interface of a running VM.
+func runImpair(args []string) error {
+	flags := flag.NewFlagSet("impair", flag.ContinueOnError)
+	var c impairmentConfig
+	flags.StringVar(&c.Delay, "delay", "", "added delay, e.g. 50ms")
+	flags.StringVar(&c.Jitter, "jitter", "", "delay variation, e.g. 5ms")
+	flags.Float64Var(&c.Loss, "loss", 0, "packet loss in percent")
+	flags.Float64Var(&c.Duplicate, "duplicate", 0, "packet duplication in percent")
+	flags.Float64Var(&c.Reorder, "reorder", 0, "packet reordering in percent, requires a delay")
+	flags.Float64Var(&c.Corrupt, "corrupt", 0, "packet corruption in percent")
+	flags.StringVar(&c.Rate, "rate", "", "bandwidth limit, e.g. 10mbit")
+	clear := flags.Bool("clear", false, "remove the impairment")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("usage: impair [-delay d] [-jitter d] [-loss %%] [-duplicate %%] [-reorder %%] [-corrupt %%] [-rate r] [-clear] <vm> [iface]")
+	}
+	st, err := loadState(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	tapName, err := st.tap(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	tt, err := loadManager(st.Namespace)
+	if err != nil {
+		return err
+	}
+	// any flag means the impairment is changed, otherwise it is only shown
+	changed := false
+	flags.Visit(func(*flag.Flag) { changed = true })
+	if changed {
+		imp := tuntap.Impairment{}
+		if !*clear {
+			imp, err = c.impairment()
+			if err != nil {
+				return err
+			}
+		}
+		err = tt.SetImpairment(tapName, imp)
+		if err != nil {
+			return err
+		}
+	}
+	imp, err := tt.GetImpairment(tapName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", tapName, imp)
+	return nil
+}
+
+// vmTap returns the tap device of an interface of a running VM, net0 if iface is empty.
+func vmTap(vm, iface string) (string, error) {
+	st, err := loadState(vm)
+	if err != nil {
+		return "", err
+	}
+	return st.tap(iface)
+}
+
+// tap returns the tap device of an interface of the VM, net0 if iface is empty.
+func (st vmState) tap(iface string) (string, error) {
+	if iface == "" {
+		iface = "net0"
+	}
+	tapName, ok := st.Taps[iface]
+	if !ok {
+		return "", fmt.Errorf("%s has no tap device for %s (network %s)", st.Name, iface, st.Network)
+	}
+	return tapName, nil
+}
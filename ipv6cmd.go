@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp6"
+	"github.com/perbu/qemu-wrapper/ra"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// ipv6Config enables router advertisements, and optionally DHCPv6, on the bridge of a DHCP server.
+type ipv6Config struct {
+	Prefix string `json:"prefix"`
+	// NoSLAAC keeps the hosts from configuring addresses in the prefix themselves.
+	NoSLAAC bool `json:"no_slaac,omitempty"`
+	// DHCPv6 hands out addresses derived from the MAC addresses, and sets the managed flag.
+	DHCPv6 bool     `json:"dhcpv6,omitempty"`
+	DNS    []string `json:"dns,omitempty"`
+	// DefaultRouter advertises the host as default router.
+	DefaultRouter bool   `json:"default_router,omitempty"`
+	MTU           int    `json:"mtu,omitempty"`
+	Interval      string `json:"interval,omitempty"` // between advertisements, 1m by default
+	LeaseTime     string `json:"lease_time,omitempty"`
+}
+
+// startIPv6 advertises the prefix on the bridge until the context is cancelled, and
+// runs the DHCPv6 server if configured. It returns that server, nil without DHCPv6.
+func startIPv6(ctx context.Context, c ipv6Config, bridge, domain string) (*dhcp6.Server, error) {
+	prefix, err := netip.ParsePrefix(c.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("ipv6 prefix: %w", err)
+	}
+	cfg := ra.Config{
+		Prefixes:   []netip.Prefix{prefix},
+		Autonomous: !c.NoSLAAC,
+		Managed:    c.DHCPv6,
+		Other:      c.DHCPv6,
+		MTU:        c.MTU,
+		Interval:   time.Minute,
+	}
+	if c.DefaultRouter {
+		cfg.RouterLifetime = 30 * time.Minute
+	}
+	if domain != "" {
+		cfg.Domains = []string{domain}
+	}
+	for _, s := range c.DNS {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("ipv6 dns: %w", err)
+		}
+		cfg.DNS = append(cfg.DNS, a)
+	}
+	if c.Interval != "" {
+		cfg.Interval, err = time.ParseDuration(c.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("ipv6 interval: %w", err)
+		}
+	}
+	adv, err := ra.NewAdvertiser(cfg, bridge)
+	if err != nil {
+		return nil, err
+	}
+	var srv *dhcp6.Server
+	if c.DHCPv6 {
+		srv, err = startDHCPv6(ctx, c, cfg, bridge)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, err := ra.Listen(bridge)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := adv.Serve(ctx, conn); err != nil {
+			log.Printf("ra: %v", err)
+		}
+	}()
+	fmt.Printf("router advertisements of %s on %s\n", prefix, bridge)
+	return srv, nil
+}
+
+func startDHCPv6(ctx context.Context, c ipv6Config, rcfg ra.Config, bridge string) (*dhcp6.Server, error) {
+	cfg := dhcp6.Config{Prefix: rcfg.Prefixes[0], DNS: rcfg.DNS, Domains: rcfg.Domains, LeaseTime: time.Hour}
+	if c.LeaseTime != "" {
+		var err error
+		cfg.LeaseTime, err = time.ParseDuration(c.LeaseTime)
+		if err != nil {
+			return nil, fmt.Errorf("ipv6 lease time: %w", err)
+		}
+	}
+	iface, err := net.InterfaceByName(bridge)
+	if err != nil {
+		return nil, err
+	}
+	leaseFile, err := leasePath(bridge)
+	if err != nil {
+		return nil, err
+	}
+	leaseFile += "6"
+	leases, err := loadLeases(leaseFile)
+	if err != nil {
+		return nil, err
+	}
+	srv, err := dhcp6.NewServer(cfg, iface.HardwareAddr, leases)
+	if err != nil {
+		return nil, err
+	}
+	srv.VMName = vmByMAC
+	srv.OnChange = func() {
+		if err := saveLeases(leaseFile, srv.Leases()); err != nil {
+			log.Printf("dhcp6: %v", err)
+		}
+	}
+	conn, err := dhcp6.Listen(bridge)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := srv.Serve(ctx, conn); err != nil {
+			log.Printf("dhcp6: %v", err)
+		}
+	}()
+	fmt.Printf("DHCPv6 server on %s, leases in %s\n", bridge, leaseFile)
+	return srv, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/qmp"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// flapSchedule says how often and how fast a link is flapped.
+type flapSchedule struct {
+	count    int
+	interval time.Duration // time spent down, and up between flaps
+	jitter   time.Duration // random variation of the interval, plus or minus
+}
+
+// delay returns the interval with the jitter applied, never negative.
+func (s flapSchedule) delay() time.Duration {
+	d := s.interval
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*s.jitter))) - s.jitter
+	}
+	return max(d, 0)
+}
+
+func (s flapSchedule) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.delay()):
+		return nil
+	}
+}
+
+// flap takes the link down and up again count times. When ctx is done it stops,
+// but never leaves the link down.
+func (s flapSchedule) flap(ctx context.Context, transition func(up bool) error) error {
+	for i := 0; i < s.count; i++ {
+		if err := transition(false); err != nil {
+			return err
+		}
+		waitErr := s.wait(ctx)
+		if err := transition(true); err != nil {
+			return err
+		}
+		if waitErr != nil {
+			return nil
+		}
+		if i < s.count-1 {
+			if s.wait(ctx) != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// linkTap returns the tap the link of iface is changed on, or false if it has to
+// go through QMP: when asked to or when the interface has no tap, like with rootless
+// networking.
+func linkTap(st vmState, iface string, useQMP bool) (string, bool) {
+	tapName, ok := st.Taps[iface]
+	if !ok || useQMP {
+		return "", false
+	}
+	return tapName, true
+}
+
+// runLink takes an interface of a running VM down or up, or flaps it.
+func runLink(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("link", flag.ContinueOnError)
+	useQMP := flags.Bool("qmp", false, "change the guest visible link through QMP instead of the tap")
+	var sched flapSchedule
+	flags.IntVar(&sched.count, "count", 1, "number of flaps")
+	flags.DurationVar(&sched.interval, "interval", 5*time.Second, "time the link stays down, and up between flaps")
+	flags.DurationVar(&sched.jitter, "jitter", 0, "random variation of the interval")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 || flags.NArg() > 3 {
+		return fmt.Errorf("usage: link [-qmp] [-count n] [-interval d] [-jitter d] down|up|flap <vm> [iface]")
+	}
+	action, vm, iface := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	if iface == "" {
+		iface = "net0"
+	}
+	st, err := loadState(vm)
+	if err != nil {
+		return err
+	}
+	tapName, onTap := linkTap(st, iface, *useQMP)
+	if !onTap && !*useQMP {
+		fmt.Printf("%s has no tap device for %s, using QMP\n", vm, iface)
+	}
+	logger, closeLog, err := linkLogger(vm)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	var setLink func(up bool) error
+	if !onTap {
+		c, err := qmp.Dial(st.QMP)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		setLink = func(up bool) error { return c.SetLink(iface, up) }
+	} else {
+		tt, err := loadManager(st.Namespace)
+		if err != nil {
+			return err
+		}
+		setLink = func(up bool) error { return tt.SetLinkState(tapName, up) }
+	}
+	transition := func(up bool) error {
+		err := setLink(up)
+		if err != nil {
+			return err
+		}
+		state := "down"
+		if up {
+			state = "up"
+		}
+		logger.Printf("%s %s %s", vm, iface, state)
+		return nil
+	}
+
+	switch action {
+	case "down":
+		return transition(false)
+	case "up":
+		return transition(true)
+	case "flap":
+	default:
+		return fmt.Errorf("unknown link action %q", action)
+	}
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	return sched.flap(ctx, transition)
+}
+
+// linkLogger logs link transitions with timestamps to stdout and to <vm>.links.log in the state dir.
+func linkLogger(vm string) (*log.Logger, func(), error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, vm+".links.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("link log: %w", err)
+	}
+	logger := log.New(io.MultiWriter(os.Stdout, f), "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	return logger, func() { _ = f.Close() }, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFlapSchedule_delay(t *testing.T) {
+	s := flapSchedule{interval: 100 * time.Millisecond, jitter: 30 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := s.delay(); d < 70*time.Millisecond || d >= 130*time.Millisecond {
+			t.Fatalf("expected a delay within the jitter, got %s", d)
+		}
+	}
+	s = flapSchedule{interval: 10 * time.Millisecond, jitter: time.Second}
+	for i := 0; i < 100; i++ {
+		if d := s.delay(); d < 0 {
+			t.Fatalf("expected no negative delay, got %s", d)
+		}
+	}
+}
+
+func TestFlapSchedule_flap(t *testing.T) {
+	s := flapSchedule{count: 3, interval: 20 * time.Millisecond}
+	var states []bool
+	var times []time.Time
+	err := s.flap(context.Background(), func(up bool) error {
+		states = append(states, up)
+		times = append(times, time.Now())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := []bool{false, true, false, true, false, true}
+	if !slices.Equal(states, want) {
+		t.Fatalf("expected %v, got %v", want, states)
+	}
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d < s.interval {
+			t.Errorf("expected transition %d at least %s after the previous one, got %s", i, s.interval, d)
+		}
+	}
+}
+
+func TestFlapSchedule_flapInterrupted(t *testing.T) {
+	s := flapSchedule{count: 3, interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	var states []bool
+	err := s.flap(ctx, func(up bool) error {
+		states = append(states, up)
+		if !up {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the link is brought back up before giving up
+	if !slices.Equal(states, []bool{false, true}) {
+		t.Errorf("expected down and up, got %v", states)
+	}
+}
+
+func TestLinkTap(t *testing.T) {
+	st := vmState{Name: "r1", Taps: map[string]string{"net0": "tap1"}}
+	tests := []struct {
+		iface   string
+		useQMP  bool
+		wantTap string
+		wantOK  bool
+	}{
+		{"net0", false, "tap1", true},
+		{"net0", true, "", false},
+		{"net1", false, "", false},
+	}
+	for _, tt := range tests {
+		tapName, ok := linkTap(st, tt.iface, tt.useQMP)
+		if tapName != tt.wantTap || ok != tt.wantOK {
+			t.Errorf("%s qmp %v: expected %q %v, got %q %v", tt.iface, tt.useQMP, tt.wantTap, tt.wantOK, tapName, ok)
+		}
+	}
+	if _, ok := linkTap(vmState{Name: "r2"}, "net0", false); ok {
+		t.Errorf("expected QMP for a VM without taps")
+	}
+}
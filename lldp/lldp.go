@@ -0,0 +1,142 @@
+// Package lldp decodes LLDP frames (IEEE 802.1AB) and checks the neighbors the
+// VMs see against the links of the intended topology.
+package lldp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/capture"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const etherType = 0x88cc
+
+// TLV types.
+const (
+	tlvEnd         = 0
+	tlvChassisID   = 1
+	tlvPortID      = 2
+	tlvTTL         = 3
+	tlvPortDesc    = 4
+	tlvSystemName  = 5
+	tlvSystemDesc  = 6
+	subtypeMAC     = 4 // of the chassis id, the port id uses 3
+	subtypeAddress = 5 // of the chassis id, the port id uses 4
+)
+
+// Filter passes only LLDP frames, for capture.Open.
+var Filter = []capture.Instruction{
+	{Code: 0x28, K: 12},               // ldh [12]
+	{Code: 0x15, Jf: 1, K: etherType}, // jeq #0x88cc
+	{Code: 0x06, K: 65535},            // ret #65535
+	{Code: 0x06, K: 0},                // ret #0
+}
+
+// Neighbor is what a device advertises about itself and the port it sends on.
+type Neighbor struct {
+	ChassisID         string
+	PortID            string
+	PortDescription   string
+	SystemName        string
+	SystemDescription string
+	TTL               time.Duration
+}
+
+// ID identifies the port the advertisement was sent from.
+func (n Neighbor) ID() string {
+	return n.ChassisID + " " + n.PortID
+}
+
+// String names the port for people, by system name if there is one.
+func (n Neighbor) String() string {
+	if n.SystemName != "" {
+		return n.SystemName + " " + n.PortID
+	}
+	return n.ID()
+}
+
+// Parse decodes an Ethernet frame holding an LLDP data unit, optionally VLAN tagged.
+func Parse(frame []byte) (Neighbor, error) {
+	if len(frame) < 14 {
+		return Neighbor{}, errors.New("short frame")
+	}
+	typ, payload := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+	if typ == 0x8100 && len(frame) >= 18 {
+		typ, payload = binary.BigEndian.Uint16(frame[16:]), frame[18:]
+	}
+	if typ != etherType {
+		return Neighbor{}, fmt.Errorf("ethertype %#04x is not lldp", typ)
+	}
+	var n Neighbor
+	seen := 0
+	for len(payload) >= 2 {
+		header := binary.BigEndian.Uint16(payload)
+		t, length := header>>9, int(header&0x1ff)
+		if len(payload) < 2+length {
+			return n, fmt.Errorf("tlv %d truncated", t)
+		}
+		v := payload[2 : 2+length]
+		payload = payload[2+length:]
+		switch t {
+		case tlvEnd:
+			payload = nil
+		case tlvChassisID, tlvPortID:
+			if length < 2 {
+				return n, fmt.Errorf("tlv %d too short", t)
+			}
+			id := idString(v[0], v[1:], t == tlvChassisID)
+			if t == tlvChassisID {
+				n.ChassisID = id
+			} else {
+				n.PortID = id
+			}
+			seen++
+		case tlvTTL:
+			if length != 2 {
+				return n, errors.New("bad ttl")
+			}
+			n.TTL = time.Duration(binary.BigEndian.Uint16(v)) * time.Second
+			seen++
+		case tlvPortDesc:
+			n.PortDescription = string(v)
+		case tlvSystemName:
+			n.SystemName = string(v)
+		case tlvSystemDesc:
+			n.SystemDescription = string(v)
+		}
+	}
+	if seen < 3 {
+		return n, errors.New("missing chassis id, port id or ttl")
+	}
+	return n, nil
+}
+
+// idString formats a chassis or port id by its subtype: MAC and network addresses
+// the usual way, the names and other ids as text.
+func idString(subtype byte, v []byte, chassis bool) string {
+	mac, addr := byte(3), byte(4)
+	if chassis {
+		mac, addr = subtypeMAC, subtypeAddress
+	}
+	switch {
+	case subtype == mac && len(v) == 6:
+		return net.HardwareAddr(v).String()
+	case subtype == addr && len(v) > 1:
+		// an IANA address family number, then the address
+		if a, ok := netip.AddrFromSlice(v[1:]); ok {
+			return a.String()
+		}
+	}
+	if s := string(v); isPrintable(s) {
+		return s
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+func isPrintable(s string) bool {
+	return s != "" && !strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f || r == 0xfffd })
+}
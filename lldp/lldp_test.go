@@ -0,0 +1,127 @@
+package lldp
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+func tlv(b []byte, t int, v ...byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(t<<9|len(v)))
+	return append(b, v...)
+}
+
+// frame builds an LLDP frame from a router, optionally tagged with a VLAN.
+func frame(tagged bool) []byte {
+	b := []byte{0x01, 0x80, 0xc2, 0, 0, 0x0e, 0x52, 0x54, 0, 0, 0, 1}
+	if tagged {
+		b = append(b, 0x81, 0x00, 0, 10)
+	}
+	b = append(b, 0x88, 0xcc)
+	b = tlv(b, tlvChassisID, 4, 0x52, 0x54, 0, 0, 0, 1)
+	b = tlv(b, tlvPortID, append([]byte{5}, "eth1"...)...)
+	b = tlv(b, tlvTTL, 0, 120)
+	b = tlv(b, tlvPortDesc, []byte("uplink")...)
+	b = tlv(b, tlvSystemName, []byte("r1")...)
+	b = tlv(b, tlvSystemDesc, []byte("Linux")...)
+	b = tlv(b, 127, 0, 0x12, 0x0f, 1, 0, 0) // organizational, ignored
+	return tlv(b, tlvEnd)
+}
+
+func TestParse(t *testing.T) {
+	expected := Neighbor{
+		ChassisID:         "52:54:00:00:00:01",
+		PortID:            "eth1",
+		PortDescription:   "uplink",
+		SystemName:        "r1",
+		SystemDescription: "Linux",
+		TTL:               120 * time.Second,
+	}
+	for _, tagged := range []bool{false, true} {
+		n, err := Parse(frame(tagged))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if n != expected {
+			t.Errorf("expected %+v, got %+v", expected, n)
+		}
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	f := frame(false)
+	tests := map[string][]byte{
+		"short":     f[:10],
+		"ethertype": append(append([]byte{}, f[:12]...), 0x08, 0x00),
+		"truncated": f[:len(f)-8],
+		"no ttl":    tlv(tlv(append([]byte{}, f[:14]...), tlvChassisID, 7, 'a'), tlvPortID, 7, 'b'),
+	}
+	for name, b := range tests {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestIDString(t *testing.T) {
+	tests := []struct {
+		subtype byte
+		v       []byte
+		chassis bool
+		want    string
+	}{
+		{4, []byte{2, 0, 0, 0, 0, 9}, true, "02:00:00:00:00:09"},
+		{3, []byte{2, 0, 0, 0, 0, 9}, false, "02:00:00:00:00:09"},
+		{5, []byte{1, 10, 0, 0, 1}, true, "10.0.0.1"},
+		{7, []byte("sw1"), true, "sw1"},
+		{7, []byte{0, 1}, false, "0001"},
+	}
+	for _, tt := range tests {
+		if got := idString(tt.subtype, tt.v, tt.chassis); got != tt.want {
+			t.Errorf("expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	r1 := &Neighbor{ChassisID: "c1", PortID: "eth1", SystemName: "r1"}
+	r2 := &Neighbor{ChassisID: "c2", PortID: "eth1", SystemName: "r2"}
+	r3 := &Neighbor{ChassisID: "c3", PortID: "eth1", SystemName: "r3"}
+	stranger := Neighbor{ChassisID: "c9", PortID: "ge-0/0/0", SystemName: "sw9"}
+	ports := []Port{
+		{Name: "r1:net1", Self: r1, Heard: []Neighbor{*r2, *r2}},
+		{Name: "r2:net1", Self: r2, Heard: []Neighbor{*r1}},
+		{Name: "r3:net1", Self: r3, Heard: []Neighbor{stranger}},
+		{Name: "r4:net1"},
+		{Name: "r5:net1", Heard: []Neighbor{*r3}},
+	}
+	links := [][2]string{
+		{"r1:net1", "r2:net1"},
+		{"r3:net1", "r4:net1"},
+		{"r6:net1", "r1:net2"},
+	}
+	results := Verify(ports, links)
+	expected := []Result{
+		{Port: "r1:net1", Self: "r1 eth1", Expected: []string{"r2:net1"}, Actual: []string{"r2:net1"}, Status: StatusOK},
+		{Port: "r1:net2", Expected: []string{"r6:net1"}, Status: StatusNoPort},
+		{Port: "r2:net1", Self: "r2 eth1", Expected: []string{"r1:net1"}, Actual: []string{"r1:net1"}, Status: StatusOK},
+		{Port: "r3:net1", Self: "r3 eth1", Expected: []string{"r4:net1"}, Actual: []string{"sw9 ge-0/0/0"}, Status: StatusMismatch},
+		{Port: "r4:net1", Expected: []string{"r3:net1"}, Status: StatusMissing},
+		{Port: "r5:net1", Actual: []string{"r3:net1"}, Status: StatusUnexpected},
+		{Port: "r6:net1", Expected: []string{"r1:net2"}, Status: StatusNoPort},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %v", len(expected), results)
+	}
+	for i, r := range results {
+		e := expected[i]
+		if r.Port != e.Port || r.Self != e.Self || r.Status != e.Status ||
+			!slices.Equal(r.Expected, e.Expected) || !slices.Equal(r.Actual, e.Actual) {
+			t.Errorf("expected %+v, got %+v", e, r)
+		}
+	}
+	if n := Mismatches(results); n != 5 {
+		t.Errorf("expected 5 mismatches, got %d", n)
+	}
+}
@@ -0,0 +1,106 @@
+package lldp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Port is what was seen on an interface of a VM, named vm:iface.
+type Port struct {
+	Name string
+	// Self is what the VM advertises on the interface, nil if it sent nothing.
+	Self *Neighbor
+	// Heard are the advertisements that reached the VM on the interface.
+	Heard []Neighbor
+}
+
+// Status of a port compared with the topology.
+const (
+	StatusOK         = "ok"
+	StatusMissing    = "missing"    // a link is expected, nothing was heard
+	StatusMismatch   = "mismatch"   // something else was heard
+	StatusUnexpected = "unexpected" // no link is expected, something was heard
+	StatusNoPort     = "no port"    // the port of a link does not exist
+)
+
+// Result compares a port with the topology.
+type Result struct {
+	Port     string
+	Self     string   // what the VM calls the port
+	Expected []string // the ports it should be linked to
+	Actual   []string // the ports it is linked to, by name if they are known
+	Status   string
+}
+
+// Verify compares the ports with the links of the topology, pairs of port names.
+// A neighbor heard on a port is named after the port whose VM sent the same
+// advertisement, so the result says which VM interface links to which.
+func Verify(ports []Port, links [][2]string) []Result {
+	byID := make(map[string]string)
+	for _, p := range ports {
+		if p.Self != nil {
+			byID[p.Self.ID()] = p.Name
+		}
+	}
+	expected := make(map[string][]string)
+	for _, l := range links {
+		expected[l[0]] = append(expected[l[0]], l[1])
+		expected[l[1]] = append(expected[l[1]], l[0])
+	}
+	var results []Result
+	seen := make(map[string]bool)
+	for _, p := range ports {
+		seen[p.Name] = true
+		r := Result{Port: p.Name, Expected: expected[p.Name]}
+		if p.Self != nil {
+			r.Self = p.Self.String()
+		}
+		for _, n := range p.Heard {
+			name, ok := byID[n.ID()]
+			if !ok {
+				name = n.String()
+			}
+			if !slices.Contains(r.Actual, name) {
+				r.Actual = append(r.Actual, name)
+			}
+		}
+		slices.Sort(r.Expected)
+		slices.Sort(r.Actual)
+		switch {
+		case len(r.Expected) == 0 && len(r.Actual) == 0:
+			r.Status = StatusOK
+		case len(r.Expected) == 0:
+			r.Status = StatusUnexpected
+		case len(r.Actual) == 0:
+			r.Status = StatusMissing
+		case slices.Equal(r.Expected, r.Actual):
+			r.Status = StatusOK
+		default:
+			r.Status = StatusMismatch
+		}
+		results = append(results, r)
+	}
+	for name, exp := range expected {
+		if !seen[name] {
+			results = append(results, Result{Port: name, Expected: exp, Status: StatusNoPort})
+		}
+	}
+	slices.SortFunc(results, func(a, b Result) int { return strings.Compare(a.Port, b.Port) })
+	return results
+}
+
+// Mismatches returns the number of results that are not ok.
+func Mismatches(results []Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Status != StatusOK {
+			n++
+		}
+	}
+	return n
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s: expected %v, got %v: %s", r.Port, r.Expected, r.Actual, r.Status)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/capture"
+	"github.com/perbu/qemu-wrapper/lldp"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// topology is the intended wiring of the lab, as pairs of vm:iface ports.
+type topology struct {
+	Links [][2]string `json:"links"`
+}
+
+// lldpListener collects the LLDP frames seen on the taps and bridges.
+type lldpListener struct {
+	mu      sync.Mutex
+	ports   map[string]*lldp.Port // by tap
+	bridges map[string][]lldp.Neighbor
+}
+
+// runLLDP listens for LLDP on the taps of running VMs and their bridges, prints the
+// neighbor table and, given the intended topology, flags the ports that differ.
+func runLLDP(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("lldp", flag.ContinueOnError)
+	topoFile := flags.String("topology", "", "intended topology, a json file with the links as pairs of vm:iface")
+	duration := flags.Duration("t", 35*time.Second, "how long to listen, longer than the LLDP interval of the VMs")
+	forward := flags.Bool("forward", false, "make the bridges forward LLDP between their ports first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var topo topology
+	if *topoFile != "" {
+		data, err := os.ReadFile(*topoFile)
+		if err != nil {
+			return fmt.Errorf("read topology: %w", err)
+		}
+		if err := json.Unmarshal(data, &topo); err != nil {
+			return fmt.Errorf("parse topology %s: %w", *topoFile, err)
+		}
+	}
+	states, err := listStates()
+	if err != nil {
+		return err
+	}
+	l := &lldpListener{ports: make(map[string]*lldp.Port), bridges: make(map[string][]lldp.Neighbor)}
+	for _, st := range states {
+		named := slices.Contains(flags.Args(), st.Name)
+		if flags.NArg() > 0 && !named {
+			continue
+		}
+		if st.otherNamespace() {
+			// the taps and bridges are looked up in our namespace
+			if named {
+				return fmt.Errorf("%s runs in namespace %s, run lldp inside with ip netns exec", st.Name, st.Namespace)
+			}
+			fmt.Printf("Skipping %s, it runs in namespace %s\n", st.Name, st.Namespace)
+			continue
+		}
+		for iface, tap := range st.Taps {
+			l.ports[tap] = &lldp.Port{Name: st.Name + ":" + iface}
+			if br, err := os.Readlink(filepath.Join("/sys/class/net", tap, "master")); err == nil {
+				l.bridges[filepath.Base(br)] = nil
+			}
+		}
+	}
+	if len(l.ports) == 0 {
+		return fmt.Errorf("no taps to listen on")
+	}
+	// the listeners add to l.bridges, so the names are taken before they start
+	bridges := make([]string, 0, len(l.bridges))
+	for br := range l.bridges {
+		bridges = append(bridges, br)
+	}
+	slices.Sort(bridges)
+	if *forward {
+		tt, err := loadManager("")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, br := range bridges {
+				if err := tt.RestoreLLDP(br); err != nil {
+					log.Printf("lldp: %v", err)
+				}
+			}
+		}()
+		for _, br := range bridges {
+			if err := tt.ForwardLLDP(br); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	fmt.Printf("Listening for LLDP on %d taps and %d bridges for %s\n", len(l.ports), len(bridges), *duration)
+	var wg sync.WaitGroup
+	for ifname := range l.ports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.listen(ctx, ifname, true)
+		}()
+	}
+	for _, ifname := range bridges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.listen(ctx, ifname, false)
+		}()
+	}
+	wg.Wait()
+
+	var ports []lldp.Port
+	for _, p := range l.ports {
+		ports = append(ports, *p)
+	}
+	results := lldp.Verify(ports, topo.Links)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *topoFile == "" {
+		_, _ = fmt.Fprintf(w, "PORT\tADVERTISES\tNEIGHBORS\n")
+		for _, r := range results {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", r.Port, dash(r.Self), dash(strings.Join(r.Actual, ", ")))
+		}
+	} else {
+		_, _ = fmt.Fprintf(w, "PORT\tADVERTISES\tEXPECTED\tNEIGHBORS\tSTATUS\n")
+		for _, r := range results {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Port, dash(r.Self),
+				dash(strings.Join(r.Expected, ", ")), dash(strings.Join(r.Actual, ", ")), r.Status)
+		}
+	}
+	for _, br := range bridges {
+		var names []string
+		for _, n := range l.bridges[br] {
+			if !slices.Contains(names, n.String()) {
+				names = append(names, n.String())
+			}
+		}
+		_, _ = fmt.Fprintf(w, "bridge %s\t\t%s\n", br, dash(strings.Join(names, ", ")))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *topoFile != "" {
+		if n := lldp.Mismatches(results); n > 0 {
+			return fmt.Errorf("%d ports differ from the topology", n)
+		}
+	}
+	return nil
+}
+
+// listen records the LLDP frames on a tap or bridge until ctx is done. Frames the
+// tap receives come from the VM, the ones it sends go to it.
+func (l *lldpListener) listen(ctx context.Context, ifname string, tap bool) {
+	h, err := capture.Open(ifname, 1600, lldp.Filter)
+	if err != nil {
+		log.Printf("lldp: capture on %s: %v", ifname, err)
+		return
+	}
+	defer h.Close()
+	err = h.Capture(ctx, func(p capture.Packet) error {
+		n, err := lldp.Parse(p.Data)
+		if err != nil {
+			log.Printf("lldp: %s: %v", ifname, err)
+			return nil
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		switch {
+		case !tap:
+			l.bridges[ifname] = append(l.bridges[ifname], n)
+		case p.Outgoing:
+			l.ports[ifname].Heard = append(l.ports[ifname].Heard, n)
+		default:
+			l.ports[ifname].Self = &n
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("lldp: %s: %v", ifname, err)
+	}
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/perbu/qemu-wrapper/privhelper"
 	"github.com/perbu/qemu-wrapper/tuntap"
 	"hash/crc32"
+	"io/fs"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -21,10 +28,41 @@ type Runner struct {
 	tt         *tuntap.Manager
 	options    []string
 	firmware   string
+	name       string
+	network    string // requested network backend
+	backend    string // network backend in use
 	mac        string
 	telnetPort uint16
+	forwards   []forward
+	links      []string // switch ports for additional NICs
+	bridge     string   // bridge for the tap device
+	vlans      tuntap.PortVLANs
+	taps       map[string]string // tap device per interface id
+	config     *vmConfig
+	qmpSocket  string
+	cpus       int
+	queues     map[string]int            // tap queues per interface id, when multi-queue
+	macs       map[string]string         // mac address per interface id
+	files      []*os.File                // passed to qemu, the first is fd 3
+	privileged bool                      // ensurePrivileges has been done
+	record     string                    // file to save the tap manager commands to, if set
+	recording  *tuntap.RecordingExecutor // what the tap manager ran, with -record
+	cleanups   []func() error            // run in reverse order when the VM stops
 }
 
+const usageText = `usage: %[1]s [-smp n] [-net auto|tap|user|passt] [-bridge br0] [-vlan id | -trunk ids [-native id]]
+          [-link [dgram:]<port socket>]... [-config vm.json] [-record file] <image>
+       %[1]s forwards [vm]
+       %[1]s switch [-v] <config.json>
+       %[1]s impair [options] <vm> [iface]
+       %[1]s link [options] down|up|flap <vm> [iface]
+       %[1]s capture [options] <vm> [iface]
+       %[1]s dhcp <config.json>
+       %[1]s leases [-vm name [-6]] [bridge]
+       %[1]s logs [-syslog] [-f] [-n lines] <vm>
+       %[1]s flows [-n talkers] [-since duration] [-by pair|src|dst] <vm>
+       %[1]s lldp [-topology topo.json] [-t duration] [-forward] [vm...]`
+
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	defer cancel()
@@ -36,86 +74,511 @@ func main() {
 }
 
 func run(ctx context.Context, args []string, env []string) error {
+	usage := fmt.Errorf(usageText, args[0])
 	if len(args) < 2 {
-		return fmt.Errorf("usage: %s <image>", args[0])
+		return usage
+	}
+	switch args[1] {
+	case "forwards":
+		if len(args) > 3 {
+			return usage
+		}
+		return runForwards(args[2:])
+	case "switch":
+		return runSwitch(ctx, args[2:])
+	case "impair":
+		return runImpair(args[2:])
+	case "link":
+		return runLink(ctx, args[2:])
+	case "capture":
+		return runCapture(ctx, args[2:])
+	case "dhcp":
+		return runDHCP(ctx, args[2:])
+	case "leases":
+		return runLeases(args[2:])
+	case "logs":
+		return runLogs(ctx, args[2:])
+	case "flows":
+		return runFlows(args[2:])
+	case "lldp":
+		return runLLDP(ctx, args[2:])
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	network := flags.String("net", "auto", "network backend: auto, tap, user or passt")
+	cpus := flags.Int("smp", 1, "number of vCPUs, taps get one queue per vCPU")
+	var links switchLinks
+	flags.Var(&links, "link", "connect an additional NIC to a switch port socket, may be repeated")
+	bridge := flags.String("bridge", "br0", "bridge for the tap device")
+	accessVLAN := flags.Uint("vlan", 0, "access vlan of the tap device")
+	trunk := flags.String("trunk", "", "comma separated vlans to carry tagged on the tap device")
+	nativeVLAN := flags.Uint("native", 0, "untagged vlan of a trunk")
+	configFile := flags.String("config", "", "VM configuration file")
+	record := flags.String("record", "", "save the ip, bridge and tc commands run for the VM to this file, as tuntap testdata")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usage
+	}
+	if *cpus < 1 {
+		return fmt.Errorf("-smp must be at least 1")
+	}
+	firmwarePath := flags.Arg(0)
+	vlans, err := parseVLANFlags(*accessVLAN, *trunk, *nativeVLAN)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return err
 	}
-	firmwarePath := args[1]
 	runner := &Runner{
 		tt:       tuntap.New(),
 		firmware: firmwarePath,
+		name:     vmName(firmwarePath),
+		network:  *network,
+		links:    links,
+		bridge:   *bridge,
+		vlans:    vlans,
+		taps:     make(map[string]string),
+		config:   cfg,
+		cpus:     *cpus,
+		queues:   make(map[string]int),
+		macs:     make(map[string]string),
+		record:   *record,
+	}
+	if cfg.Namespace != nil {
+		runner.tt.SetNamespace(cfg.Namespace.Name)
 	}
+	defer func() {
+		_ = runner.teardown()
+		runner.saveRecording()
+	}()
 	runner.generateMac()
 	runner.allocatePort()
-	runner.makeCommandLine()
+	err = runner.makeCommandLine()
+	if err != nil {
+		return fmt.Errorf("command line: %w", err)
+	}
+	err = runner.applyImpairments()
+	if err != nil {
+		return fmt.Errorf("impairments: %w", err)
+	}
+	err = runner.applyMirrors()
+	if err != nil {
+		return fmt.Errorf("mirrors: %w", err)
+	}
+	err = runner.applyUplinks()
+	if err != nil {
+		return fmt.Errorf("uplinks: %w", err)
+	}
+	err = runner.connectNamespace()
+	if err != nil {
+		return fmt.Errorf("namespace: %w", err)
+	}
+	err = runner.startForwards(ctx)
+	if err != nil {
+		return fmt.Errorf("forwards: %w", err)
+	}
+	err = runner.saveState()
+	if err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	runner.printForwards()
 	cmd := exec.CommandContext(ctx, qemuBinary, runner.options...)
 	cmd.Env = env
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = runner.files
 	for _, opt := range runner.options {
 		fmt.Printf(" - %s\n", opt)
 	}
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("qemu start: %w", err)
 	}
-	err := cmd.Wait()
+	err = cmd.Wait()
 	if err != nil {
 		return fmt.Errorf("qemu wait: %w", err)
 	}
-	err = runner.tt.DeleteTaps()
-	if err != nil {
-		return fmt.Errorf("delete taps: %w", err)
+	return runner.teardown()
+}
+
+// addCleanup registers a function that undoes part of the setup when the VM stops.
+func (r *Runner) addCleanup(f func() error) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// teardown runs the cleanups in reverse order. It is safe to call more than once.
+func (r *Runner) teardown() error {
+	var errs []error
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		if err := r.cleanups[i](); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	r.cleanups = nil
+	return errors.Join(errs...)
 }
 
-func (r *Runner) makeCommandLine() {
+func (r *Runner) makeCommandLine() error {
 	var ext string
 	if strings.HasSuffix(r.firmware, ".qcow2") {
 		ext = "qcow2"
 	} else {
 		ext = "raw"
 	}
+	var netdev string
+	var err error
+	if r.config.Interfaces["net0"].Backend == "macvtap" {
+		netdev, err = r.getMacvtapNetworking("net0")
+		r.backend = "macvtap"
+	} else {
+		netdev, err = r.getNativeNetworking("net0")
+	}
+	if err != nil {
+		return fmt.Errorf("networking: %w", err)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	r.qmpSocket = filepath.Join(dir, r.name+".qmp")
 	options := []string{
 		"-drive", fmt.Sprintf("file=%s,format=%s", r.firmware, ext),
 		"-m", "512",
+		"-smp", strconv.Itoa(r.cpus),
 		"-machine", "q35",
-		"-netdev", r.getNativeNetworking("net0"),
-		"-device", fmt.Sprintf("virtio-net-pci,netdev=net0,mac=%s", r.mac),
+		"-netdev", netdev,
+		"-device", r.nicDevice("net0", r.mac),
 		"-nographic",
 		"-serial", fmt.Sprintf("telnet:localhost:%d,server,nowait", r.telnetPort),
+		"-qmp", fmt.Sprintf("unix:%s,server=on,wait=off", r.qmpSocket),
+	}
+	for i, link := range r.links {
+		linkOpts, err := r.linkOptions(fmt.Sprintf("net%d", i+1), link)
+		if err != nil {
+			return fmt.Errorf("link %s: %w", link, err)
+		}
+		options = append(options, linkOpts...)
+	}
+	// additional NICs declared in the config only
+	ids := make([]string, 0, len(r.config.Interfaces))
+	for id, c := range r.config.Interfaces {
+		if c.Backend == "macvtap" && id != "net0" {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		if n, err := strconv.Atoi(strings.TrimPrefix(id, "net")); err == nil && n <= len(r.links) {
+			return fmt.Errorf("%s is a switch link, it can't be a macvtap", id)
+		}
+		netdev, err := r.getMacvtapNetworking(id)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		options = append(options, "-netdev", netdev, "-device", r.nicDevice(id, r.nicMac(id)))
 	}
 	if runtime.GOOS == "linux" {
 		options = append(options, "-enable-kvm")
 	}
 	r.options = options
+	return nil
 }
 
-// getNativeNetworking returns the correct -netdev string for the current OS
-func (r *Runner) getNativeNetworking(id string) string {
+// getNativeNetworking returns the correct -netdev string for the current OS and the requested backend.
+func (r *Runner) getNativeNetworking(id string) (string, error) {
+	switch r.network {
+	case "user", "passt":
+		return r.getRootlessNetworking(id)
+	case "auto", "tap":
+	default:
+		return "", fmt.Errorf("unknown network backend %q", r.network)
+	}
 	switch runtime.GOOS {
 	case "darwin":
-		return fmt.Sprintf("vmnet-shared,id=%s", id)
+		r.backend = "vmnet"
+		return fmt.Sprintf("vmnet-shared,id=%s", id), nil
 	case "linux":
-		// use a tap device.
-		tapName := generateTapName(r.firmware)
-		r.tt.SetSudo(true)
-		err := r.tt.Load()
+		netdev, err := r.getTapNetworking(id)
+		if err == nil || r.network == "tap" || !errors.Is(err, fs.ErrPermission) {
+			// only a lack of privileges means tap networking is not for us
+			return netdev, err
+		}
+		fmt.Printf("Tap networking not allowed (%v), using rootless networking\n", err)
+		return r.getRootlessNetworking(id)
+	default:
+		return "", fmt.Errorf("unsupported OS %s", runtime.GOOS)
+	}
+}
+
+// getTapNetworking creates a tap device on the bridge for the VM.
+func (r *Runner) getTapNetworking(id string) (string, error) {
+	tapName := generateTapName(r.firmware)
+	r.ensurePrivileges()
+	if r.tt.Namespace() != "" {
+		err := r.tt.EnsureNamespace()
+		if err != nil {
+			return "", fmt.Errorf("namespace: %w", err)
+		}
+	}
+	err := r.tt.Load()
+	if err != nil {
+		return "", fmt.Errorf("load: %w", err)
+	}
+	if r.tt.Namespace() != "" && !r.tt.HasBridge(r.bridge) {
+		// a new namespace has no bridges yet
+		err = r.tt.CreateBridge(r.bridge)
+		if err != nil {
+			return "", fmt.Errorf("bridge: %w", err)
+		}
+	}
+	if !r.vlans.IsZero() {
+		// an existing bridge may not filter vlans yet
+		err = r.tt.EnableVLANFiltering(r.bridge)
+		if err != nil {
+			return "", fmt.Errorf("bridge vlans: %w", err)
+		}
+	}
+	if mtu := r.config.Bridges[r.bridge].MTU; mtu != 0 {
+		err = r.tt.SetBridgeMTU(r.bridge, mtu)
+		if err != nil {
+			return "", fmt.Errorf("bridge mtu: %w", err)
+		}
+	}
+	queues := tuntap.TapQueues(r.cpus)
+	if queues > 1 {
+		err = r.tt.CreateMultiQueueTap(tapName)
 		if err != nil {
-			panic(err)
+			fmt.Printf("Warning: multi-queue tap not available (%v), using a single queue\n", err)
+			queues = 1
 		}
+	}
+	if queues == 1 {
 		err = r.tt.CreateTap(tapName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("create tap: %w", err)
+	}
+	r.addCleanup(func() error {
+		err := r.tt.DeleteTaps()
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("delete taps: %w", err)
 		}
-		// add the tap to the bridge
-		err = r.tt.AddTapToBridge(tapName, "br0")
+		return nil
+	})
+	var files []*os.File
+	if r.tt.Namespace() != "" {
+		// qemu stays in the host namespace, it gets the tap as open files
+		files, err = r.tt.OpenTap(tapName, queues)
 		if err != nil {
-			panic(err)
+			_ = r.teardown()
+			return "", fmt.Errorf("open tap: %w", err)
 		}
-		return fmt.Sprintf("tap,id=%s,ifname=%s,br=br0,script=no", id, tapName)
-	default:
-		panic("unsupported OS")
+		for _, f := range files {
+			r.addCleanup(f.Close)
+		}
+	}
+	if mtu := r.config.Interfaces[id].MTU; mtu != 0 {
+		err = r.tt.SetTapMTU(tapName, mtu)
+		if err != nil {
+			_ = r.teardown()
+			return "", fmt.Errorf("tap mtu: %w", err)
+		}
+	}
+	// add the tap to the bridge
+	err = r.tt.AddTapToBridge(tapName, r.bridge, r.vlans)
+	if err != nil {
+		_ = r.teardown()
+		return "", fmt.Errorf("add tap to bridge: %w", err)
+	}
+	host, err := r.config.Bridges[r.bridge].host()
+	if err != nil {
+		_ = r.teardown()
+		return "", fmt.Errorf("bridge %s: %w", r.bridge, err)
+	}
+	if !host.IsZero() {
+		err = r.tt.SetBridgeHost(r.bridge, host)
+		if err != nil {
+			_ = r.teardown()
+			return "", fmt.Errorf("bridge host: %w", err)
+		}
+		// registered after the tap, so it runs while the tap is still on the bridge
+		r.addCleanup(func() error {
+			return r.tt.ReleaseBridgeHost(r.bridge, host)
+		})
+	}
+	r.backend = "tap"
+	r.taps[id] = tapName
+	netdev := fmt.Sprintf("tap,id=%s,ifname=%s,br=%s,script=no", id, tapName, r.bridge)
+	if len(files) > 0 {
+		fds := make([]string, len(files))
+		for i, f := range files {
+			r.files = append(r.files, f)
+			fds[i] = strconv.Itoa(2 + len(r.files))
+		}
+		netdev = fmt.Sprintf("tap,id=%s,fds=%s", id, strings.Join(fds, ":"))
 	}
+	if err := tuntap.VhostNetAvailable(); err != nil {
+		fmt.Printf("Warning: %v, running without vhost acceleration\n", err)
+	} else {
+		netdev += ",vhost=on"
+	}
+	if queues > 1 {
+		r.queues[id] = queues
+		if len(files) == 0 {
+			// with fds the queues are the files
+			netdev += fmt.Sprintf(",queues=%d", queues)
+		}
+	}
+	return netdev, nil
+}
+
+// getMacvtapNetworking creates a macvtap on the parent link given in the config
+// and passes its character device to qemu.
+func (r *Runner) getMacvtapNetworking(id string) (string, error) {
+	c := r.config.Interfaces[id]
+	mode, err := tuntap.ParseMacvtapMode(c.Mode)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("mvt%d", crc32.ChecksumIEEE([]byte(r.firmware+os.Getenv("USER")+id)))
+	r.ensurePrivileges()
+	err = r.tt.CreateMacvtap(name, c.Parent, mode, r.nicMac(id))
+	if err != nil {
+		return "", fmt.Errorf("create macvtap: %w", err)
+	}
+	r.addCleanup(func() error {
+		err := r.tt.DeleteMacvtap(name)
+		if err != nil {
+			return fmt.Errorf("delete macvtap: %w", err)
+		}
+		return nil
+	})
+	if c.MTU != 0 {
+		err = r.tt.SetMacvtapMTU(name, c.MTU)
+		if err != nil {
+			_ = r.teardown()
+			return "", fmt.Errorf("macvtap mtu: %w", err)
+		}
+	}
+	f, err := r.tt.OpenMacvtap(name)
+	if err != nil {
+		_ = r.teardown()
+		return "", fmt.Errorf("open macvtap: %w", err)
+	}
+	r.files = append(r.files, f)
+	r.addCleanup(f.Close)
+	r.taps[id] = name
+	netdev := fmt.Sprintf("tap,id=%s,fd=%d", id, 2+len(r.files))
+	if err := tuntap.VhostNetAvailable(); err == nil {
+		netdev += ",vhost=on"
+	}
+	return netdev, nil
+}
+
+// nicMac returns the mac address of the NIC with the given netdev id.
+func (r *Runner) nicMac(id string) string {
+	if id == "net0" {
+		return r.mac
+	}
+	return macFromInt("52:54", crc32.ChecksumIEEE([]byte(r.firmware+os.Getenv("USER")+id)))
+}
+
+// nicDevice returns the -device option of the NIC with the given netdev id
+// and records its mac address for the state file.
+func (r *Runner) nicDevice(id, mac string) string {
+	r.macs[id] = mac
+	device := fmt.Sprintf("virtio-net-pci,netdev=%s,mac=%s", id, mac)
+	if queues := r.queues[id]; queues > 1 {
+		// one MSI-X vector per rx and tx queue, plus config and control
+		device += fmt.Sprintf(",mq=on,vectors=%d", 2*queues+2)
+	}
+	if mtu := r.config.Interfaces[id].MTU; mtu != 0 {
+		// tell the guest which MTU to use
+		device += fmt.Sprintf(",host_mtu=%d", mtu)
+	}
+	return device
+}
+
+// parseVLANFlags turns the -vlan, -trunk and -native flags into the port configuration.
+func parseVLANFlags(access uint, trunk string, native uint) (tuntap.PortVLANs, error) {
+	v := tuntap.PortVLANs{Access: uint16(access), Native: uint16(native)}
+	if trunk != "" {
+		for _, s := range strings.Split(trunk, ",") {
+			vid, err := strconv.ParseUint(strings.TrimSpace(s), 10, 12)
+			if err != nil {
+				return v, fmt.Errorf("bad vlan %q in -trunk", s)
+			}
+			v.Trunk = append(v.Trunk, uint16(vid))
+		}
+	}
+	if access > 4094 || native > 4094 {
+		return v, fmt.Errorf("vlan out of range")
+	}
+	return v, v.Validate()
+}
+
+// ensurePrivileges sets up the privileges of the VM's tap manager on first use,
+// and starts recording its commands with -record.
+func (r *Runner) ensurePrivileges() {
+	if !r.privileged {
+		setupPrivileges(r.tt)
+		r.privileged = true
+		if r.record != "" {
+			r.recording = r.tt.Record()
+		}
+	}
+}
+
+// saveRecording writes the commands recorded with -record, including the teardown.
+func (r *Runner) saveRecording() {
+	if r.recording == nil {
+		return
+	}
+	err := r.recording.Save(r.record)
+	if err != nil {
+		fmt.Printf("Saving recording: %v\n", err)
+		return
+	}
+	fmt.Printf("Recorded %s\n", r.record)
+}
+
+// setupPrivileges picks how the tap manager gets to run ip: through the helper if
+// it is running, with our own CAP_NET_ADMIN if we have it, and with sudo as a last resort.
+// In a namespace it uses sudo unless we are root.
+func setupPrivileges(tt *tuntap.Manager) {
+	if tt.Namespace() != "" {
+		// ip netns exec needs more than the helper allows or CAP_NET_ADMIN gives
+		if os.Geteuid() != 0 {
+			tt.SetSudo(true)
+		}
+		return
+	}
+	if _, err := os.Stat(privhelper.DefaultSocket); err == nil {
+		// the helper does the privileged work, no sudo needed.
+		tt.OverrideCommander(privhelper.NewClient(privhelper.DefaultSocket))
+		return
+	}
+	err := tt.UseNetAdmin()
+	if err == nil {
+		return
+	}
+	fmt.Printf("Falling back to sudo, %v\n", err)
+	tt.SetSudo(true)
+}
+
+// loadManager returns a tap manager that knows the current taps and bridges in
+// the namespace, the host one if empty, for subcommands acting on a running VM.
+func loadManager(namespace string) (*tuntap.Manager, error) {
+	tt := tuntap.New()
+	tt.SetNamespace(namespace)
+	setupPrivileges(tt)
+	err := tt.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load taps: %w", err)
+	}
+	return tt, nil
 }
 
 func (r *Runner) allocatePort() {
@@ -160,6 +623,12 @@ func generateTapName(input ...string) string {
 	return fmt.Sprintf("tap%d", hash)
 }
 
+// vmName derives the name of the VM from its image, /images/r1.qcow2 becomes r1.
+func vmName(firmware string) string {
+	base := filepath.Base(firmware)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
 func macFromInt(prefix string, i uint32) string {
 	// split into bytes:
 	b1, b2, b3, b4 := byte(i>>24), byte(i>>16), byte(i>>8), byte(i)
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"strings"
+)
+
+// mirrorConfig copies the traffic of one of the VM's interfaces to a monitor port.
+type mirrorConfig struct {
+	// Source is the interface id of this VM, net0 if empty.
+	Source string `json:"source,omitempty"`
+	// Target is <vm>:<iface> of another running VM, or the name of a tap or bridge port.
+	Target string `json:"target"`
+	// Direction is ingress (sent by the VM), egress (received by the VM) or both.
+	Direction string `json:"direction,omitempty"`
+}
+
+// applyMirrors sets up the mirrors from the config, they are removed on teardown.
+func (r *Runner) applyMirrors() error {
+	sources := make(map[string]bool)
+	for _, c := range r.config.Mirrors {
+		source := c.Source
+		if source == "" {
+			source = "net0"
+		}
+		tapName, ok := r.taps[source]
+		if !ok {
+			return fmt.Errorf("cannot mirror %s, it has no tap device (network %s)", source, r.backend)
+		}
+		direction, err := tuntap.ParseMirrorDirection(c.Direction)
+		if err != nil {
+			return err
+		}
+		target, err := resolveMirrorTarget(c.Target)
+		if err != nil {
+			return err
+		}
+		err = r.tt.AddMirror(tapName, target, direction)
+		if err != nil {
+			return fmt.Errorf("mirroring %s: %w", source, err)
+		}
+		fmt.Printf("Mirroring %s (%s) %s to %s\n", source, tapName, direction, target)
+		if !sources[tapName] {
+			sources[tapName] = true
+			r.addCleanup(func() error {
+				return r.tt.RemoveMirrors(tapName)
+			})
+		}
+	}
+	return nil
+}
+
+// resolveMirrorTarget turns <vm>:<iface> into the tap of that VM, other targets are device names.
+func resolveMirrorTarget(target string) (string, error) {
+	vm, iface, ok := strings.Cut(target, ":")
+	if !ok {
+		return target, nil
+	}
+	return vmTap(vm, iface)
+}
@@ -0,0 +1,424 @@
+// Package netflow collects flow records exported by routers as NetFlow v5, NetFlow v9
+// (RFC 3954) or IPFIX (RFC 7011). Templates are kept per exporter, and records of
+// all versions come out as the same Flow.
+package netflow
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Flow is a decoded flow record.
+type Flow struct {
+	Time     time.Time  `json:"time"` // when it was exported
+	Exporter netip.Addr `json:"exporter"`
+	Version  int        `json:"version"`
+	Src      netip.Addr `json:"src"`
+	Dst      netip.Addr `json:"dst"`
+	SrcPort  uint16     `json:"src_port,omitempty"`
+	DstPort  uint16     `json:"dst_port,omitempty"`
+	Proto    uint8      `json:"proto"`
+	Bytes    uint64     `json:"bytes"`
+	Packets  uint64     `json:"packets"`
+	TCPFlags uint8      `json:"tcp_flags,omitempty"`
+	In       uint32     `json:"in_if,omitempty"`
+	Out      uint32     `json:"out_if,omitempty"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	// Other holds the fields without a place above in hex, by information element
+	// id, prefixed with the enterprise number for enterprise specific elements.
+	Other map[string]string `json:"other,omitempty"`
+}
+
+// Information elements (IANA IPFIX, the same numbers as NetFlow v9).
+const (
+	ieOctetDelta     = 1
+	iePacketDelta    = 2
+	ieProtocol       = 4
+	ieTCPFlags       = 6
+	ieSrcPort        = 7
+	ieSrcIPv4        = 8
+	ieIngress        = 10
+	ieDstPort        = 11
+	ieDstIPv4        = 12
+	ieEgress         = 14
+	ieLastSwitched   = 21 // milliseconds of system uptime, v9
+	ieFirstSwitched  = 22
+	ieSrcIPv6        = 27
+	ieDstIPv6        = 28
+	ieOctetTotal     = 85
+	iePacketTotal    = 86
+	ieFlowStartSec   = 150
+	ieFlowEndSec     = 151
+	ieFlowStartMilli = 152
+	ieFlowEndMilli   = 153
+)
+
+const variableLength = 65535
+
+type field struct {
+	id         uint16
+	enterprise uint32
+	length     uint16
+}
+
+type templateKey struct {
+	exporter netip.Addr
+	domain   uint32 // v9 source id, IPFIX observation domain
+	id       uint16
+}
+
+// header is what the records of a packet share.
+type header struct {
+	version  int
+	exporter netip.Addr
+	time     time.Time
+	uptime   time.Duration // v9 system uptime at export
+}
+
+// Collector decodes export packets.
+type Collector struct {
+	mu        sync.Mutex
+	templates map[templateKey][]field
+	// OnFlow is called for every flow received by Serve.
+	OnFlow func(Flow)
+}
+
+// NewCollector returns a collector without templates.
+func NewCollector() *Collector {
+	return &Collector{templates: make(map[templateKey][]field)}
+}
+
+// Serve decodes the packets on the connection until the context is cancelled.
+func (c *Collector) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("netflow read: %w", err)
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		flows, err := c.Decode(addr.AddrPort().Addr().Unmap(), buf[:n])
+		if err != nil {
+			log.Printf("netflow: from %s: %v", from, err)
+		}
+		if c.OnFlow != nil {
+			for _, f := range flows {
+				c.OnFlow(f)
+			}
+		}
+	}
+}
+
+// Decode returns the flows in a packet from the exporter. Templates in it are kept
+// for later packets, records of templates not seen yet are skipped.
+func (c *Collector) Decode(exporter netip.Addr, b []byte) ([]Flow, error) {
+	if len(b) < 2 {
+		return nil, errors.New("short packet")
+	}
+	switch v := binary.BigEndian.Uint16(b); v {
+	case 5:
+		return decodeV5(exporter, b)
+	case 9:
+		return c.decodeV9(exporter, b)
+	case 10:
+		return c.decodeIPFIX(exporter, b)
+	default:
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+}
+
+func decodeV5(exporter netip.Addr, b []byte) ([]Flow, error) {
+	const headerLen, recordLen = 24, 48
+	if len(b) < headerLen {
+		return nil, errors.New("short v5 header")
+	}
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < headerLen+count*recordLen {
+		return nil, fmt.Errorf("v5 packet of %d bytes too short for %d records", len(b), count)
+	}
+	uptime := time.Duration(binary.BigEndian.Uint32(b[4:])) * time.Millisecond
+	h := header{
+		version:  5,
+		exporter: exporter,
+		time:     time.Unix(int64(binary.BigEndian.Uint32(b[8:])), int64(binary.BigEndian.Uint32(b[12:]))).UTC(),
+		uptime:   uptime,
+	}
+	flows := make([]Flow, 0, count)
+	for i := 0; i < count; i++ {
+		r := b[headerLen+i*recordLen:]
+		f := h.flow()
+		f.Src = netip.AddrFrom4([4]byte(r[0:4]))
+		f.Dst = netip.AddrFrom4([4]byte(r[4:8]))
+		f.In = uint32(binary.BigEndian.Uint16(r[12:]))
+		f.Out = uint32(binary.BigEndian.Uint16(r[14:]))
+		f.Packets = uint64(binary.BigEndian.Uint32(r[16:]))
+		f.Bytes = uint64(binary.BigEndian.Uint32(r[20:]))
+		f.Start = h.sinceBoot(uint64(binary.BigEndian.Uint32(r[24:])))
+		f.End = h.sinceBoot(uint64(binary.BigEndian.Uint32(r[28:])))
+		f.SrcPort = binary.BigEndian.Uint16(r[32:])
+		f.DstPort = binary.BigEndian.Uint16(r[34:])
+		f.TCPFlags = r[37]
+		f.Proto = r[38]
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+func (c *Collector) decodeV9(exporter netip.Addr, b []byte) ([]Flow, error) {
+	const headerLen = 20
+	if len(b) < headerLen {
+		return nil, errors.New("short v9 header")
+	}
+	h := header{
+		version:  9,
+		exporter: exporter,
+		time:     time.Unix(int64(binary.BigEndian.Uint32(b[8:])), 0).UTC(),
+		uptime:   time.Duration(binary.BigEndian.Uint32(b[4:])) * time.Millisecond,
+	}
+	domain := binary.BigEndian.Uint32(b[16:])
+	return c.decodeSets(h, domain, b[headerLen:], 0, 1)
+}
+
+func (c *Collector) decodeIPFIX(exporter netip.Addr, b []byte) ([]Flow, error) {
+	const headerLen = 16
+	if len(b) < headerLen {
+		return nil, errors.New("short ipfix header")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < headerLen || length > len(b) {
+		return nil, fmt.Errorf("ipfix message length %d of %d bytes", length, len(b))
+	}
+	h := header{
+		version:  10,
+		exporter: exporter,
+		time:     time.Unix(int64(binary.BigEndian.Uint32(b[4:])), 0).UTC(),
+	}
+	domain := binary.BigEndian.Uint32(b[12:])
+	return c.decodeSets(h, domain, b[headerLen:length], 2, 3)
+}
+
+// decodeSets decodes the flowsets of v9 or the sets of IPFIX, which differ in
+// the ids of the template sets and in IPFIX having enterprise fields.
+func (c *Collector) decodeSets(h header, domain uint32, b []byte, templateSet, optionsSet uint16) ([]Flow, error) {
+	var flows []Flow
+	for len(b) >= 4 {
+		id, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			return flows, fmt.Errorf("set %d of length %d in %d bytes", id, length, len(b))
+		}
+		set := b[4:length]
+		b = b[length:]
+		var err error
+		switch {
+		case id == templateSet:
+			err = c.readTemplates(h, domain, set, false)
+		case id == optionsSet:
+			err = c.readTemplates(h, domain, set, true)
+		case id >= 256:
+			c.mu.Lock()
+			fields, ok := c.templates[templateKey{h.exporter, domain, id}]
+			c.mu.Unlock()
+			if ok {
+				flows = append(flows, h.records(fields, set)...)
+			}
+		}
+		if err != nil {
+			return flows, err
+		}
+	}
+	return flows, nil
+}
+
+// readTemplates stores the templates of a set. Options templates are stored too,
+// their records come out as flows with the scope fields in Other.
+func (c *Collector) readTemplates(h header, domain uint32, b []byte, options bool) error {
+	for len(b) >= 4 {
+		id, count := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if id < 256 {
+			return nil // padding
+		}
+		if options {
+			if len(b) < 2 {
+				return errors.New("truncated options template")
+			}
+			if h.version == 9 {
+				// v9 has the scope and option lengths in bytes, IPFIX the field count and scope count
+				count = (count + int(binary.BigEndian.Uint16(b))) / 4
+			}
+			b = b[2:]
+		}
+		fields := make([]field, 0, count)
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return fmt.Errorf("template %d truncated", id)
+			}
+			f := field{id: binary.BigEndian.Uint16(b), length: binary.BigEndian.Uint16(b[2:])}
+			b = b[4:]
+			if h.version == 10 && f.id&0x8000 != 0 {
+				if len(b) < 4 {
+					return fmt.Errorf("template %d truncated", id)
+				}
+				f.id &^= 0x8000
+				f.enterprise = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			fields = append(fields, f)
+		}
+		c.mu.Lock()
+		c.templates[templateKey{h.exporter, domain, id}] = fields
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// records decodes the data records of a set, the rest is padding.
+func (h header) records(fields []field, b []byte) []Flow {
+	var flows []Flow
+	for {
+		f := h.flow()
+		rest, ok := h.record(&f, fields, b)
+		if !ok || len(rest) == len(b) {
+			return flows
+		}
+		flows = append(flows, f)
+		b = rest
+	}
+}
+
+// record decodes one record, it returns false if b does not hold a whole record.
+func (h header) record(f *Flow, fields []field, b []byte) ([]byte, bool) {
+	if len(fields) == 0 {
+		return nil, false
+	}
+	for _, fd := range fields {
+		n := int(fd.length)
+		if fd.length == variableLength {
+			if len(b) < 1 {
+				return nil, false
+			}
+			n, b = int(b[0]), b[1:]
+			if n == 255 {
+				if len(b) < 2 {
+					return nil, false
+				}
+				n, b = int(binary.BigEndian.Uint16(b)), b[2:]
+			}
+		}
+		if len(b) < n {
+			return nil, false
+		}
+		h.set(f, fd, b[:n])
+		b = b[n:]
+	}
+	return b, true
+}
+
+// set puts the value of a field in the flow.
+func (h header) set(f *Flow, fd field, v []byte) {
+	if fd.enterprise == 0 {
+		switch {
+		case (fd.id == ieSrcIPv4 || fd.id == ieDstIPv4) && len(v) == 4,
+			(fd.id == ieSrcIPv6 || fd.id == ieDstIPv6) && len(v) == 16:
+			a, _ := netip.AddrFromSlice(v)
+			if fd.id == ieSrcIPv4 || fd.id == ieSrcIPv6 {
+				f.Src = a
+			} else {
+				f.Dst = a
+			}
+			return
+		case len(v) <= 8:
+			if h.setNumber(f, fd.id, number(v)) {
+				return
+			}
+		}
+	}
+	if f.Other == nil {
+		f.Other = make(map[string]string)
+	}
+	key := strconv.Itoa(int(fd.id))
+	if fd.enterprise != 0 {
+		key = strconv.FormatUint(uint64(fd.enterprise), 10) + "." + key
+	}
+	f.Other[key] = hex.EncodeToString(v)
+}
+
+// setNumber sets a numeric field, it returns false for elements it does not know.
+func (h header) setNumber(f *Flow, id uint16, n uint64) bool {
+	switch id {
+	case ieOctetDelta, ieOctetTotal:
+		f.Bytes = n
+	case iePacketDelta, iePacketTotal:
+		f.Packets = n
+	case ieProtocol:
+		f.Proto = uint8(n)
+	case ieTCPFlags:
+		f.TCPFlags = uint8(n)
+	case ieSrcPort:
+		f.SrcPort = uint16(n)
+	case ieDstPort:
+		f.DstPort = uint16(n)
+	case ieIngress:
+		f.In = uint32(n)
+	case ieEgress:
+		f.Out = uint32(n)
+	case ieFirstSwitched:
+		f.Start = h.sinceBoot(n)
+	case ieLastSwitched:
+		f.End = h.sinceBoot(n)
+	case ieFlowStartSec, ieFlowEndSec, ieFlowStartMilli, ieFlowEndMilli:
+		var t time.Time
+		if id == ieFlowStartSec || id == ieFlowEndSec {
+			t = time.Unix(int64(n), 0).UTC()
+		} else {
+			t = time.UnixMilli(int64(n)).UTC()
+		}
+		if id == ieFlowStartSec || id == ieFlowStartMilli {
+			f.Start = &t
+		} else {
+			f.End = &t
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (h header) flow() Flow {
+	return Flow{Time: h.time, Exporter: h.exporter, Version: h.version}
+}
+
+// sinceBoot converts milliseconds of system uptime to a time.
+func (h header) sinceBoot(ms uint64) *time.Time {
+	t := h.time.Add(time.Duration(ms)*time.Millisecond - h.uptime)
+	return &t
+}
+
+// number decodes an unsigned integer of up to 8 bytes, exporters may shorten fields.
+func number(v []byte) uint64 {
+	var n uint64
+	for _, b := range v {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+var exporter = netip.MustParseAddr("10.10.0.11")
+
+func u16(b []byte, v ...uint16) []byte {
+	for _, x := range v {
+		b = binary.BigEndian.AppendUint16(b, x)
+	}
+	return b
+}
+
+func u32(b []byte, v ...uint32) []byte {
+	for _, x := range v {
+		b = binary.BigEndian.AppendUint32(b, x)
+	}
+	return b
+}
+
+// set wraps the body in a set or flowset header.
+func set(id uint16, body []byte) []byte {
+	return append(u16(nil, id, uint16(4+len(body))), body...)
+}
+
+func TestDecode_v5(t *testing.T) {
+	b := u16(nil, 5, 1)
+	b = u32(b, 60000, 1700000000, 0, 1) // uptime, secs, nsecs, sequence
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 10, 0, 0, 1, 10, 0, 0, 2, 0, 0, 0, 0) // src, dst, nexthop
+	b = u16(b, 3, 4)                                    // in, out
+	b = u32(b, 10, 1500, 50000, 59000)                  // packets, bytes, first, last
+	b = u16(b, 1234, 22)
+	b = append(b, 0, 0x12, 6, 0)
+	b = append(b, make([]byte, 8)...)
+	flows, err := NewCollector().Decode(exporter, b)
+	if err != nil || len(flows) != 1 {
+		t.Fatalf("expected one flow, got %v %v", flows, err)
+	}
+	f := flows[0]
+	if f.Src != netip.MustParseAddr("10.0.0.1") || f.Dst != netip.MustParseAddr("10.0.0.2") || f.SrcPort != 1234 || f.DstPort != 22 ||
+		f.Proto != 6 || f.Bytes != 1500 || f.Packets != 10 || f.In != 3 || f.Out != 4 || f.TCPFlags != 0x12 {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	if want := time.Unix(1700000000-10, 0).UTC(); !f.Start.Equal(want) {
+		t.Errorf("expected start %s, got %s", want, f.Start)
+	}
+}
+
+func TestDecode_v9(t *testing.T) {
+	c := NewCollector()
+	header := func(count uint16) []byte {
+		b := u16(nil, 9, count)
+		return u32(b, 60000, 1700000000, 1, 42)
+	}
+	data := set(256, append(append([]byte{192, 0, 2, 1, 192, 0, 2, 2}, u32(nil, 1000)...), 17, 0, 0, 0)) // padded
+	// data before its template is skipped
+	flows, err := c.Decode(exporter, append(header(1), data...))
+	if err != nil || len(flows) != 0 {
+		t.Fatalf("expected no flows, got %v %v", flows, err)
+	}
+	tmpl := set(0, u16(nil, 256, 4, ieSrcIPv4, 4, ieDstIPv4, 4, ieOctetDelta, 4, ieProtocol, 1))
+	flows, err = c.Decode(exporter, append(append(header(2), tmpl...), data...))
+	if err != nil || len(flows) != 1 {
+		t.Fatalf("expected one flow, got %v %v", flows, err)
+	}
+	if f := flows[0]; f.Src != netip.MustParseAddr("192.0.2.1") || f.Bytes != 1000 || f.Proto != 17 || f.Version != 9 {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	// templates are per exporter
+	flows, _ = c.Decode(netip.MustParseAddr("10.10.0.12"), append(header(1), data...))
+	if len(flows) != 0 {
+		t.Errorf("expected no flows from another exporter, got %v", flows)
+	}
+}
+
+func TestDecode_ipfix(t *testing.T) {
+	c := NewCollector()
+	tmpl := u16(nil, 300, 5, ieSrcIPv6, 16, ieDstIPv6, 16, iePacketDelta, 8, ieFlowStartMilli, 8)
+	tmpl = u16(tmpl, 0x8000|12, variableLength)
+	tmpl = u32(tmpl, 9) // enterprise
+	record := append(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::2").AsSlice()...)
+	record = binary.BigEndian.AppendUint64(record, 7)
+	record = binary.BigEndian.AppendUint64(record, 1700000000123)
+	record = append(record, 3, 'a', 'b', 'c')
+	body := append(set(2, tmpl), set(300, append(record, record...))...)
+	msg := u16(nil, 10, uint16(16+len(body)))
+	msg = u32(msg, 1700000001, 1, 0)
+	msg = append(msg, body...)
+	flows, err := c.Decode(exporter, msg)
+	if err != nil || len(flows) != 2 {
+		t.Fatalf("expected two flows, got %v %v", flows, err)
+	}
+	f := flows[1]
+	if f.Src != netip.MustParseAddr("2001:db8::1") || f.Packets != 7 || f.Version != 10 || f.Other["9.12"] != "616263" {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	if want := time.UnixMilli(1700000000123).UTC(); !f.Start.Equal(want) {
+		t.Errorf("expected start %s, got %s", want, f.Start)
+	}
+}
+
+func TestDecode_errors(t *testing.T) {
+	c := NewCollector()
+	for _, b := range [][]byte{{0}, u16(nil, 7, 0), u16(nil, 5, 3), append(u16(nil, 10, 100), make([]byte, 14)...)} {
+		if _, err := c.Decode(exporter, b); err == nil {
+			t.Errorf("%x: expected an error", b)
+		}
+	}
+}
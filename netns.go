@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"net/netip"
+)
+
+// namespaceConfig puts the bridges and taps of the VM in a network namespace, so a
+// misconfigured lab can't break the networking of the host.
+type namespaceConfig struct {
+	Name string      `json:"name"`
+	Veth *vethConfig `json:"veth,omitempty"`
+}
+
+// vethConfig connects the namespace to the host.
+type vethConfig struct {
+	// Host is the end of the pair in the host namespace.
+	Host string `json:"host"`
+	// Peer is the end in the namespace, named like the host end if empty.
+	Peer string `json:"peer,omitempty"`
+	// Address is a host address with prefix length for the host end.
+	Address string `json:"address,omitempty"`
+	// Bridge in the namespace the peer joins, the one given with -bridge if empty.
+	Bridge string `json:"bridge,omitempty"`
+}
+
+func (c vethConfig) veth() (tuntap.Veth, error) {
+	v := tuntap.Veth{Host: c.Host, Peer: c.Peer, Bridge: c.Bridge}
+	if c.Host == "" {
+		return v, fmt.Errorf("veth without host end")
+	}
+	if c.Address != "" {
+		p, err := netip.ParsePrefix(c.Address)
+		if err != nil {
+			return v, fmt.Errorf("address: %w", err)
+		}
+		v.Address = p
+	}
+	return v, nil
+}
+
+// connectNamespace creates the veth pair from the config, unless an earlier VM did.
+// It is left in place when the VM stops, like the namespace.
+func (r *Runner) connectNamespace() error {
+	c := r.config.Namespace
+	if c == nil || c.Veth == nil || r.backend != "tap" {
+		return nil
+	}
+	v, err := c.Veth.veth()
+	if err != nil {
+		return err
+	}
+	if v.Bridge == "" {
+		v.Bridge = r.bridge
+	}
+	return r.tt.ConnectNamespace(v)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"io/fs"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// failingExecutor fails every command the tap manager runs.
+type failingExecutor struct {
+	err error
+}
+
+func (e failingExecutor) Run(path string, args ...string) ([]byte, error) {
+	return nil, fmt.Errorf("executing %s: %w", path, e.err)
+}
+
+func newNetworkRunner(err error) *Runner {
+	tt := tuntap.New()
+	tt.OverrideCommander(failingExecutor{err: err})
+	return &Runner{
+		tt:         tt,
+		firmware:   "r1.qcow2",
+		name:       "r1",
+		network:    "auto",
+		bridge:     "br0",
+		taps:       make(map[string]string),
+		config:     &vmConfig{},
+		queues:     make(map[string]int),
+		macs:       make(map[string]string),
+		privileged: true,
+	}
+}
+
+func TestRunner_getNativeNetworking_fallback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tap networking is linux only")
+	}
+	t.Setenv("PATH", "") // no passt, so the fallback is qemu user networking
+	r := newNetworkRunner(fs.ErrPermission)
+	netdev, err := r.getNativeNetworking("net0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(netdev, "user,id=net0") || r.backend != "user" {
+		t.Errorf("expected user networking, got %q on %s", netdev, r.backend)
+	}
+}
+
+func TestRunner_getNativeNetworking_noFallback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("tap networking is linux only")
+	}
+	failure := errors.New("exit status 1")
+	r := newNetworkRunner(failure)
+	_, err := r.getNativeNetworking("net0")
+	if !errors.Is(err, failure) {
+		t.Errorf("expected %v, got %v", failure, err)
+	}
+	if r.backend != "" || len(r.forwards) != 0 {
+		t.Errorf("expected no rootless networking, got %s with %d forwards", r.backend, len(r.forwards))
+	}
+	// -net tap never falls back
+	r = newNetworkRunner(fs.ErrPermission)
+	r.network = "tap"
+	_, err = r.getNativeNetworking("net0")
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
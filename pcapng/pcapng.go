@@ -0,0 +1,142 @@
+// Package pcapng writes packet captures in the pcapng format, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html
+package pcapng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	blockSectionHeader    = 0x0A0D0D0A
+	blockInterfaceDesc    = 0x00000001
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1A2B3C4D
+	optEndOfOpt           = 0
+	optShbUserAppl        = 4
+	optIfName             = 2
+	optIfDescription      = 3
+	optIfTsresol          = 9
+	optEpbFlags           = 2
+	LinkTypeEthernet      = 1
+	defaultSnapLen        = 262144
+	microsecondResolution = 6
+)
+
+// Direction of a packet, as recorded in the epb_flags option.
+type Direction uint32
+
+const (
+	DirectionUnknown  Direction = 0
+	DirectionInbound  Direction = 1
+	DirectionOutbound Direction = 2
+)
+
+// Interface describes the interface the packets were captured on.
+type Interface struct {
+	Name        string
+	Description string
+	LinkType    uint16
+	SnapLen     uint32
+}
+
+// Writer writes a pcapng section with a single interface.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the section header and the interface description to w.
+func NewWriter(w io.Writer, application string, iface Interface) (*Writer, error) {
+	pw := &Writer{w: w}
+	var opts []byte
+	if application != "" {
+		opts = appendOption(opts, optShbUserAppl, []byte(application))
+	}
+	opts = appendEndOfOpt(opts)
+	shb := make([]byte, 0, 16+len(opts))
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	shb = append(shb, opts...)
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, fmt.Errorf("writing section header: %w", err)
+	}
+
+	if iface.LinkType == 0 {
+		iface.LinkType = LinkTypeEthernet
+	}
+	if iface.SnapLen == 0 {
+		iface.SnapLen = defaultSnapLen
+	}
+	opts = nil
+	if iface.Name != "" {
+		opts = appendOption(opts, optIfName, []byte(iface.Name))
+	}
+	if iface.Description != "" {
+		opts = appendOption(opts, optIfDescription, []byte(iface.Description))
+	}
+	opts = appendOption(opts, optIfTsresol, []byte{microsecondResolution})
+	opts = appendEndOfOpt(opts)
+	idb := make([]byte, 0, 8+len(opts))
+	idb = binary.LittleEndian.AppendUint16(idb, iface.LinkType)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, iface.SnapLen)
+	idb = append(idb, opts...)
+	if err := pw.writeBlock(blockInterfaceDesc, idb); err != nil {
+		return nil, fmt.Errorf("writing interface description: %w", err)
+	}
+	return pw, nil
+}
+
+// WritePacket writes an enhanced packet block. origLen is the length of the
+// packet on the wire, which may be more than what was captured.
+func (pw *Writer) WritePacket(ts time.Time, data []byte, origLen int, dir Direction) error {
+	us := uint64(ts.UnixMicro())
+	body := make([]byte, 0, 20+len(data)+16)
+	body = binary.LittleEndian.AppendUint32(body, 0) // interface id
+	body = binary.LittleEndian.AppendUint32(body, uint32(us>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(us))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(origLen))
+	body = append(body, data...)
+	body = pad(body)
+	if dir != DirectionUnknown {
+		body = appendOption(body, optEpbFlags, binary.LittleEndian.AppendUint32(nil, uint32(dir)))
+		body = appendEndOfOpt(body)
+	}
+	return pw.writeBlock(blockEnhancedPacket, body)
+}
+
+// writeBlock frames the body with the block type and the total length on both ends.
+func (pw *Writer) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	_, err := pw.w.Write(block)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pad(b)
+}
+
+func appendEndOfOpt(b []byte) []byte {
+	return binary.LittleEndian.AppendUint32(b, optEndOfOpt)
+}
+
+// pad pads to a multiple of 32 bits.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
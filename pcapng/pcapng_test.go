@@ -0,0 +1,65 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// blocks splits a pcapng stream into its blocks, checking the framing.
+func blocks(t *testing.T, data []byte) map[uint32][][]byte {
+	t.Helper()
+	found := make(map[uint32][][]byte)
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block")
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:4])
+		total := binary.LittleEndian.Uint32(data[4:8])
+		if total%4 != 0 || int(total) > len(data) {
+			t.Fatalf("bad block length %d", total)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[total-4 : total]); trailer != total {
+			t.Fatalf("trailing length %d does not match %d", trailer, total)
+		}
+		found[blockType] = append(found[blockType], data[8:total-4])
+		data = data[total:]
+	}
+	return found
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "qemu-wrapper", Interface{Name: "tap123", Description: "r1 net0"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	packet := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, 5, 6, 0x08, 0x06, 0}
+	err = w.WritePacket(ts, packet, len(packet), DirectionOutbound)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	found := blocks(t, buf.Bytes())
+	if len(found[blockSectionHeader]) != 1 || len(found[blockInterfaceDesc]) != 1 || len(found[blockEnhancedPacket]) != 1 {
+		t.Fatalf("expected one block of each kind, got %v", found)
+	}
+	if binary.LittleEndian.Uint32(found[blockSectionHeader][0]) != byteOrderMagic {
+		t.Errorf("expected byte order magic")
+	}
+	if !bytes.Contains(found[blockInterfaceDesc][0], []byte("r1 net0")) {
+		t.Errorf("expected interface description in IDB")
+	}
+	epb := found[blockEnhancedPacket][0]
+	us := uint64(binary.LittleEndian.Uint32(epb[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb[8:12]))
+	if us != uint64(ts.UnixMicro()) {
+		t.Errorf("expected timestamp %d, got %d", ts.UnixMicro(), us)
+	}
+	if capLen := binary.LittleEndian.Uint32(epb[12:16]); capLen != uint32(len(packet)) {
+		t.Errorf("expected captured length %d, got %d", len(packet), capLen)
+	}
+	if !bytes.Equal(epb[20:20+len(packet)], packet) {
+		t.Errorf("expected packet data in EPB")
+	}
+}
@@ -0,0 +1,96 @@
+// Package portfwd relays TCP connections from a host port to a service in a VM.
+package portfwd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Forwarder accepts connections and relays each one to the target.
+type Forwarder struct {
+	// Target returns the address to connect to. It is called for every connection,
+	// so the target may change while the forwarder runs, or not be known yet.
+	Target func() (string, error)
+	// DialTimeout limits connecting to the target, 5 seconds if zero.
+	DialTimeout time.Duration
+}
+
+// New returns a forwarder to a fixed address.
+func New(target string) *Forwarder {
+	return &Forwarder{Target: func() (string, error) { return target, nil }}
+}
+
+// Serve accepts connections until the context is cancelled. The listener and the
+// open connections are closed when it returns.
+func (f *Forwarder) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("forward accept: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			defer conn.Close()
+			err := f.relay(ctx, conn)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("forward %s: %v", ln.Addr(), err)
+			}
+		}()
+	}
+}
+
+// relay connects to the target and copies in both directions until both are done.
+func (f *Forwarder) relay(ctx context.Context, conn net.Conn) error {
+	target, err := f.Target()
+	if err != nil {
+		return err
+	}
+	timeout := f.DialTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	d := net.Dialer{Timeout: timeout}
+	up, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = up.Close() })
+	defer stop()
+	defer up.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(up, conn)
+		closeWrite(up)
+	}()
+	_, _ = io.Copy(conn, up)
+	closeWrite(conn)
+	<-done
+	return nil
+}
+
+// closeWrite passes the end of one direction on, the other may still have data.
+func closeWrite(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+		return
+	}
+	_ = c.Close()
+}
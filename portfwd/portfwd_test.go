@@ -0,0 +1,108 @@
+package portfwd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return ln
+}
+
+// echo serves a target that sends back what it reads until the client is done.
+func echo(t *testing.T) net.Listener {
+	ln := listen(t)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+func serve(t *testing.T, f *Forwarder) (net.Listener, chan error, context.CancelFunc) {
+	ln := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.Serve(ctx, ln) }()
+	return ln, done, cancel
+}
+
+func TestForwarder(t *testing.T) {
+	target := echo(t)
+	ln, done, cancel := serve(t, New(target.Addr().String()))
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.(*net.TCPConn).CloseWrite()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("expected hello, got %q", got)
+	}
+
+	// an open connection is closed when the forwarder stops
+	open, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer open.Close()
+	if _, err := open.Write([]byte("x")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(open, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	_ = open.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := open.Read(buf); err == nil {
+		t.Errorf("expected the connection to be closed")
+	}
+}
+
+func TestForwarder_noTarget(t *testing.T) {
+	f := &Forwarder{Target: func() (string, error) { return "", errors.New("no lease") }}
+	ln, done, cancel := serve(t, f)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %d bytes and %v", n, err)
+	}
+}
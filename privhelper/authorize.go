@@ -0,0 +1,193 @@
+package privhelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// caller is the peer on the other end of the socket, as reported by the kernel.
+type caller struct {
+	uid    int
+	user   string
+	groups []string
+}
+
+func (c caller) String() string {
+	return fmt.Sprintf("%s(%d)", c.user, c.uid)
+}
+
+var errDenied = errors.New("denied by policy")
+
+// authorize checks an ip, bridge or tc command line against the policy. Only the handful of
+// commands tuntap.Manager issues are accepted. Read-only listings are always
+// allowed, taps may only be touched by their owner, bridges must match the policy and
+// tc may only change the qdiscs and filters of the caller's taps, and mirror to their
+// taps and allowed bridges.
+// It returns the arguments to execute.
+func (s *Server) authorize(c caller, path string, args []string) ([]string, error) {
+	switch path {
+	case "ip":
+		return s.authorizeIP(c, args)
+	case "bridge":
+		return s.authorizeBridge(c, args)
+	case "tc":
+		return s.authorizeTC(c, args)
+	}
+	return nil, fmt.Errorf("%w: only ip, bridge and tc may be executed", errDenied)
+}
+
+func (s *Server) authorizeIP(c caller, args []string) ([]string, error) {
+	switch {
+	case match(args, "link", "show"), match(args, "-d", "link", "show"):
+		return args, nil
+	case match(args, "tuntap", "add", "dev", "*", "mode", "tap"):
+		cmd := []string{"tuntap", "add", "dev", args[3], "mode", "tap"}
+		rest := args[6:]
+		if len(rest) > 0 && rest[0] == "multi_queue" {
+			cmd = append(cmd, "multi_queue")
+			rest = rest[1:]
+		}
+		// the tap is always owned by the caller, regardless of what was asked for.
+		if len(rest) != 0 && !(len(rest) == 2 && rest[0] == "user" && rest[1] == c.user) {
+			return nil, fmt.Errorf("%w: taps must be owned by %s", errDenied, c.user)
+		}
+		return append(cmd, "user", strconv.Itoa(c.uid)), nil
+	case match(args, "tuntap", "del", "dev", "*", "mode", "tap") && (len(args) == 6 || len(args) == 7 && args[6] == "multi_queue"):
+		return args, s.checkTap(c, args[3])
+	case match(args, "link", "set", "dev", "*", "down") && len(args) == 5:
+		return args, s.checkTap(c, args[3])
+	case match(args, "link", "set", "dev", "*", "up") && len(args) == 5:
+		return args, s.checkDevice(c, args[3])
+	case match(args, "link", "set", "dev", "*", "mtu", "*") && len(args) == 6:
+		return args, s.checkDevice(c, args[3])
+	case match(args, "link", "set", "dev", "*", "address", "*") && len(args) == 6:
+		return args, s.checkTap(c, args[3])
+	case match(args, "link", "set", "dev", "*", "nomaster") && len(args) == 5:
+		return args, s.checkTap(c, args[3])
+	case match(args, "link", "set", "*", "master", "*") && len(args) == 5:
+		if err := s.checkTap(c, args[2]); err != nil {
+			return nil, err
+		}
+		return args, s.checkBridge(c, args[4])
+	case match(args, "link", "add", "name", "*", "type", "bridge") && (len(args) == 6 || len(args) == 8 && match(args[6:], "vlan_filtering", "1")):
+		return args, s.checkBridge(c, args[3])
+	case match(args, "link", "set", "dev", "*", "type", "bridge", "vlan_filtering", "1") && len(args) == 8:
+		return args, s.checkBridge(c, args[3])
+	case match(args, "link", "set", "dev", "*", "type", "bridge", "group_fwd_mask", "*") && len(args) == 8:
+		return args, s.checkBridge(c, args[3])
+	}
+	return nil, fmt.Errorf("%w: command not supported: ip %s", errDenied, strings.Join(args, " "))
+}
+
+func (s *Server) authorizeBridge(c caller, args []string) ([]string, error) {
+	switch {
+	case match(args, "vlan", "show"):
+		return args, nil
+	case match(args, "vlan", "add", "dev", "*", "vid", "*"), match(args, "vlan", "del", "dev", "*", "vid", "*"):
+		for _, flag := range args[6:] {
+			if flag != "pvid" && flag != "untagged" {
+				return nil, fmt.Errorf("%w: unsupported vlan flag %s", errDenied, flag)
+			}
+		}
+		return args, s.checkTap(c, args[3])
+	}
+	return nil, fmt.Errorf("%w: command not supported: bridge %s", errDenied, strings.Join(args, " "))
+}
+
+// authorizeTC allows qdiscs and filters to be changed on the caller's own taps.
+// Filters may only redirect or mirror to the caller's own taps or the bridges the
+// policy gives them.
+func (s *Server) authorizeTC(c caller, args []string) ([]string, error) {
+	switch {
+	case match(args, "qdisc", "show", "dev", "*"):
+		return args, nil
+	case match(args, "qdisc", "replace", "dev", "*"), match(args, "qdisc", "del", "dev", "*"):
+		return args, s.checkTap(c, args[3])
+	case match(args, "filter", "add", "dev", "*"), match(args, "filter", "del", "dev", "*"):
+		for i := 4; i < len(args)-1; i++ {
+			if args[i] == "dev" {
+				if err := s.checkDevice(c, args[i+1]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return args, s.checkTap(c, args[3])
+	}
+	return nil, fmt.Errorf("%w: command not supported: tc %s", errDenied, strings.Join(args, " "))
+}
+
+// match returns true if args starts with the given words, * matches any word.
+func match(args []string, words ...string) bool {
+	if len(args) < len(words) {
+		return false
+	}
+	for i, w := range words {
+		if w != "*" && args[i] != w {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) checkBridge(c caller, bridge string) error {
+	if !s.policy.AllowBridge(c.user, c.groups, bridge) {
+		return fmt.Errorf("%w: %s may not use bridge %s", errDenied, c, bridge)
+	}
+	return nil
+}
+
+func (s *Server) checkTap(c caller, tap string) error {
+	owner, err := s.tapOwner(tap)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDenied, err)
+	}
+	if owner != c.uid {
+		return fmt.Errorf("%w: tap %s is not owned by %s", errDenied, tap, c)
+	}
+	return nil
+}
+
+func (s *Server) isTap(name string) bool {
+	_, err := s.tapOwner(name)
+	return err == nil
+}
+
+// checkDevice allows the caller's own taps and the bridges the policy gives them.
+// Other interfaces are refused even if their name matches the policy.
+func (s *Server) checkDevice(c caller, name string) error {
+	if s.isTap(name) {
+		return s.checkTap(c, name)
+	}
+	if !s.isBridge(name) {
+		return fmt.Errorf("%w: %s is neither a tap nor a bridge", errDenied, name)
+	}
+	return s.checkBridge(c, name)
+}
+
+// sysIsBridge returns true if the interface is a bridge.
+func sysIsBridge(name string) bool {
+	if strings.ContainsAny(name, "/.") {
+		return false
+	}
+	fi, err := os.Stat("/sys/class/net/" + name + "/bridge")
+	return err == nil && fi.IsDir()
+}
+
+// sysTapOwner reads the owner of a tap device from sysfs.
+func sysTapOwner(name string) (int, error) {
+	if strings.ContainsAny(name, "/.") {
+		return 0, fmt.Errorf("bad interface name %q", name)
+	}
+	data, err := os.ReadFile("/sys/class/net/" + name + "/owner")
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a tap device", name)
+	}
+	owner, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || owner < 0 {
+		return 0, fmt.Errorf("tap %s has no owner", name)
+	}
+	return owner, nil
+}
@@ -0,0 +1,138 @@
+package privhelper
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	p, err := ParsePolicy([]byte("alice lab-*\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	s := NewServer(p)
+	owners := map[string]int{"tapa": 1000, "tapa2": 1000, "tapb": 1001}
+	s.tapOwner = func(name string) (int, error) {
+		uid, ok := owners[name]
+		if !ok {
+			return 0, fmt.Errorf("%s is not a tap device", name)
+		}
+		return uid, nil
+	}
+	s.isBridge = func(name string) bool { return name == "lab-1" || name == "br0" }
+	return s
+}
+
+func TestServer_authorize(t *testing.T) {
+	s := newTestServer(t)
+	alice := caller{uid: 1000, user: "alice"}
+	tests := []struct {
+		args    []string
+		allowed bool
+	}{
+		{[]string{"-d", "link", "show", "type", "bridge"}, true},
+		{[]string{"link", "add", "name", "lab-2", "type", "bridge", "vlan_filtering", "1"}, true},
+		{[]string{"link", "set", "dev", "br0", "type", "bridge", "vlan_filtering", "1"}, false},
+		{[]string{"link", "set", "dev", "lab-1", "type", "bridge", "group_fwd_mask", "0x4000"}, true},
+		{[]string{"link", "set", "dev", "br0", "type", "bridge", "group_fwd_mask", "0x4000"}, false},
+		{[]string{"link", "show", "type", "tun"}, true},
+		{[]string{"tuntap", "add", "dev", "tapc", "mode", "tap", "user", "alice"}, true},
+		{[]string{"tuntap", "add", "dev", "tapc", "mode", "tap", "user", "root"}, false},
+		{[]string{"tuntap", "add", "dev", "tapc", "mode", "tap", "multi_queue", "user", "alice"}, true},
+		{[]string{"tuntap", "add", "dev", "tapc", "mode", "tap", "multi_queue", "multi_queue"}, false},
+		{[]string{"tuntap", "del", "dev", "tapa", "mode", "tap"}, true},
+		{[]string{"tuntap", "del", "dev", "tapa", "mode", "tap", "multi_queue"}, true},
+		{[]string{"tuntap", "del", "dev", "tapa", "mode", "tap", "user", "alice"}, false},
+		{[]string{"tuntap", "del", "dev", "tapb", "mode", "tap"}, false},
+		{[]string{"tuntap", "del", "dev", "eth0", "mode", "tap"}, false},
+		{[]string{"link", "set", "dev", "tapa", "up"}, true},
+		{[]string{"link", "set", "dev", "lab-1", "up"}, true},
+		{[]string{"link", "set", "dev", "eth0", "up"}, false},
+		{[]string{"link", "set", "dev", "br0", "up"}, false},
+		{[]string{"link", "set", "dev", "lab-eth", "up"}, false},
+		{[]string{"link", "set", "dev", "tapb", "up"}, false},
+		{[]string{"link", "set", "dev", "tapa", "down"}, true},
+		{[]string{"link", "set", "dev", "tapa", "mtu", "9000"}, true},
+		{[]string{"link", "set", "dev", "lab-1", "mtu", "9000"}, true},
+		{[]string{"link", "set", "dev", "eth0", "mtu", "9000"}, false},
+		{[]string{"link", "set", "dev", "lab-eth", "mtu", "9000"}, false},
+		{[]string{"link", "set", "dev", "lab-1", "down"}, false},
+		{[]string{"link", "set", "tapa", "master", "lab-1"}, true},
+		{[]string{"link", "set", "tapa", "master", "br0"}, false},
+		{[]string{"link", "set", "tapb", "master", "lab-1"}, false},
+		{[]string{"link", "set", "dev", "tapa", "nomaster"}, true},
+		{[]string{"link", "set", "dev", "eth0", "nomaster"}, false},
+		{[]string{"link", "add", "name", "lab-2", "type", "bridge"}, true},
+		{[]string{"link", "add", "name", "br9", "type", "bridge"}, false},
+		{[]string{"link", "del", "eth0"}, false},
+		{[]string{"addr", "flush", "dev", "eth0"}, false},
+	}
+	for _, tt := range tests {
+		_, err := s.authorize(alice, "ip", tt.args)
+		if tt.allowed && err != nil {
+			t.Errorf("ip %v: expected allowed, got %v", tt.args, err)
+		}
+		if !tt.allowed && !errors.Is(err, errDenied) {
+			t.Errorf("ip %v: expected denied, got %v", tt.args, err)
+		}
+	}
+}
+
+func TestServer_authorize_forcesOwner(t *testing.T) {
+	s := newTestServer(t)
+	args, err := s.authorize(caller{uid: 1000, user: "alice"}, "ip", []string{"tuntap", "add", "dev", "tapc", "mode", "tap"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := []string{"tuntap", "add", "dev", "tapc", "mode", "tap", "user", "1000"}
+	if !slices.Equal(args, want) {
+		t.Errorf("expected %v, got %v", want, args)
+	}
+	args, err = s.authorize(caller{uid: 1000, user: "alice"}, "ip", []string{"tuntap", "add", "dev", "tapc", "mode", "tap", "multi_queue"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want = []string{"tuntap", "add", "dev", "tapc", "mode", "tap", "multi_queue", "user", "1000"}
+	if !slices.Equal(args, want) {
+		t.Errorf("expected %v, got %v", want, args)
+	}
+}
+
+func TestServer_authorizeOther(t *testing.T) {
+	s := newTestServer(t)
+	alice := caller{uid: 1000, user: "alice"}
+	tests := []struct {
+		path    string
+		args    []string
+		allowed bool
+	}{
+		{"bridge", []string{"vlan", "show"}, true},
+		{"bridge", []string{"vlan", "add", "dev", "tapa", "vid", "10", "pvid", "untagged"}, true},
+		{"bridge", []string{"vlan", "del", "dev", "tapa", "vid", "1"}, true},
+		{"bridge", []string{"vlan", "add", "dev", "tapb", "vid", "10"}, false},
+		{"bridge", []string{"vlan", "add", "dev", "tapa", "vid", "10", "self"}, false},
+		{"bridge", []string{"fdb", "show"}, false},
+		{"tc", []string{"qdisc", "replace", "dev", "tapa", "root", "handle", "1:", "netem", "delay", "10ms"}, true},
+		{"tc", []string{"qdisc", "del", "dev", "tapb", "root"}, false},
+		{"tc", []string{"filter", "add", "dev", "tapa", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "tapa2"}, true},
+		{"tc", []string{"filter", "add", "dev", "tapa", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "tapb"}, false},
+		{"tc", []string{"filter", "add", "dev", "tapb", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "tapa"}, false},
+		{"tc", []string{"filter", "add", "dev", "tapa", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "lab-1"}, true},
+		{"tc", []string{"filter", "add", "dev", "tapa", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "br0"}, false},
+		{"tc", []string{"filter", "add", "dev", "tapa", "ingress", "matchall", "action", "mirred", "egress", "mirror", "dev", "lab-eth0"}, false},
+		{"tc", []string{"filter", "del", "dev", "tapa", "ingress", "pref", "40000"}, true},
+		{"sh", []string{"-c", "id"}, false},
+	}
+	for _, tt := range tests {
+		_, err := s.authorize(alice, tt.path, tt.args)
+		if tt.allowed && err != nil {
+			t.Errorf("%s %v: expected allowed, got %v", tt.path, tt.args, err)
+		}
+		if !tt.allowed && !errors.Is(err, errDenied) {
+			t.Errorf("%s %v: expected denied, got %v", tt.path, tt.args, err)
+		}
+	}
+}
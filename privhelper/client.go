@@ -0,0 +1,64 @@
+package privhelper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultSocket is where the helper listens unless told otherwise.
+const DefaultSocket = "/run/tuntap-helper.sock"
+
+// Client forwards ip, bridge and tc commands to the helper. It implements tuntap.Executor,
+// so it can be handed to Manager.OverrideCommander. The manager must not use sudo.
+type Client struct {
+	socket string
+}
+
+func NewClient(socket string) *Client {
+	return &Client{socket: socket}
+}
+
+func (c *Client) Run(path string, args ...string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", c.socket, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to helper: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	err = json.NewEncoder(conn).Encode(request{Path: path, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("sending request to helper: %w", err)
+	}
+	var resp response
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("reading response from helper: %w", err)
+	}
+	if resp.Error != "" {
+		err = errors.New(resp.Error)
+		if strings.HasPrefix(resp.Error, errDenied.Error()) {
+			err = deniedError{err}
+		}
+		return nil, fmt.Errorf("helper: %w (output: %s)", err, resp.Output)
+	}
+	return []byte(resp.Output), nil
+}
+
+// deniedError is a command the policy refused, it matches fs.ErrPermission so the
+// caller can tell it from a command that failed.
+type deniedError struct {
+	error
+}
+
+func (e deniedError) Unwrap() error {
+	return e.error
+}
+
+func (e deniedError) Is(target error) bool {
+	return target == fs.ErrPermission
+}
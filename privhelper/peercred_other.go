@@ -0,0 +1,13 @@
+//go:build !linux
+
+package privhelper
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func peerUID(_ *net.UnixConn) (int, error) {
+	return 0, fmt.Errorf("peer credentials not supported on %s", runtime.GOOS)
+}
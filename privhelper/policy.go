@@ -0,0 +1,97 @@
+package privhelper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Policy decides which bridges a user may attach taps to.
+// The policy file has one rule per line, a user (or @group, or * for everyone)
+// followed by one or more bridge name patterns:
+//
+//	# user    bridges
+//	alice     lab-* br0
+//	@netlab   lab-*
+type Policy struct {
+	rules []rule
+}
+
+type rule struct {
+	subject  string // user name, @group or *
+	patterns []string
+}
+
+// LoadPolicy reads a policy file from disk.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses the policy file format.
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected user followed by bridge patterns", lineNo)
+		}
+		for _, pattern := range fields[1:] {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("line %d: bad pattern %q: %w", lineNo, pattern, err)
+			}
+		}
+		p.rules = append(p.rules, rule{subject: fields[0], patterns: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanning policy: %w", err)
+	}
+	return p, nil
+}
+
+// AllowBridge returns true if the user, being a member of groups, may use the bridge.
+func (p *Policy) AllowBridge(user string, groups []string, bridge string) bool {
+	for _, r := range p.rules {
+		if !r.matches(user, groups) {
+			continue
+		}
+		for _, pattern := range r.patterns {
+			if ok, _ := path.Match(pattern, bridge); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r rule) matches(user string, groups []string) bool {
+	switch {
+	case r.subject == "*":
+		return true
+	case strings.HasPrefix(r.subject, "@"):
+		for _, g := range groups {
+			if g == r.subject[1:] {
+				return true
+			}
+		}
+		return false
+	default:
+		return r.subject == user
+	}
+}
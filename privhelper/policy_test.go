@@ -0,0 +1,46 @@
+package privhelper
+
+import "testing"
+
+const testPolicy = `
+# user    bridges
+alice     lab-* br0
+@netlab   shared-*   # group rule
+*         public
+`
+
+func TestPolicy_AllowBridge(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	tests := []struct {
+		user   string
+		groups []string
+		bridge string
+		want   bool
+	}{
+		{"alice", nil, "lab-1", true},
+		{"alice", nil, "br0", true},
+		{"alice", nil, "br1", false},
+		{"bob", nil, "lab-1", false},
+		{"bob", []string{"netlab"}, "shared-a", true},
+		{"bob", []string{"users"}, "shared-a", false},
+		{"bob", nil, "public", true},
+	}
+	for _, tt := range tests {
+		got := p.AllowBridge(tt.user, tt.groups, tt.bridge)
+		if got != tt.want {
+			t.Errorf("AllowBridge(%s, %v, %s) = %v, want %v", tt.user, tt.groups, tt.bridge, got, tt.want)
+		}
+	}
+}
+
+func TestParsePolicy_errors(t *testing.T) {
+	for _, input := range []string{"alice", "alice lab-["} {
+		_, err := ParsePolicy([]byte(input))
+		if err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// request is sent by the client, one per connection.
+type request struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+}
+
+// response is the result of running the command.
+type response struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server runs ip, bridge and tc commands on behalf of unprivileged users, as far as the policy allows.
+type Server struct {
+	policy   *Policy
+	tapOwner func(name string) (int, error)
+	isBridge func(name string) bool
+	run      func(path string, args ...string) ([]byte, error)
+}
+
+// NewServer creates a server that enforces the given policy.
+func NewServer(policy *Policy) *Server {
+	return &Server{
+		policy:   policy,
+		tapOwner: sysTapOwner,
+		isBridge: sysIsBridge,
+		run:      runCommand,
+	}
+}
+
+// ListenAndServe listens on the unix socket at socketPath until the context is cancelled.
+// The socket is world-writable, callers are identified by their peer credentials.
+func (s *Server) ListenAndServe(ctx context.Context, socketPath string) error {
+	_ = os.Remove(socketPath)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer l.Close()
+	err = os.Chmod(socketPath, 0o666)
+	if err != nil {
+		return fmt.Errorf("chmod socket: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := lookupCaller(conn)
+	if err != nil {
+		log.Printf("identifying peer: %v", err)
+		return
+	}
+	var req request
+	err = json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		log.Printf("%s: decoding request: %v", c, err)
+		return
+	}
+	resp := s.execute(c, req)
+	err = json.NewEncoder(conn).Encode(resp)
+	if err != nil {
+		log.Printf("%s: writing response: %v", c, err)
+	}
+}
+
+func (s *Server) execute(c caller, req request) response {
+	args, err := s.authorize(c, req.Path, req.Args)
+	if err != nil {
+		log.Printf("%s: %s %v: %v", c, req.Path, req.Args, err)
+		return response{Error: err.Error()}
+	}
+	log.Printf("%s: %s %v", c, req.Path, args)
+	out, err := s.run(req.Path, args...)
+	if err != nil {
+		return response{Output: string(out), Error: err.Error()}
+	}
+	return response{Output: string(out)}
+}
+
+func runCommand(path string, args ...string) ([]byte, error) {
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return out, fmt.Errorf("%s: %w (output: %s)", path, err, out)
+		}
+		return out, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// lookupCaller identifies the peer through SO_PEERCRED and resolves its user and groups.
+func lookupCaller(conn *net.UnixConn) (caller, error) {
+	uid, err := peerUID(conn)
+	if err != nil {
+		return caller{}, err
+	}
+	c := caller{uid: uid, user: strconv.Itoa(uid)}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		// unknown users may still match a "*" rule.
+		return c, nil
+	}
+	c.user = u.Username
+	gids, err := u.GroupIds()
+	if err != nil {
+		return c, nil
+	}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		c.groups = append(c.groups, g.Name)
+	}
+	return c, nil
+}
@@ -0,0 +1,108 @@
+// Package qmp is a minimal client for the QEMU Machine Protocol.
+package qmp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client is a connection to a qemu QMP socket, in command mode.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+type command struct {
+	Execute   string `json:"execute"`
+	Arguments any    `json:"arguments,omitempty"`
+}
+
+// message is anything qemu sends: the greeting, a reply or an asynchronous event.
+type message struct {
+	QMP    json.RawMessage `json:"QMP,omitempty"`
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error,omitempty"`
+	Event string `json:"event,omitempty"`
+}
+
+// Dial connects to the QMP unix socket and negotiates capabilities.
+func Dial(socket string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to qmp: %w", err)
+	}
+	c := &Client{conn: conn, scanner: bufio.NewScanner(conn)}
+	c.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	msg, err := c.read()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("reading greeting: %w", err)
+	}
+	if msg.QMP == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unexpected greeting")
+	}
+	_, err = c.Execute("qmp_capabilities", nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) read() (message, error) {
+	var msg message
+	_ = c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return msg, err
+		}
+		return msg, fmt.Errorf("connection closed")
+	}
+	err := json.Unmarshal(c.scanner.Bytes(), &msg)
+	if err != nil {
+		return msg, fmt.Errorf("decoding qmp message: %w", err)
+	}
+	return msg, nil
+}
+
+// Execute runs a command and returns its result. Events arriving meanwhile are skipped.
+func (c *Client) Execute(name string, arguments any) (json.RawMessage, error) {
+	data, err := json.Marshal(command{Execute: name, Arguments: arguments})
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", name, err)
+	}
+	_, err = c.conn.Write(append(data, '\n'))
+	if err != nil {
+		return nil, fmt.Errorf("sending %s: %w", name, err)
+	}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if msg.Event != "" {
+			continue
+		}
+		if msg.Error != nil {
+			return nil, fmt.Errorf("%s: %s: %s", name, msg.Error.Class, msg.Error.Desc)
+		}
+		return msg.Return, nil
+	}
+}
+
+// SetLink sets the guest visible link state of a NIC, identified by its netdev id.
+func (c *Client) SetLink(name string, up bool) error {
+	_, err := c.Execute("set_link", map[string]any{"name": name, "up": up})
+	return err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,68 @@
+package qmp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// fakeQemu answers QMP commands on a unix socket and records them.
+func fakeQemu(t *testing.T, commands chan<- command) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "qmp.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(`{"QMP": {"version": {}, "capabilities": []}}` + "\n"))
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var cmd command
+			_ = json.Unmarshal(scanner.Bytes(), &cmd)
+			commands <- cmd
+			if cmd.Execute == "set_link" {
+				_, _ = conn.Write([]byte(`{"event": "NIC_RX_FILTER_CHANGED", "data": {}}` + "\n"))
+			}
+			if cmd.Execute == "bogus" {
+				_, _ = conn.Write([]byte(`{"error": {"class": "CommandNotFound", "desc": "The command bogus has not been found"}}` + "\n"))
+				continue
+			}
+			_, _ = conn.Write([]byte(`{"return": {}}` + "\n"))
+		}
+	}()
+	return socket
+}
+
+func TestClient_SetLink(t *testing.T) {
+	commands := make(chan command, 10)
+	c, err := Dial(fakeQemu(t, commands))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer c.Close()
+	if cmd := <-commands; cmd.Execute != "qmp_capabilities" {
+		t.Errorf("expected capabilities negotiation, got %s", cmd.Execute)
+	}
+	err = c.SetLink("net0", false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	cmd := <-commands
+	args, _ := cmd.Arguments.(map[string]any)
+	if cmd.Execute != "set_link" || args["name"] != "net0" || args["up"] != false {
+		t.Errorf("expected set_link net0 down, got %+v", cmd)
+	}
+	_, err = c.Execute("bogus", nil)
+	if err == nil {
+		t.Errorf("expected error for unknown command")
+	}
+}
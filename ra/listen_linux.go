@@ -0,0 +1,47 @@
+package ra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Listen opens an ICMPv6 socket on the interface that receives router solicitations
+// and sends with the hop limit of 255 that hosts require. It needs CAP_NET_RAW.
+func Listen(ifname string) (net.PacketConn, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	allRouters := syscall.IPv6Mreq{Interface: uint32(iface.Index)}
+	copy(allRouters.Multiaddr[:], net.ParseIP("ff02::2"))
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+				for _, opt := range []int{syscall.IPV6_MULTICAST_HOPS, syscall.IPV6_UNICAST_HOPS} {
+					if serr == nil {
+						serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, opt, 255)
+					}
+				}
+				if serr == nil {
+					serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 0)
+				}
+				if serr == nil {
+					serr = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, &allRouters)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", ifname, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package ra
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func Listen(_ string) (net.PacketConn, error) {
+	return nil, fmt.Errorf("router advertisements are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,215 @@
+// Package ra sends IPv6 router advertisements (RFC 4861) on a bridge, so the
+// VMs on it configure addresses with SLAAC and find the DNS servers (RFC 8106).
+package ra
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ICMPv6 types and option types.
+const (
+	typeRouterSolicitation  = 133
+	typeRouterAdvertisement = 134
+	optSourceLinkAddr       = 1
+	optPrefixInfo           = 3
+	optMTU                  = 5
+	optRDNSS                = 25
+	optDNSSL                = 31
+)
+
+const (
+	flagManaged    = 0x80
+	flagOther      = 0x40
+	flagOnLink     = 0x80
+	flagAutonomous = 0x40
+	// minSolicitedInterval limits the advertisements sent in answer to solicitations.
+	minSolicitedInterval = 3 * time.Second
+)
+
+// Config is what is advertised.
+type Config struct {
+	Prefixes []netip.Prefix
+	// Autonomous lets the hosts configure addresses in the prefixes with SLAAC.
+	Autonomous bool
+	// Managed and Other tell the hosts to ask DHCPv6 for addresses and for other configuration.
+	Managed bool
+	Other   bool
+	// RouterLifetime is how long the hosts use the sender as default router, 0 for not at all.
+	RouterLifetime time.Duration
+	DNS            []netip.Addr
+	Domains        []string
+	MTU            int // 0 to leave out
+	// Interval is the time between unsolicited advertisements.
+	Interval time.Duration
+}
+
+// Validate checks the configuration.
+func (c Config) Validate() error {
+	for _, p := range c.Prefixes {
+		if !p.Addr().Is6() || p.Addr().Is4In6() {
+			return fmt.Errorf("prefix %s is not IPv6", p)
+		}
+		if c.Autonomous && p.Bits() != 64 {
+			return fmt.Errorf("prefix %s must be a /64 for SLAAC", p)
+		}
+	}
+	for _, a := range c.DNS {
+		if !a.Is6() || a.Is4In6() {
+			return fmt.Errorf("dns server %s is not IPv6", a)
+		}
+	}
+	if c.Interval < 4*time.Second || c.Interval > 1800*time.Second {
+		return fmt.Errorf("interval %s not between 4s and 1800s", c.Interval)
+	}
+	if c.RouterLifetime > 9000*time.Second {
+		return fmt.Errorf("router lifetime %s longer than 9000s", c.RouterLifetime)
+	}
+	return nil
+}
+
+// Marshal encodes a router advertisement from the interface with the MAC address.
+// The checksum is left to the kernel.
+func (c Config) Marshal(mac net.HardwareAddr) []byte {
+	var flags byte
+	if c.Managed {
+		flags |= flagManaged
+	}
+	if c.Other {
+		flags |= flagOther
+	}
+	b := []byte{typeRouterAdvertisement, 0, 0, 0, 64, flags}
+	b = binary.BigEndian.AppendUint16(b, uint16(c.RouterLifetime/time.Second))
+	b = binary.BigEndian.AppendUint32(b, 0) // reachable time, unspecified
+	b = binary.BigEndian.AppendUint32(b, 0) // retransmit timer, unspecified
+	if len(mac) == 6 {
+		b = append(b, optSourceLinkAddr, 1)
+		b = append(b, mac...)
+	}
+	if c.MTU > 0 {
+		b = append(b, optMTU, 1, 0, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(c.MTU))
+	}
+	for _, p := range c.Prefixes {
+		pflags := byte(flagOnLink)
+		if c.Autonomous {
+			pflags |= flagAutonomous
+		}
+		b = append(b, optPrefixInfo, 4, byte(p.Bits()), pflags)
+		b = binary.BigEndian.AppendUint32(b, 86400) // valid lifetime
+		b = binary.BigEndian.AppendUint32(b, 14400) // preferred lifetime
+		b = binary.BigEndian.AppendUint32(b, 0)
+		a := p.Masked().Addr().As16()
+		b = append(b, a[:]...)
+	}
+	// the dns options stay valid for three intervals, as RFC 8106 suggests
+	lifetime := uint32(3 * c.Interval / time.Second)
+	if len(c.DNS) > 0 {
+		b = append(b, optRDNSS, byte(1+2*len(c.DNS)), 0, 0)
+		b = binary.BigEndian.AppendUint32(b, lifetime)
+		for _, a := range c.DNS {
+			a := a.As16()
+			b = append(b, a[:]...)
+		}
+	}
+	if len(c.Domains) > 0 {
+		var names []byte
+		for _, d := range c.Domains {
+			for _, label := range strings.Split(strings.Trim(d, "."), ".") {
+				names = append(names, byte(len(label)))
+				names = append(names, label...)
+			}
+			names = append(names, 0)
+		}
+		for (8+len(names))%8 != 0 {
+			names = append(names, 0)
+		}
+		b = append(b, optDNSSL, byte((8+len(names))/8), 0, 0)
+		b = binary.BigEndian.AppendUint32(b, lifetime)
+		b = append(b, names...)
+	}
+	return b
+}
+
+// Advertiser sends router advertisements on an interface.
+type Advertiser struct {
+	cfg    Config
+	ifname string
+	mac    net.HardwareAddr
+}
+
+// NewAdvertiser returns an advertiser for the interface.
+func NewAdvertiser(cfg Config, ifname string) (*Advertiser, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	return &Advertiser{cfg: cfg, ifname: ifname, mac: iface.HardwareAddr}, nil
+}
+
+// Serve advertises every interval and when a host solicits, until the context is
+// cancelled. Then it advertises once more with a router lifetime of 0, so the hosts
+// stop using the sender as router right away.
+func (a *Advertiser) Serve(ctx context.Context, conn net.PacketConn) error {
+	defer conn.Close()
+	solicited := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if n >= 8 && buf[0] == typeRouterSolicitation && buf[1] == 0 {
+				select {
+				case solicited <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	allNodes := &net.IPAddr{IP: net.ParseIP("ff02::1"), Zone: a.ifname}
+	send := func(cfg Config) {
+		_, err := conn.WriteTo(cfg.Marshal(a.mac), allNodes)
+		if err != nil {
+			log.Printf("ra: send on %s: %v", a.ifname, err)
+		}
+	}
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+	send(a.cfg)
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			final := a.cfg
+			final.RouterLifetime = 0
+			send(final)
+			return nil
+		case err := <-readErr:
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("ra read: %w", err)
+		case <-solicited:
+			if time.Since(last) < minSolicitedInterval {
+				continue
+			}
+		case <-ticker.C:
+		}
+		send(a.cfg)
+		last = time.Now()
+	}
+}
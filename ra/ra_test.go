@@ -0,0 +1,70 @@
+package ra
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestConfig_Marshal(t *testing.T) {
+	cfg := Config{
+		Prefixes:       []netip.Prefix{netip.MustParsePrefix("fd00:10::1/64")},
+		Autonomous:     true,
+		Managed:        true,
+		RouterLifetime: 1800 * time.Second,
+		DNS:            []netip.Addr{netip.MustParseAddr("fd00:10::1")},
+		Domains:        []string{"lab.internal"},
+		MTU:            9000,
+		Interval:       time.Minute,
+	}
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	b := cfg.Marshal(mac)
+	if b[0] != typeRouterAdvertisement || b[4] != 64 || b[5] != flagManaged || binary.BigEndian.Uint16(b[6:]) != 1800 {
+		t.Fatalf("unexpected header %x", b[:16])
+	}
+	opts := make(map[byte][]byte)
+	for rest := b[16:]; len(rest) > 0; {
+		if len(rest) < 8 || rest[1] == 0 || len(rest) < 8*int(rest[1]) {
+			t.Fatalf("bad option %x", rest)
+		}
+		n := 8 * int(rest[1])
+		opts[rest[0]] = rest[2:n]
+		rest = rest[n:]
+	}
+	if got := net.HardwareAddr(opts[optSourceLinkAddr]); got.String() != mac.String() {
+		t.Errorf("expected source %s, got %s", mac, got)
+	}
+	if got := binary.BigEndian.Uint32(opts[optMTU][2:]); got != 9000 {
+		t.Errorf("expected mtu 9000, got %d", got)
+	}
+	prefix := opts[optPrefixInfo]
+	if prefix[0] != 64 || prefix[1] != flagOnLink|flagAutonomous || netip.AddrFrom16([16]byte(prefix[14:30])) != netip.MustParseAddr("fd00:10::") {
+		t.Errorf("unexpected prefix information %x", prefix)
+	}
+	if got := netip.AddrFrom16([16]byte(opts[optRDNSS][6:22])); got != cfg.DNS[0] || binary.BigEndian.Uint32(opts[optRDNSS][2:]) != 180 {
+		t.Errorf("unexpected rdnss %x", opts[optRDNSS])
+	}
+	if got := string(opts[optDNSSL][6:20]); got != "\x03lab\x08internal\x00" {
+		t.Errorf("unexpected dnssl %q", got)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	ok := Config{Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00:10::/64")}, Autonomous: true, Interval: time.Minute}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	bad := []Config{
+		{Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00:10::/56")}, Autonomous: true, Interval: time.Minute},
+		{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}, Interval: time.Minute},
+		{DNS: []netip.Addr{netip.MustParseAddr("10.0.0.1")}, Interval: time.Minute},
+		{Interval: time.Second},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v: expected an error", c)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// managementPorts are the guest services exposed on the host in rootless mode.
+var managementPorts = []struct {
+	name string
+	port uint16
+}{
+	{"ssh", 22},
+	{"netconf", 830},
+	{"https", 443},
+}
+
+// forward maps a port on the host to a service in the VM.
+type forward struct {
+	Name      string `json:"name"`
+	HostAddr  string `json:"host_addr"`
+	HostPort  uint16 `json:"host_port"`
+	GuestPort uint16 `json:"guest_port,omitempty"` // zero for the console, which qemu serves itself
+	// GuestAddr is the address of the VM a relayed forward connects to, the DHCP
+	// lease of the VM if empty.
+	GuestAddr string `json:"guest_addr,omitempty"`
+	// Relayed is true for the forwards the wrapper relays itself, see startForwards.
+	Relayed bool `json:"relayed,omitempty"`
+}
+
+// getRootlessNetworking sets up networking that needs no privileges at all: qemu
+// user networking, or passt when it is installed. Management ports are forwarded from localhost.
+func (r *Runner) getRootlessNetworking(id string) (string, error) {
+	r.forwards = append(r.forwards, forward{Name: "console", HostAddr: "127.0.0.1", HostPort: r.telnetPort})
+	for _, mp := range managementPorts {
+		port, err := allocateHostPort(r.firmware + mp.name)
+		if err != nil {
+			return "", fmt.Errorf("allocating port for %s: %w", mp.name, err)
+		}
+		r.forwards = append(r.forwards, forward{Name: mp.name, HostAddr: "127.0.0.1", HostPort: port, GuestPort: mp.port})
+	}
+	usePasst := r.network == "passt"
+	if r.network != "user" && !usePasst {
+		_, err := exec.LookPath("passt")
+		usePasst = err == nil
+	}
+	if usePasst {
+		r.backend = "passt"
+		return r.startPasst(id)
+	}
+	r.backend = "user"
+	return userNetdev(id, r.forwards), nil
+}
+
+// userNetdev returns the netdev of qemu user networking with the forwards as hostfwd.
+func userNetdev(id string, forwards []forward) string {
+	netdev := fmt.Sprintf("user,id=%s", id)
+	for _, f := range forwards {
+		if f.GuestPort == 0 {
+			continue
+		}
+		netdev += fmt.Sprintf(",hostfwd=tcp:%s:%d-:%d", f.HostAddr, f.HostPort, f.GuestPort)
+	}
+	return netdev
+}
+
+// passtArgs returns the arguments of passt serving qemu on the socket, with the forwards.
+func passtArgs(socket string, forwards []forward) []string {
+	args := []string{"--foreground", "--socket", socket}
+	for _, f := range forwards {
+		if f.GuestPort == 0 {
+			continue
+		}
+		args = append(args, "-t", fmt.Sprintf("%s/%d:%d", f.HostAddr, f.HostPort, f.GuestPort))
+	}
+	return args
+}
+
+// startPasst runs passt next to qemu and returns a netdev that connects to it.
+func (r *Runner) startPasst(id string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	socket := filepath.Join(dir, r.name+".passt.sock")
+	_ = os.Remove(socket)
+	cmd := exec.Command("passt", passtArgs(socket, r.forwards)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("starting passt: %w", err)
+	}
+	r.addCleanup(func() error {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = os.Remove(socket)
+		return nil
+	})
+	// passt creates the socket once it is ready for qemu.
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return fmt.Sprintf("stream,id=%s,server=off,addr.type=unix,addr.path=%s", id, socket), nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", fmt.Errorf("passt did not create %s", socket)
+}
+
+// handedOut are the ports allocateHostPort gave out in this run. They stay free until
+// qemu, passt or a forward listens on them, so probing alone would hand them out twice.
+var (
+	handedOutMu sync.Mutex
+	handedOut   = make(map[uint16]bool)
+)
+
+// allocateHostPort picks a free port on localhost. The starting point is derived
+// from the seed and the user name, so a VM usually gets the same port every time.
+func allocateHostPort(seed string) (uint16, error) {
+	handedOutMu.Lock()
+	defer handedOutMu.Unlock()
+	hash := crc32.ChecksumIEEE([]byte(seed + os.Getenv("USER")))
+	base := 20000 + hash%10000
+	for i := uint32(0); i < 100; i++ {
+		port := uint16(20000 + (base-20000+i)%10000)
+		if handedOut[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			continue
+		}
+		_ = l.Close()
+		handedOut[port] = true
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port found near %d", base)
+}
+
+func (r *Runner) printForwards() {
+	if len(r.forwards) == 0 {
+		return
+	}
+	fmt.Printf("Host forwards for %s:\n", r.name)
+	writeForwards(r.forwards)
+}
+
+func writeForwards(forwards []forward) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVICE\tHOST\tGUEST")
+	for _, f := range forwards {
+		guest := "-"
+		switch {
+		case f.Relayed && f.GuestAddr == "":
+			guest = net.JoinHostPort("lease", fmt.Sprint(f.GuestPort))
+		case f.Relayed:
+			guest = net.JoinHostPort(f.GuestAddr, fmt.Sprint(f.GuestPort))
+		case f.GuestPort != 0:
+			guest = fmt.Sprint(f.GuestPort)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, net.JoinHostPort(f.HostAddr, fmt.Sprint(f.HostPort)), guest)
+	}
+	_ = w.Flush()
+}
+
+// runForwards prints the host forwards of a running VM, or of all of them.
+func runForwards(args []string) error {
+	if len(args) == 1 {
+		st, err := loadState(args[0])
+		if err != nil {
+			return err
+		}
+		if len(st.Forwards) == 0 {
+			return fmt.Errorf("%s has no host forwards (network %s)", args[0], st.Network)
+		}
+		writeForwards(st.Forwards)
+		return nil
+	}
+	states, err := listStates()
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if len(st.Forwards) == 0 {
+			continue
+		}
+		fmt.Printf("Host forwards for %s:\n", st.Name)
+		writeForwards(st.Forwards)
+	}
+	return nil
+}
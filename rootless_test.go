@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+var testForwards = []forward{
+	{Name: "console", HostAddr: "127.0.0.1", HostPort: 1050},
+	{Name: "ssh", HostAddr: "127.0.0.1", HostPort: 20022, GuestPort: 22},
+	{Name: "netconf", HostAddr: "127.0.0.1", HostPort: 20830, GuestPort: 830},
+}
+
+func Test_userNetdev(t *testing.T) {
+	tests := []struct {
+		name     string
+		forwards []forward
+		want     string
+	}{
+		{"none", nil, "user,id=net0"},
+		{"console only", testForwards[:1], "user,id=net0"},
+		{"management", testForwards, "user,id=net0,hostfwd=tcp:127.0.0.1:20022-:22,hostfwd=tcp:127.0.0.1:20830-:830"},
+	}
+	for _, tt := range tests {
+		if got := userNetdev("net0", tt.forwards); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func Test_passtArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		forwards []forward
+		want     []string
+	}{
+		{"none", nil, []string{"--foreground", "--socket", "/run/r1.sock"}},
+		{"console only", testForwards[:1], []string{"--foreground", "--socket", "/run/r1.sock"}},
+		{"management", testForwards, []string{"--foreground", "--socket", "/run/r1.sock",
+			"-t", "127.0.0.1/20022:22", "-t", "127.0.0.1/20830:830"}},
+	}
+	for _, tt := range tests {
+		if got := passtArgs("/run/r1.sock", tt.forwards); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func Test_allocateHostPort_distinct(t *testing.T) {
+	// the same seed starts at the same port, it must not be handed out twice
+	first, err := allocateHostPort("r1.qcow2 ssh")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	second, err := allocateHostPort("r1.qcow2 ssh")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if first == second {
+		t.Errorf("expected two ports, got %d twice", first)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/tuntap"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// vmState is what we know about a running VM. It is written when the VM starts
+// and removed when it stops, so other invocations of the wrapper can query it.
+type vmState struct {
+	Name     string    `json:"name"`
+	Firmware string    `json:"firmware"`
+	PID      int       `json:"pid"`
+	Network  string    `json:"network"`
+	QMP      string    `json:"qmp"` // path of the QMP socket
+	Forwards []forward `json:"forwards,omitempty"`
+	// Taps maps interface ids to tap devices.
+	Taps map[string]string `json:"taps,omitempty"`
+	// MACs maps interface ids to the MAC addresses of the NICs.
+	MACs map[string]string `json:"macs,omitempty"`
+	// Namespace is the network namespace of the taps, empty for the host namespace.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// otherNamespace tells whether the taps of the VM are in a network namespace other
+// than ours, as they are unless we were started with ip netns exec.
+func (st vmState) otherNamespace() bool {
+	if st.Namespace == "" {
+		return false
+	}
+	ns, err := os.Stat(tuntap.NamespacePath(st.Namespace))
+	if err != nil {
+		return true
+	}
+	self, err := os.Stat("/proc/self/ns/net")
+	return err != nil || !os.SameFile(ns, self)
+}
+
+// stateDir returns the directory holding the state of running VMs, creating it if needed.
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "qemu-wrapper")
+	} else {
+		dir = filepath.Join(os.TempDir(), "qemu-wrapper-"+os.Getenv("USER"))
+	}
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return "", fmt.Errorf("state dir: %w", err)
+	}
+	return dir, nil
+}
+
+func statePath(name string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// saveState records the VM and removes the record again on teardown.
+func (r *Runner) saveState() error {
+	st := vmState{
+		Name:     r.name,
+		Firmware: r.firmware,
+		PID:      os.Getpid(),
+		Network:  r.backend,
+		QMP:      r.qmpSocket,
+		Forwards: r.forwards,
+		Taps:     r.taps,
+		MACs:     r.macs,
+	}
+	if r.backend == "tap" {
+		st.Namespace = r.tt.Namespace()
+	}
+	filename, err := statePath(r.name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	err = os.WriteFile(filename, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	r.addCleanup(func() error {
+		return os.Remove(filename)
+	})
+	return nil
+}
+
+// loadState reads the state of a running VM.
+func loadState(name string) (vmState, error) {
+	var st vmState
+	filename, err := statePath(name)
+	if err != nil {
+		return st, err
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, fmt.Errorf("vm %s is not running", name)
+	}
+	if err != nil {
+		return st, fmt.Errorf("read state: %w", err)
+	}
+	err = json.Unmarshal(data, &st)
+	if err != nil {
+		return st, fmt.Errorf("parse state of %s: %w", name, err)
+	}
+	return st, nil
+}
+
+// listStates returns the state of all running VMs. A state file that cannot be read,
+// or is gone by the time it is read, is skipped with a warning.
+func listStates() ([]vmState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []vmState
+	for _, f := range files {
+		st, err := loadState(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err != nil {
+			log.Printf("state: skipping %s: %v", f, err)
+			continue
+		}
+		states = append(states, st)
+	}
+	return states, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListStates_skipsBad(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir, err := stateDir()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "r1.json"), []byte(`{"name": "r1"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "r2.json"), []byte(`{"name": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	states, err := listStates()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(states) != 1 || states[0].Name != "r1" {
+		t.Errorf("expected the state of r1 only, got %+v", states)
+	}
+}
+
+func TestVmState_otherNamespace(t *testing.T) {
+	if (vmState{}).otherNamespace() {
+		t.Errorf("expected the host namespace to be ours")
+	}
+	if !(vmState{Namespace: "qemu-wrapper-test-missing"}).otherNamespace() {
+		t.Errorf("expected a missing namespace not to be ours")
+	}
+}
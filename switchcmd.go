@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/vswitch"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// switchConfig describes a userspace switch and its ports.
+type switchConfig struct {
+	// Dir holds the port sockets, one <port name>.sock per port.
+	Dir string `json:"dir"`
+	// Transport is stream (the default) or dgram.
+	Transport string               `json:"transport,omitempty"`
+	Ports     []vswitch.PortConfig `json:"ports"`
+}
+
+// runSwitch runs a userspace switch until the context is cancelled.
+func runSwitch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("switch", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "log every frame")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: switch [-v] <config.json>")
+	}
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read switch config: %w", err)
+	}
+	var cfg switchConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return fmt.Errorf("parse switch config: %w", err)
+	}
+	err = os.MkdirAll(cfg.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("switch dir: %w", err)
+	}
+	sw := vswitch.New()
+	if *verbose {
+		sw.Observer = func(f vswitch.Frame) {
+			log.Println(f)
+		}
+	}
+	for _, p := range cfg.Ports {
+		path := filepath.Join(cfg.Dir, p.Name+".sock")
+		switch cfg.Transport {
+		case "", "stream":
+			err = sw.ListenStream(ctx, p, path)
+		case "dgram":
+			err = sw.ListenDgram(ctx, p, path)
+		default:
+			return fmt.Errorf("unknown transport %q", cfg.Transport)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Port %s on %s\n", p.Name, path)
+	}
+	<-ctx.Done()
+	for _, p := range cfg.Ports {
+		_ = os.Remove(filepath.Join(cfg.Dir, p.Name+".sock"))
+	}
+	return nil
+}
+
+// switchLinks is the -link flag, it may be given several times.
+type switchLinks []string
+
+func (l *switchLinks) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *switchLinks) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// linkOptions returns the qemu options for a NIC connected to a switch port.
+// The link is the path of the port socket, prefixed with dgram: for datagram ports.
+func (r *Runner) linkOptions(id, link string) ([]string, error) {
+	var netdev string
+	if path, ok := strings.CutPrefix(link, "dgram:"); ok {
+		dir, err := stateDir()
+		if err != nil {
+			return nil, err
+		}
+		local := filepath.Join(dir, fmt.Sprintf("%s-%s.sock", r.name, id))
+		_ = os.Remove(local)
+		r.addCleanup(func() error {
+			_ = os.Remove(local)
+			return nil
+		})
+		netdev = fmt.Sprintf("dgram,id=%s,local.type=unix,local.path=%s,remote.type=unix,remote.path=%s", id, local, path)
+	} else {
+		netdev = fmt.Sprintf("stream,id=%s,server=off,addr.type=unix,addr.path=%s", id, link)
+	}
+	return []string{
+		"-netdev", netdev,
+		"-device", r.nicDevice(id, r.nicMac(id)),
+	}, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/syslogd"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogConfig enables the syslog receiver on the bridge of a DHCP server.
+type syslogConfig struct {
+	Port int `json:"port,omitempty"` // UDP and TCP, 514 by default
+}
+
+// vmFiles appends lines to a file per VM in the state dir, kept open while the receiver runs.
+type vmFiles struct {
+	suffix string // of the file names, after the VM name
+	mu     sync.Mutex
+	files  map[string]*os.File
+}
+
+func newVMFiles(suffix string) *vmFiles {
+	return &vmFiles{suffix: suffix, files: make(map[string]*os.File)}
+}
+
+func (s *vmFiles) write(name string, line any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		dir, err := stateDir()
+		if err != nil {
+			return err
+		}
+		f, err = os.OpenFile(filepath.Join(dir, name+s.suffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		s.files[name] = f
+	}
+	_, err := fmt.Fprintln(f, line)
+	return err
+}
+
+func (s *vmFiles) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, f := range s.files {
+		_ = f.Close()
+		delete(s.files, name)
+	}
+}
+
+const syslogSuffix = ".syslog.log"
+
+// leaseName returns what to name the files of a sender after: the VM it has a lease
+// for, or else its MAC address from the lease, or else its address.
+func leaseName(srv *dhcp.Server, ip netip.Addr) string {
+	for _, l := range srv.Leases() {
+		if l.IP != ip {
+			continue
+		}
+		if l.VM != "" {
+			return l.VM
+		}
+		return strings.ReplaceAll(l.MAC, ":", "-")
+	}
+	return ip.String()
+}
+
+// startSyslog receives syslog on the server address until the context is cancelled.
+// Messages are attributed by the lease of the sender: to its VM, or else to its MAC
+// address. Senders without a lease are logged by address.
+func startSyslog(ctx context.Context, c syslogConfig, srv *dhcp.Server, serverIP netip.Addr) error {
+	if c.Port == 0 {
+		c.Port = 514
+	}
+	addr := net.JoinHostPort(serverIP.String(), strconv.Itoa(c.Port))
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("syslog listen: %w", err)
+	}
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("syslog listen: %w", err)
+	}
+	files := newVMFiles(syslogSuffix)
+	s := syslogd.NewServer(func(from netip.Addr, m syslogd.Message) {
+		if err := files.write(leaseName(srv, from), m); err != nil {
+			log.Printf("syslog: %v", err)
+		}
+	})
+	go func() {
+		if err := s.ServeUDP(ctx, conn); err != nil {
+			log.Printf("syslog: %v", err)
+		}
+	}()
+	go func() {
+		if err := s.ServeTCP(ctx, ln); err != nil {
+			log.Printf("syslog: %v", err)
+		}
+		files.close()
+	}()
+	fmt.Printf("syslog receiver on %s udp and tcp\n", addr)
+	return nil
+}
+
+// runLogs prints the link log of a VM, or with -syslog what the VM sent to the syslog receiver.
+func runLogs(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	syslog := flags.Bool("syslog", false, "print the syslog messages of the VM")
+	follow := flags.Bool("f", false, "keep printing new lines")
+	lines := flags.Int("n", 0, "print only the last n lines")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: logs [-syslog] [-f] [-n lines] <vm>")
+	}
+	vm := flags.Arg(0)
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(dir, vm+".links.log")
+	if *syslog {
+		p = filepath.Join(dir, vm+syslogSuffix)
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no logs for %s in %s", vm, filepath.Dir(p))
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = printTail(os.Stdout, f, *lines)
+	if err != nil || !*follow {
+		return err
+	}
+	return followFile(ctx, os.Stdout, f)
+}
+
+// printTail copies the last n lines of r to w, all lines if n is 0.
+func printTail(w io.Writer, r io.Reader, n int) error {
+	if n <= 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	tail := make([]string, 0, n)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(tail) == n {
+			tail = tail[1:]
+		}
+		tail = append(tail, scanner.Text())
+	}
+	for _, line := range tail {
+		_, _ = fmt.Fprintln(w, line)
+	}
+	return scanner.Err()
+}
+
+// followFile copies what is appended to the file until the context is cancelled.
+func followFile(ctx context.Context, w io.Writer, f *os.File) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			_, err := io.Copy(w, f)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
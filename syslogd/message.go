@@ -0,0 +1,204 @@
+// Package syslogd receives syslog messages from routers, over UDP and TCP, in the
+// BSD format (RFC 3164) or the structured format (RFC 5424).
+package syslogd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a received syslog message. Fields the sender left out are empty.
+type Message struct {
+	Facility   int
+	Severity   int
+	Time       time.Time
+	Hostname   string
+	App        string
+	ProcID     string
+	MsgID      string
+	Structured string // RFC 5424 structured data, as sent
+	Text       string
+}
+
+var facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Priority returns the facility and severity as in facility.severity, local7.notice.
+func (m Message) Priority() string {
+	f := strconv.Itoa(m.Facility)
+	if m.Facility >= 0 && m.Facility < len(facilities) {
+		f = facilities[m.Facility]
+	}
+	sev := strconv.Itoa(m.Severity)
+	if m.Severity >= 0 && m.Severity < len(severities) {
+		sev = severities[m.Severity]
+	}
+	return f + "." + sev
+}
+
+// String formats the message as a log line.
+func (m Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Time.Format(time.RFC3339))
+	b.WriteByte(' ')
+	b.WriteString(m.Priority())
+	if m.Hostname != "" {
+		b.WriteByte(' ')
+		b.WriteString(m.Hostname)
+	}
+	if m.App != "" {
+		b.WriteByte(' ')
+		b.WriteString(m.App)
+		if m.ProcID != "" {
+			fmt.Fprintf(&b, "[%s]", m.ProcID)
+		}
+		b.WriteByte(':')
+	}
+	if m.MsgID != "" {
+		b.WriteByte(' ')
+		b.WriteString(m.MsgID)
+	}
+	if m.Structured != "" {
+		b.WriteByte(' ')
+		b.WriteString(m.Structured)
+	}
+	if m.Text != "" {
+		b.WriteByte(' ')
+		b.WriteString(m.Text)
+	}
+	return b.String()
+}
+
+// Parse decodes a message. Messages without a usable timestamp get the receive time,
+// now. A BSD message that does not follow the format is kept whole as text.
+func Parse(data []byte, now time.Time) (Message, error) {
+	s := strings.TrimRight(string(data), "\r\n\x00")
+	if len(s) < 3 || s[0] != '<' {
+		return Message{}, errors.New("missing priority")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return Message{}, errors.New("bad priority")
+	}
+	// one to three digits, no sign
+	pri := 0
+	for _, c := range []byte(s[1:end]) {
+		if c < '0' || c > '9' {
+			return Message{}, fmt.Errorf("bad priority %q", s[1:end])
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return Message{}, fmt.Errorf("bad priority %q", s[1:end])
+	}
+	m := Message{Facility: pri >> 3, Severity: pri & 7, Time: now}
+	s = s[end+1:]
+	if strings.HasPrefix(s, "1 ") {
+		return parse5424(m, s[2:])
+	}
+	return parse3164(m, s, now), nil
+}
+
+// parse5424 parses the part after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parse5424(m Message, s string) (Message, error) {
+	var fields [5]string
+	for i := range fields {
+		var ok bool
+		fields[i], s, ok = strings.Cut(s, " ")
+		if !ok && i < len(fields)-1 {
+			return m, errors.New("truncated header")
+		}
+		if fields[i] == "-" {
+			fields[i] = ""
+		}
+	}
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return m, fmt.Errorf("bad timestamp %q", fields[0])
+		}
+		m.Time = t
+	}
+	m.Hostname, m.App, m.ProcID, m.MsgID = fields[1], fields[2], fields[3], fields[4]
+	n, err := structuredLength(s)
+	if err != nil {
+		return m, err
+	}
+	if sd := s[:n]; sd != "-" {
+		m.Structured = sd
+	}
+	m.Text = strings.TrimPrefix(strings.TrimPrefix(s[n:], " "), "\ufeff")
+	return m, nil
+}
+
+// structuredLength returns the length of the structured data at the start of s,
+// the nil value or a sequence of [id param="value"...] elements.
+func structuredLength(s string) (int, error) {
+	if strings.HasPrefix(s, "-") {
+		return 1, nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+		for i++; i < len(s); i++ {
+			c := s[i]
+			if quoted && c == '\\' {
+				i++
+			} else if c == '"' {
+				quoted = !quoted
+			} else if c == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(s) {
+			return 0, errors.New("unterminated structured data")
+		}
+		i++
+	}
+	if i == 0 {
+		return 0, errors.New("missing structured data")
+	}
+	return i, nil
+}
+
+// parse3164 parses the part after the priority: TIMESTAMP HOSTNAME TAG: MSG,
+// where the timestamp is like "Oct  9 22:33:20" and has no year.
+func parse3164(m Message, s string, now time.Time) Message {
+	const stamp = "Jan _2 15:04:05"
+	if len(s) > len(stamp) && s[len(stamp)] == ' ' {
+		t, err := time.ParseInLocation(stamp, s[:len(stamp)], now.Location())
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0) // sent last year, in December
+			}
+			m.Time = t
+			s = s[len(stamp)+1:]
+			if host, rest, ok := strings.Cut(s, " "); ok && !strings.HasSuffix(host, ":") {
+				m.Hostname, s = host, rest
+			}
+		}
+	}
+	m.App, m.ProcID, m.Text = parseTag(s)
+	return m
+}
+
+// parseTag splits "sshd[123]: text" in its parts. Without a tag all of s is text.
+func parseTag(s string) (app, pid, text string) {
+	colon := strings.Index(s, ": ")
+	if colon < 1 || colon > 48 || strings.ContainsAny(s[:colon], " \t") {
+		return "", "", s
+	}
+	tag := s[:colon]
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		return tag[:open], tag[open+1 : len(tag)-1], s[colon+2:]
+	}
+	return tag, "", s[colon+2:]
+}
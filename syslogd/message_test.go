@@ -0,0 +1,78 @@
+package syslogd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want Message
+	}{
+		{
+			"<34>Oct 11 22:14:15 r1 su: 'su root' failed for lonvick on /dev/pts/8\n",
+			Message{Facility: 4, Severity: 2, Time: time.Date(2025, 10, 11, 22, 14, 15, 0, time.UTC),
+				Hostname: "r1", App: "su", Text: "'su root' failed for lonvick on /dev/pts/8"},
+		},
+		{
+			"<189>Jan  2 03:00:00 r2 sshd[123]: Accepted publickey",
+			Message{Facility: 23, Severity: 5, Time: time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC),
+				Hostname: "r2", App: "sshd", ProcID: "123", Text: "Accepted publickey"},
+		},
+		{
+			"<187>42: *Mar  1 00:01:10.123: %LINK-3-UPDOWN: Interface Gi1, changed state to up",
+			Message{Facility: 23, Severity: 3, Time: now, App: "42",
+				Text: "*Mar  1 00:01:10.123: %LINK-3-UPDOWN: Interface Gi1, changed state to up"},
+		},
+		{
+			`<165>1 2026-01-02T03:04:05.678Z r3 mgd 3046 UI_CMDLINE_READ_LINE [junos@2636.1.1.1.2.18 username="lab" command="show \"x\" ]"] User 'lab', command 'show'`,
+			Message{Facility: 20, Severity: 5, Time: time.Date(2026, 1, 2, 3, 4, 5, 678000000, time.UTC),
+				Hostname: "r3", App: "mgd", ProcID: "3046", MsgID: "UI_CMDLINE_READ_LINE",
+				Structured: `[junos@2636.1.1.1.2.18 username="lab" command="show \"x\" ]"]`, Text: "User 'lab', command 'show'"},
+		},
+		{
+			"<14>1 - - - - - -",
+			Message{Facility: 1, Severity: 6, Time: now},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Parse([]byte(tt.in), now)
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q:\nexpected %+v\ngot      %+v", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestParse_errors(t *testing.T) {
+	for _, in := range []string{"", "hello", "<999>x", "<-1>hello", "<+1>x", "<1a>x", "<1>1 2026-01-02T03:04:05Z r1", "<1>1 bad r1 a b c -", "<1>1 - r1 a b c [x"} {
+		if _, err := Parse([]byte(in), time.Now()); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestMessage_Priority(t *testing.T) {
+	tests := map[string]Message{
+		"local7.notice": {Facility: 23, Severity: 5},
+		"30.debug":      {Facility: 30, Severity: 7},
+		"-1.-1":         {Facility: -1, Severity: -1},
+	}
+	for want, m := range tests {
+		if got := m.Priority(); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestMessage_String(t *testing.T) {
+	m := Message{Facility: 23, Severity: 5, Time: time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC), Hostname: "r2", App: "sshd", ProcID: "123", Text: "hello"}
+	if want := "2026-01-02T03:00:00Z local7.notice r2 sshd[123]: hello"; m.String() != want {
+		t.Errorf("expected %q, got %q", want, m.String())
+	}
+}
@@ -0,0 +1,135 @@
+package syslogd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// maxMessage is the largest message accepted over TCP.
+const maxMessage = 64 * 1024
+
+// Server passes the received messages to a handler.
+type Server struct {
+	// Handle is called for every message, from several goroutines at once.
+	Handle func(from netip.Addr, m Message)
+	now    func() time.Time
+}
+
+// NewServer returns a server passing the messages to handle.
+func NewServer(handle func(from netip.Addr, m Message)) *Server {
+	return &Server{Handle: handle, now: time.Now}
+}
+
+// ServeUDP reads a message per datagram until the context is cancelled.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, maxMessage)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("syslog read: %w", err)
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		s.deliver(addr.AddrPort().Addr().Unmap(), buf[:n])
+	}
+}
+
+// ServeTCP accepts connections until the context is cancelled. Messages are framed
+// by octet counting or by newlines (RFC 6587), whatever the sender uses.
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("syslog accept: %w", err)
+		}
+		go func() {
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			defer conn.Close()
+			var from netip.Addr
+			if ap, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+				from = ap.Addr().Unmap()
+			}
+			err := s.readStream(from, bufio.NewReaderSize(conn, maxMessage))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("syslog: %s: %v", from, err)
+			}
+		}()
+	}
+}
+
+// readStream delivers the messages of a stream until it ends.
+func (s *Server) readStream(from netip.Addr, r *bufio.Reader) error {
+	for {
+		first, err := r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg []byte
+		if first[0] >= '1' && first[0] <= '9' {
+			msg, err = readCounted(r)
+		} else {
+			msg, err = r.ReadSlice('\n')
+			if errors.Is(err, io.EOF) && len(msg) > 0 {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if len(msg) > 0 && msg[0] != '\n' {
+			s.deliver(from, msg)
+		}
+	}
+}
+
+// readCounted reads a message framed as "LENGTH SP MESSAGE".
+func readCounted(r *bufio.Reader) ([]byte, error) {
+	prefix, err := r.ReadSlice(' ')
+	if err != nil {
+		return nil, fmt.Errorf("reading frame length: %w", err)
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n > maxMessage {
+		return nil, fmt.Errorf("bad frame length %q", prefix)
+	}
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	return msg, err
+}
+
+func (s *Server) deliver(from netip.Addr, data []byte) {
+	m, err := Parse(data, s.now())
+	if err != nil {
+		log.Printf("syslog: bad message from %s: %v", from, err)
+		return
+	}
+	s.Handle(from, m)
+}
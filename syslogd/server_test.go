@@ -0,0 +1,71 @@
+package syslogd
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T) (*Server, chan Message) {
+	t.Helper()
+	got := make(chan Message, 10)
+	return NewServer(func(from netip.Addr, m Message) {
+		if from != netip.MustParseAddr("127.0.0.1") {
+			t.Errorf("expected a message from 127.0.0.1, got %s", from)
+		}
+		got <- m
+	}), got
+}
+
+func expectTexts(t *testing.T, got chan Message, texts ...string) {
+	t.Helper()
+	for _, want := range texts {
+		select {
+		case m := <-got:
+			if m.Text != want {
+				t.Errorf("expected %q, got %q", want, m.Text)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %q, got nothing", want)
+		}
+	}
+}
+
+func TestServer_ServeUDP(t *testing.T) {
+	s, got := collect(t)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.ServeUDP(ctx, conn) }()
+	c, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write([]byte("<13>Jan  2 03:00:00 r1 app: one"))
+	expectTexts(t, got, "one")
+}
+
+func TestServer_ServeTCP(t *testing.T) {
+	s, got := collect(t)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.ServeTCP(ctx, ln) }()
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// newline framing, then octet counting with a newline inside the message
+	_, _ = c.Write([]byte("<13>app: one\n<13>app: two\n28 <13>1 - r1 app - - - three\nx"))
+	_ = c.Close()
+	expectTexts(t, got, "one", "two", "three\nx")
+}
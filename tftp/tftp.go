@@ -0,0 +1,330 @@
+// Package tftp is a TFTP server (RFC 1350) for booting routers and taking their config backups.
+package tftp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opcodes.
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+)
+
+// Error codes.
+const (
+	errUndefined  = 0
+	errNotFound   = 1
+	errAccess     = 2
+	errDiskFull   = 3
+	errIllegal    = 4
+	errUnknownTID = 5
+)
+
+const (
+	blockSize = 512
+	// maxBlockSize is the largest block size a client can negotiate (RFC 2348).
+	maxBlockSize = 65464
+)
+
+// Transfer describes a finished or failed transfer.
+type Transfer struct {
+	Client   netip.AddrPort
+	Filename string
+	Upload   bool
+	Bytes    int64
+	Err      error
+}
+
+// Server answers read requests, and write requests if Create is set.
+// The blksize (RFC 2348) and tsize (RFC 2349) options are supported.
+type Server struct {
+	// Open returns the file the client asked for. Errors wrapping fs.ErrNotExist
+	// are reported to the client as file not found, other errors as access violation.
+	// If the file has a Stat method its size is reported to clients asking for tsize.
+	Open func(client netip.Addr, filename string) (io.ReadCloser, error)
+	// Create returns the writer for an upload. It is closed after the transfer, also
+	// when it failed, unless it has an Abort method: that is called instead when the
+	// transfer failed. Uploads are refused if Create is nil.
+	Create func(client netip.Addr, filename string) (io.WriteCloser, error)
+	// OnTransfer is called after every transfer, it may be nil.
+	OnTransfer func(Transfer)
+	// Timeout is how long to wait for an acknowledgement before sending again.
+	Timeout time.Duration
+	// Retries is how many times a packet is sent before giving up.
+	Retries int
+}
+
+// NewServer returns a server serving the files returned by open.
+func NewServer(open func(client netip.Addr, filename string) (io.ReadCloser, error)) *Server {
+	return &Server{Open: open, Timeout: 2 * time.Second, Retries: 5}
+}
+
+// Serve reads requests from the connection, usually port 69, until the context is cancelled.
+// Each transfer runs on its own socket as the protocol requires.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("tftp read: %w", err)
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go s.handle(ctx, addr, req)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, client *net.UDPAddr, req []byte) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		log.Printf("tftp: transfer socket: %v", err)
+		return
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	t := Transfer{Client: client.AddrPort()}
+	t.Bytes, t.Filename, t.Err = s.transfer(conn, client, req, &t.Upload)
+	if s.OnTransfer != nil {
+		s.OnTransfer(t)
+	}
+}
+
+// transfer runs a request to completion.
+func (s *Server) transfer(conn *net.UDPConn, client *net.UDPAddr, req []byte, upload *bool) (int64, string, error) {
+	if len(req) < 2 {
+		return 0, "", errors.New("short request")
+	}
+	op := binary.BigEndian.Uint16(req)
+	fields := strings.Split(string(req[2:]), "\x00")
+	if len(fields) < 2 {
+		sendError(conn, client, errIllegal, "malformed request")
+		return 0, "", errors.New("malformed request")
+	}
+	filename, mode := fields[0], strings.ToLower(fields[1])
+	opts := make(map[string]string)
+	for i := 2; i+1 < len(fields); i += 2 {
+		opts[strings.ToLower(fields[i])] = fields[i+1]
+	}
+	switch {
+	case op != opRRQ && op != opWRQ:
+		sendError(conn, client, errIllegal, "expected a read or write request")
+		return 0, filename, fmt.Errorf("unexpected opcode %d", op)
+	case mode != "octet" && mode != "netascii":
+		sendError(conn, client, errIllegal, "unsupported mode")
+		return 0, filename, fmt.Errorf("unsupported mode %q", mode)
+	}
+	addr := client.AddrPort().Addr().Unmap()
+	if op == opWRQ {
+		*upload = true
+		if s.Create == nil {
+			sendError(conn, client, errAccess, "uploads are not allowed")
+			return 0, filename, errors.New("upload refused")
+		}
+		w, err := s.Create(addr, filename)
+		if err != nil {
+			sendError(conn, client, errAccess, err.Error())
+			return 0, filename, err
+		}
+		tsize, err := strconv.ParseInt(opts["tsize"], 10, 64)
+		if err != nil {
+			tsize = -1
+		}
+		size, oack := negotiate(opts, tsize)
+		n, err := s.receive(conn, client, w, size, oack)
+		if a, ok := w.(interface{ Abort() error }); ok && err != nil {
+			_ = a.Abort()
+		} else if cerr := w.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing: %w", cerr)
+		}
+		return n, filename, err
+	}
+	f, err := s.Open(addr, filename)
+	if err != nil {
+		code := uint16(errAccess)
+		if errors.Is(err, fs.ErrNotExist) {
+			code = errNotFound
+		}
+		sendError(conn, client, code, err.Error())
+		return 0, filename, err
+	}
+	defer f.Close()
+	tsize := int64(-1)
+	if st, ok := f.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if fi, err := st.Stat(); err == nil && fi.Mode().IsRegular() {
+			tsize = fi.Size()
+		}
+	}
+	size, oack := negotiate(opts, tsize)
+	n, err := s.send(conn, client, f, size, oack)
+	return n, filename, err
+}
+
+// negotiate returns the block size and the option acknowledgement for the options
+// the client asked for, nil if there is none. A negative tsize is left out.
+func negotiate(opts map[string]string, tsize int64) (int, []byte) {
+	size := blockSize
+	var oack []byte
+	if v, ok := opts["blksize"]; ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n >= 8 {
+			size = min(n, maxBlockSize)
+			oack = fmt.Appendf(oack, "blksize\x00%d\x00", size)
+		}
+	}
+	if _, ok := opts["tsize"]; ok && tsize >= 0 {
+		oack = fmt.Appendf(oack, "tsize\x00%d\x00", tsize)
+	}
+	if oack == nil {
+		return size, nil
+	}
+	return size, append(binary.BigEndian.AppendUint16(nil, opOACK), oack...)
+}
+
+// send sends the file in blocks, each acknowledged before the next is sent.
+// The last block is shorter than the block size, possibly empty. An option
+// acknowledgement goes first and is acknowledged as block 0.
+func (s *Server) send(conn *net.UDPConn, client *net.UDPAddr, f io.Reader, size int, oack []byte) (int64, error) {
+	if oack != nil {
+		err := s.sendBlock(conn, client, oack, 0)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var total int64
+	data := make([]byte, 4+size)
+	binary.BigEndian.PutUint16(data, opDATA)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(f, data[4:])
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			sendError(conn, client, errUndefined, "read error")
+			return total, fmt.Errorf("reading: %w", err)
+		}
+		binary.BigEndian.PutUint16(data[2:], block)
+		err = s.sendBlock(conn, client, data[:4+n], block)
+		if err != nil {
+			return total, err
+		}
+		total += int64(n)
+		if n < size {
+			return total, nil
+		}
+	}
+}
+
+// receive writes the blocks of an upload, acknowledging each. The option
+// acknowledgement, or else an acknowledgement of block 0, starts the transfer.
+func (s *Server) receive(conn *net.UDPConn, client *net.UDPAddr, w io.Writer, size int, oack []byte) (int64, error) {
+	reply := oack
+	if reply == nil {
+		reply = ackPacket(0)
+	}
+	var total int64
+	buf := make([]byte, 4+size+1)
+	for block := uint16(1); ; block++ {
+		n, err := s.exchange(conn, client, reply, buf, func(p []byte) bool {
+			return len(p) >= 4 && binary.BigEndian.Uint16(p) == opDATA && binary.BigEndian.Uint16(p[2:]) == block
+		})
+		if err != nil {
+			return total, err
+		}
+		if n-4 > size {
+			sendError(conn, client, errIllegal, "block too large")
+			return total, fmt.Errorf("block %d of %d bytes", block, n-4)
+		}
+		_, err = w.Write(buf[4:n])
+		if err != nil {
+			sendError(conn, client, errDiskFull, "write error")
+			return total, fmt.Errorf("writing: %w", err)
+		}
+		total += int64(n - 4)
+		reply = ackPacket(block)
+		if n-4 < size {
+			_, _ = conn.WriteToUDP(reply, client)
+			return total, nil
+		}
+	}
+}
+
+// sendBlock sends a packet until the client acknowledges the block.
+func (s *Server) sendBlock(conn *net.UDPConn, client *net.UDPAddr, packet []byte, block uint16) error {
+	buf := make([]byte, 1500)
+	_, err := s.exchange(conn, client, packet, buf, func(p []byte) bool {
+		return len(p) >= 4 && binary.BigEndian.Uint16(p) == opACK && binary.BigEndian.Uint16(p[2:]) == block
+	})
+	if err != nil {
+		return fmt.Errorf("block %d: %w", block, err)
+	}
+	return nil
+}
+
+// exchange sends a packet until the client answers with a packet accepted by want,
+// which is read into buf. Other packets, like duplicates of earlier ones, are ignored.
+func (s *Server) exchange(conn *net.UDPConn, client *net.UDPAddr, packet, buf []byte, want func([]byte) bool) (int, error) {
+	for try := 0; try < s.Retries; try++ {
+		_, err := conn.WriteToUDP(packet, client)
+		if err != nil {
+			return 0, fmt.Errorf("sending: %w", err)
+		}
+		deadline := time.Now().Add(s.Timeout)
+		for {
+			_ = conn.SetReadDeadline(deadline)
+			n, from, err := conn.ReadFromUDP(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return 0, err
+			}
+			if err != nil {
+				break // timeout, send again
+			}
+			if from.Port != client.Port || !from.IP.Equal(client.IP) {
+				sendError(conn, from, errUnknownTID, "unknown transfer id")
+				continue
+			}
+			if n >= 4 && binary.BigEndian.Uint16(buf) == opERROR {
+				return 0, fmt.Errorf("client error: %s", strings.TrimRight(string(buf[4:n]), "\x00"))
+			}
+			if want(buf[:n]) {
+				return n, nil
+			}
+		}
+	}
+	return 0, errors.New("no answer from client")
+}
+
+func ackPacket(block uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, opACK), block)
+}
+
+func sendError(conn *net.UDPConn, to *net.UDPAddr, code uint16, msg string) {
+	packet := binary.BigEndian.AppendUint16(nil, opERROR)
+	packet = binary.BigEndian.AppendUint16(packet, code)
+	packet = append(packet, msg...)
+	packet = append(packet, 0)
+	_, _ = conn.WriteToUDP(packet, to)
+}
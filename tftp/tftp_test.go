@@ -0,0 +1,279 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// startServer serves the files on a loopback port and returns its address.
+func startServer(t *testing.T, files map[string][]byte) (*net.UDPAddr, chan Transfer) {
+	return startUploadServer(t, files, nil)
+}
+
+// startUploadServer also accepts uploads if create is not nil.
+func startUploadServer(t *testing.T, files map[string][]byte, create func(netip.Addr, string) (io.WriteCloser, error)) (*net.UDPAddr, chan Transfer) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	s := NewServer(func(_ netip.Addr, name string) (io.ReadCloser, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	s.Create = create
+	s.Timeout = 200 * time.Millisecond
+	done := make(chan Transfer, 1)
+	s.OnTransfer = func(tr Transfer) { done <- tr }
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = s.Serve(ctx, conn) }()
+	return conn.LocalAddr().(*net.UDPAddr), done
+}
+
+// dial sends a request with options, given as name and value pairs, from a new client socket.
+func dial(t *testing.T, server *net.UDPAddr, op uint16, name string, opts ...string) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req := binary.BigEndian.AppendUint16(nil, op)
+	req = append(req, name+"\x00octet\x00"...)
+	for _, o := range opts {
+		req = append(req, o+"\x00"...)
+	}
+	if _, err := conn.WriteToUDP(req, server); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return conn
+}
+
+// get reads a file like a TFTP client, it returns the data or the error message.
+func get(t *testing.T, server *net.UDPAddr, name string) ([]byte, string) {
+	t.Helper()
+	conn := dial(t, server, opRRQ, name)
+	var data []byte
+	buf := make([]byte, 1500)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		switch binary.BigEndian.Uint16(buf) {
+		case opERROR:
+			return nil, string(bytes.TrimRight(buf[4:n], "\x00"))
+		case opDATA:
+			data = append(data, buf[4:n]...)
+			ack := binary.BigEndian.AppendUint16(nil, opACK)
+			ack = append(ack, buf[2:4]...)
+			_, _ = conn.WriteToUDP(ack, from)
+			if n-4 < blockSize {
+				return data, ""
+			}
+		}
+	}
+}
+
+func TestServer_read(t *testing.T) {
+	files := map[string][]byte{
+		"r1.cfg":   bytes.Repeat([]byte("hostname r1\n"), 100),
+		"even.cfg": bytes.Repeat([]byte{'x'}, 2*blockSize),
+	}
+	server, done := startServer(t, files)
+	for name, want := range files {
+		got, msg := get(t, server, name)
+		if msg != "" {
+			t.Fatalf("%s: expected no error, got %s", name, msg)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: expected %d bytes, got %d", name, len(want), len(got))
+		}
+		tr := <-done
+		if tr.Err != nil || tr.Bytes != int64(len(want)) || tr.Filename != name {
+			t.Errorf("%s: unexpected transfer %+v", name, tr)
+		}
+	}
+}
+
+func TestServer_notFound(t *testing.T) {
+	server, done := startServer(t, nil)
+	_, msg := get(t, server, "missing.cfg")
+	if msg == "" {
+		t.Errorf("expected an error")
+	}
+	if tr := <-done; tr.Err == nil {
+		t.Errorf("expected the transfer to fail")
+	}
+}
+
+func TestServer_options(t *testing.T) {
+	want := bytes.Repeat([]byte("0123456789"), 300)
+	server, done := startServer(t, map[string][]byte{"image.bin": want})
+	conn := dial(t, server, opRRQ, "image.bin", "blksize", "1024", "tsize", "0")
+	buf := make([]byte, 2000)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// bytes.Reader has no Stat, the size is unknown
+	if got := string(buf[:n]); got != "\x00\x06blksize\x001024\x00" {
+		t.Errorf("expected an oack with blksize only, got %q", got)
+	}
+	_, _ = conn.WriteToUDP(ackPacket(0), from)
+	var got []byte
+	for {
+		n, from, err = conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		got = append(got, buf[4:n]...)
+		_, _ = conn.WriteToUDP(ackPacket(binary.BigEndian.Uint16(buf[2:])), from)
+		if n-4 < 1024 {
+			break
+		}
+		if n-4 != 1024 {
+			t.Fatalf("expected blocks of 1024 bytes, got %d", n-4)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected %d bytes, got %d", len(want), len(got))
+	}
+	if tr := <-done; tr.Err != nil {
+		t.Errorf("expected no error, got %v", tr.Err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		opts  map[string]string
+		tsize int64
+		size  int
+		oack  string
+	}{
+		{map[string]string{}, 100, blockSize, ""},
+		{map[string]string{"tsize": "0"}, 100, blockSize, "\x00\x06tsize\x00100\x00"},
+		{map[string]string{"blksize": "1468", "tsize": "0"}, -1, 1468, "\x00\x06blksize\x001468\x00"},
+		{map[string]string{"blksize": "100000"}, -1, maxBlockSize, "\x00\x06blksize\x0065464\x00"},
+		{map[string]string{"blksize": "4"}, -1, blockSize, ""},
+	}
+	for _, tt := range tests {
+		size, oack := negotiate(tt.opts, tt.tsize)
+		if size != tt.size || string(oack) != tt.oack {
+			t.Errorf("%v: expected %d %q, got %d %q", tt.opts, tt.size, tt.oack, size, oack)
+		}
+	}
+}
+
+type buffer struct{ bytes.Buffer }
+
+func (b *buffer) Close() error { return nil }
+
+func TestServer_upload(t *testing.T) {
+	var uploaded buffer
+	server, done := startUploadServer(t, nil, func(_ netip.Addr, name string) (io.WriteCloser, error) {
+		if name != "r1-confg" {
+			return nil, fs.ErrPermission
+		}
+		return &uploaded, nil
+	})
+	want := bytes.Repeat([]byte("interface ge-0/0/0\n"), 40)
+	conn := dial(t, server, opWRQ, "r1-confg", "blksize", "600", "tsize", "800")
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := string(buf[:n]); got != "\x00\x06blksize\x00600\x00tsize\x00800\x00" {
+		t.Errorf("unexpected oack %q", got)
+	}
+	for block, rest := uint16(1), want; ; block++ {
+		chunk := rest[:min(len(rest), 600)]
+		rest = rest[len(chunk):]
+		data := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, opDATA), block)
+		_, _ = conn.WriteToUDP(append(data, chunk...), from)
+		n, _, err = conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(buf[:n], ackPacket(block)) {
+			t.Fatalf("expected ack of block %d, got %q", block, buf[:n])
+		}
+		if len(chunk) < 600 {
+			break
+		}
+	}
+	tr := <-done
+	if tr.Err != nil || !tr.Upload || tr.Bytes != int64(len(want)) {
+		t.Errorf("unexpected transfer %+v", tr)
+	}
+	if !bytes.Equal(uploaded.Bytes(), want) {
+		t.Errorf("expected %d bytes uploaded, got %d", len(want), uploaded.Len())
+	}
+}
+
+func TestServer_uploadRefused(t *testing.T) {
+	server, done := startServer(t, nil)
+	conn := dial(t, server, opWRQ, "r1-confg")
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if binary.BigEndian.Uint16(buf) != opERROR || binary.BigEndian.Uint16(buf[2:]) != errAccess {
+		t.Errorf("expected an access violation, got %q", buf[:n])
+	}
+	if tr := <-done; tr.Err == nil || !tr.Upload {
+		t.Errorf("expected a refused upload, got %+v", tr)
+	}
+}
+
+// abortable records whether the upload was closed or aborted.
+type abortable struct {
+	buffer
+	closed, aborted bool
+}
+
+func (a *abortable) Close() error { a.closed = true; return nil }
+func (a *abortable) Abort() error { a.aborted = true; return nil }
+
+func TestServer_uploadAborted(t *testing.T) {
+	w := &abortable{}
+	server, done := startUploadServer(t, nil, func(netip.Addr, string) (io.WriteCloser, error) {
+		return w, nil
+	})
+	conn := dial(t, server, opWRQ, "r1-confg")
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(buf[:n], ackPacket(0)) {
+		t.Fatalf("expected ack of block 0, got %q", buf[:n])
+	}
+	// the client gives up
+	abort := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, opERROR), 0)
+	_, _ = conn.WriteToUDP(append(abort, "cancelled\x00"...), from)
+	if tr := <-done; tr.Err == nil {
+		t.Errorf("expected a failed transfer, got %+v", tr)
+	}
+	if !w.aborted || w.closed {
+		t.Errorf("expected the upload to be aborted, not closed, got %+v", w)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/tftp"
+	"github.com/perbu/qemu-wrapper/ztp"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+// tftpConfig enables the TFTP server on the bridge of a DHCP server.
+type tftpConfig struct {
+	// Root holds a directory per VM, <root>/<vm>, the files the VM can read.
+	Root string `json:"root,omitempty"`
+	// Uploads holds a directory per VM, <uploads>/<vm>, where the VM can write but not read.
+	Uploads string `json:"uploads,omitempty"`
+	// Bootfile is advertised in the DHCP replies for network boot, relative to the root of the VM.
+	Bootfile string `json:"bootfile,omitempty"`
+}
+
+// tftpFiles maps the requests of a client to the directories of its VM.
+type tftpFiles struct {
+	root    string
+	uploads string
+	ztp     *ztp.Server // may be nil
+	vmByIP  func(ip netip.Addr) string
+}
+
+// vmPath returns the path of a file in the directory of the VM with the address.
+func (f *tftpFiles) vmPath(dir string, client netip.Addr, name string) (string, error) {
+	vm := f.vmByIP(client)
+	if vm == "" {
+		return "", fmt.Errorf("%s is not a VM: %w", client, fs.ErrPermission)
+	}
+	name = filepath.FromSlash(name)
+	if len(name) > 0 && name[0] == filepath.Separator {
+		name = name[1:]
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("bad file name %q: %w", name, fs.ErrPermission)
+	}
+	return filepath.Join(dir, vm, name), nil
+}
+
+// Open reads from the root of the VM, then the ZTP configuration offered to the client.
+func (f *tftpFiles) Open(client netip.Addr, name string) (io.ReadCloser, error) {
+	err := fmt.Errorf("no tftp root: %w", fs.ErrNotExist)
+	if f.root != "" {
+		var p string
+		p, err = f.vmPath(f.root, client, name)
+		if err == nil {
+			var file *os.File
+			file, err = openRegular(p)
+			if err == nil {
+				return file, nil
+			}
+		}
+	}
+	if f.ztp != nil {
+		return f.ztp.Open(client, name)
+	}
+	return nil, err
+}
+
+// openRegular opens a file, but not a directory or a device.
+func openRegular(p string) (*os.File, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	st, err := file.Stat()
+	if err == nil && !st.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a file: %w", p, fs.ErrPermission)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Create writes to the upload directory of the VM. An earlier upload is only
+// replaced once the new one is complete, a failed transfer leaves it alone.
+func (f *tftpFiles) Create(client netip.Addr, name string) (io.WriteCloser, error) {
+	p, err := f.vmPath(f.uploads, client, name)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &upload{File: tmp, path: p}, nil
+}
+
+// upload is a temporary file renamed to its path when the transfer succeeded.
+type upload struct {
+	*os.File
+	path string
+}
+
+// Close completes the upload.
+func (u *upload) Close() error {
+	err := u.File.Close()
+	if err == nil {
+		err = os.Chmod(u.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(u.Name(), u.path)
+	}
+	if err != nil {
+		_ = os.Remove(u.Name())
+	}
+	return err
+}
+
+// Abort drops the upload, called by the TFTP server instead of Close when the transfer failed.
+func (u *upload) Abort() error {
+	_ = u.File.Close()
+	return os.Remove(u.Name())
+}
+
+// startTFTP serves files on port 69 of the server address until the context is cancelled.
+// The configuration may be nil when the server only runs for ZTP.
+func startTFTP(ctx context.Context, c *tftpConfig, srv *dhcp.Server, serverIP netip.Addr, z *ztp.Server) error {
+	files := &tftpFiles{ztp: z, vmByIP: func(ip netip.Addr) string { return leaseVM(srv, ip) }}
+	if c != nil {
+		files.root, files.uploads = c.Root, c.Uploads
+	}
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort(serverIP.String(), "69"))
+	if err != nil {
+		return fmt.Errorf("tftp listen: %w", err)
+	}
+	ts := tftp.NewServer(files.Open)
+	if files.uploads != "" {
+		ts.Create = files.Create
+	}
+	ts.OnTransfer = func(t tftp.Transfer) {
+		client := t.Client.Addr().Unmap()
+		if z != nil && !t.Upload {
+			z.TFTPDone(client, t.Filename, t.Bytes, t.Err)
+			return
+		}
+		op := "read"
+		if t.Upload {
+			op = "upload"
+		}
+		status := "ok"
+		if t.Err != nil {
+			status = t.Err.Error()
+		}
+		log.Printf("tftp: %s %s %s %s %d bytes: %s", op, client, dash(leaseVM(srv, client)), t.Filename, t.Bytes, status)
+	}
+	go func() {
+		if err := ts.Serve(ctx, conn); err != nil {
+			log.Printf("tftp: %v", err)
+		}
+	}()
+	if c != nil && c.Bootfile != "" {
+		next := srv.Options
+		srv.Options = func(req *dhcp.Message, l dhcp.Lease) map[byte][]byte {
+			if next != nil {
+				if opts := next(req, l); opts != nil {
+					return opts
+				}
+			}
+			return map[byte][]byte{
+				dhcp.OptTFTPServer: []byte(serverIP.String()),
+				dhcp.OptBootfile:   []byte(c.Bootfile),
+			}
+		}
+	}
+	fmt.Printf("TFTP server on %s\n", conn.LocalAddr())
+	return nil
+}
+
+// leaseVM returns the VM that has a lease for the address.
+func leaseVM(srv *dhcp.Server, ip netip.Addr) string {
+	for _, l := range srv.Leases() {
+		if l.IP == ip {
+			return l.VM
+		}
+	}
+	return ""
+}
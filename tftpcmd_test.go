@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/ztp"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	r1IP      = netip.MustParseAddr("10.10.0.11")
+	unknownIP = netip.MustParseAddr("10.10.0.99")
+)
+
+func testFiles(t *testing.T) *tftpFiles {
+	t.Helper()
+	dir := t.TempDir()
+	f := &tftpFiles{
+		root:    filepath.Join(dir, "root"),
+		uploads: filepath.Join(dir, "uploads"),
+		vmByIP: func(ip netip.Addr) string {
+			if ip == r1IP {
+				return "r1"
+			}
+			return ""
+		},
+	}
+	for name, content := range map[string]string{"r1/boot.cfg": "r1 boot\n", "r2/boot.cfg": "r2 boot\n"} {
+		p := filepath.Join(f.root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestTFTPFiles_vmPath(t *testing.T) {
+	f := testFiles(t)
+	tests := []struct {
+		client netip.Addr
+		name   string
+		want   string // empty for an error
+	}{
+		{r1IP, "boot.cfg", "/d/r1/boot.cfg"},
+		{r1IP, "/boot.cfg", "/d/r1/boot.cfg"},
+		{r1IP, "images/vmlinuz", "/d/r1/images/vmlinuz"},
+		{r1IP, "../r2/boot.cfg", ""},
+		{r1IP, "images/../../r2/boot.cfg", ""},
+		{r1IP, "", ""},
+		{unknownIP, "boot.cfg", ""},
+	}
+	for _, tt := range tests {
+		got, err := f.vmPath("/d", tt.client, tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("%s %q: expected a permission error, got %q, %v", tt.client, tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s %q: expected %s, got %q, %v", tt.client, tt.name, tt.want, got, err)
+		}
+	}
+}
+
+func TestTFTPFiles_Open(t *testing.T) {
+	f := testFiles(t)
+	r, err := f.Open(r1IP, "boot.cfg")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "r1 boot\n" {
+		t.Errorf("expected the file of r1, got %q", data)
+	}
+	// without a ZTP server there is nothing to fall back to
+	for _, c := range []struct {
+		client netip.Addr
+		name   string
+	}{{r1IP, "missing.cfg"}, {unknownIP, "boot.cfg"}, {r1IP, "."}} {
+		if r, err := f.Open(c.client, c.name); err == nil {
+			_ = r.Close()
+			t.Errorf("%s %q: expected an error", c.client, c.name)
+		}
+	}
+}
+
+func TestTFTPFiles_OpenZTP(t *testing.T) {
+	f := testFiles(t)
+	f.ztp = &ztp.Server{Dir: t.TempDir()}
+	for _, name := range []string{"r1.cfg", "r2.cfg"} {
+		if err := os.WriteFile(filepath.Join(f.ztp.Dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.ztp.Options(&dhcp.Message{}, dhcp.Lease{IP: r1IP, VM: "r1"})
+	r, err := f.Open(r1IP, "r1.cfg")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_ = r.Close()
+	// only the configuration offered to the client
+	for _, c := range []struct {
+		client netip.Addr
+		name   string
+	}{{r1IP, "r2.cfg"}, {unknownIP, "r1.cfg"}, {unknownIP, "r2.cfg"}} {
+		if r, err := f.Open(c.client, c.name); err == nil {
+			_ = r.Close()
+			t.Errorf("%s %q: expected an error", c.client, c.name)
+		}
+	}
+}
+
+func TestTFTPFiles_Create(t *testing.T) {
+	f := testFiles(t)
+	p := filepath.Join(f.uploads, "r1", "r1-confg")
+	write := func(content string, ok bool) {
+		t.Helper()
+		w, err := f.Create(r1IP, "r1-confg")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_, _ = io.WriteString(w, content)
+		if ok {
+			err = w.Close()
+		} else {
+			err = w.(interface{ Abort() error }).Abort()
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	write("hostname r1\n", true)
+	// a failed upload keeps the earlier one
+	write("host", false)
+	data, err := os.ReadFile(p)
+	if err != nil || string(data) != "hostname r1\n" {
+		t.Errorf("expected the first upload, got %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(p))
+	if len(entries) != 1 {
+		t.Errorf("expected only the upload in the directory, got %v", entries)
+	}
+	if _, err := f.Create(unknownIP, "r1-confg"); err == nil {
+		t.Errorf("expected an error for an unknown client")
+	}
+}
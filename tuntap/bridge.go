@@ -3,9 +3,12 @@ package tuntap
 import "fmt"
 
 type bridge struct {
-	name   string
-	ifaces []*tap
-	mac    string
+	name          string
+	ifaces        []*tap
+	mac           string
+	mtu           int
+	vlanFiltering bool
+	vlans         map[string]PortVLANs // per port, only on bridges with vlan filtering
 }
 
 func (br *bridge) addTap(iface *tap) error {
@@ -24,6 +27,7 @@ func (br *bridge) removeTap(iface *tap) error {
 	for i, t := range br.ifaces {
 		if t == iface {
 			br.ifaces = append(br.ifaces[:i], br.ifaces[i+1:]...)
+			delete(br.vlans, iface.name)
 			return nil
 		}
 	}
@@ -34,6 +38,17 @@ func (br *bridge) String() string {
 	ifaceList := ""
 	for _, i := range br.ifaces {
 		ifaceList += i.String() + " "
+		if v, ok := br.vlans[i.name]; ok {
+			ifaceList += "(" + v.String() + ") "
+		}
 	}
 	return fmt.Sprintf("[%s %s] taps: %s", br.name, br.mac, ifaceList)
 }
+
+// setVlans records the VLAN membership of a port.
+func (br *bridge) setVlans(iface *tap, v PortVLANs) {
+	if br.vlans == nil {
+		br.vlans = make(map[string]PortVLANs)
+	}
+	br.vlans[iface.name] = v
+}
@@ -0,0 +1,111 @@
+package tuntap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the capability number of CAP_NET_ADMIN.
+const capNetAdmin = 12
+
+// NetAdmin describes whether this process holds CAP_NET_ADMIN and where it came from.
+type NetAdmin struct {
+	Executable  string // path of the running binary
+	Permitted   bool   // in the permitted set, so it can be passed on to ip
+	Effective   bool
+	Ambient     bool // inherited from the parent as an ambient capability
+	Bounding    bool // false if it has been dropped, e.g. in a container
+	FileCaps    bool // the binary has cap_net_admin in its file capabilities
+	NoNewPrivs  bool // file capabilities are ignored
+	Unsupported bool // the OS has no capabilities
+}
+
+// Usable returns true if ip can be run with CAP_NET_ADMIN without sudo.
+func (n NetAdmin) Usable() bool {
+	return n.Permitted
+}
+
+// Source returns a short description of where the capability comes from.
+func (n NetAdmin) Source() string {
+	switch {
+	case !n.Permitted:
+		return "none"
+	case n.Ambient:
+		return "ambient capabilities"
+	case n.FileCaps:
+		return "file capabilities on " + n.Executable
+	default:
+		return "process capabilities"
+	}
+}
+
+// Missing explains what is needed to get CAP_NET_ADMIN. It is empty if the capability is usable.
+func (n NetAdmin) Missing() string {
+	switch {
+	case n.Permitted:
+		return ""
+	case n.Unsupported:
+		return "capabilities are not supported on this OS"
+	case !n.Bounding:
+		return "CAP_NET_ADMIN has been dropped from the bounding set, it cannot be gained in this environment (container or restricted session)"
+	case n.FileCaps && n.NoNewPrivs:
+		return fmt.Sprintf("%s has cap_net_admin file capabilities, but they are ignored because no_new_privs is set", n.Executable)
+	case n.FileCaps:
+		return fmt.Sprintf("%s has cap_net_admin file capabilities, but they were not applied (is the filesystem mounted nosuid?)", n.Executable)
+	default:
+		return fmt.Sprintf("CAP_NET_ADMIN is not in the permitted set and %s has no file capabilities; grant them once with: sudo setcap cap_net_admin+ep %s", n.Executable, n.Executable)
+	}
+}
+
+// parseProcStatus fills in the capability sets from the contents of /proc/<pid>/status.
+func (n *NetAdmin) parseProcStatus(status []byte) error {
+	found := 0
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		var field *bool
+		switch key {
+		case "CapPrm":
+			field = &n.Permitted
+		case "CapEff":
+			field = &n.Effective
+		case "CapAmb":
+			field = &n.Ambient
+		case "CapBnd":
+			field = &n.Bounding
+		case "NoNewPrivs":
+			n.NoNewPrivs = strings.TrimSpace(value) == "1"
+			continue
+		default:
+			continue
+		}
+		set, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", key, err)
+		}
+		*field = set&(1<<capNetAdmin) != 0
+		found++
+	}
+	if found != 4 {
+		return fmt.Errorf("capability sets not found in status")
+	}
+	return nil
+}
+
+// fileCapsHaveNetAdmin decodes a security.capability extended attribute (struct vfs_cap_data)
+// and returns true if CAP_NET_ADMIN is in its permitted set.
+func fileCapsHaveNetAdmin(xattr []byte) bool {
+	// magic_etc followed by permitted and inheritable for the low 32 capabilities.
+	if len(xattr) < 12 {
+		return false
+	}
+	permitted := binary.LittleEndian.Uint32(xattr[4:8])
+	return permitted&(1<<capNetAdmin) != 0
+}
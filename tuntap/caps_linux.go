@@ -0,0 +1,47 @@
+package tuntap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// CheckNetAdmin inspects the capabilities of the running process and its binary.
+func CheckNetAdmin() (NetAdmin, error) {
+	var n NetAdmin
+	exe, err := os.Executable()
+	if err != nil {
+		return n, fmt.Errorf("finding executable: %w", err)
+	}
+	n.Executable = exe
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return n, fmt.Errorf("reading process status: %w", err)
+	}
+	err = n.parseProcStatus(status)
+	if err != nil {
+		return n, fmt.Errorf("process status: %w", err)
+	}
+	buf := make([]byte, 64)
+	size, err := syscall.Getxattr(exe, "security.capability", buf)
+	switch {
+	case err == nil:
+		n.FileCaps = fileCapsHaveNetAdmin(buf[:size])
+	case errors.Is(err, syscall.ENODATA), errors.Is(err, syscall.ENOTSUP):
+		// no file capabilities
+	default:
+		return n, fmt.Errorf("reading file capabilities of %s: %w", exe, err)
+	}
+	return n, nil
+}
+
+// setNetAdmin makes the command inherit CAP_NET_ADMIN as an ambient capability.
+// Without it the capability is dropped when ip is executed.
+func setNetAdmin(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, capNetAdmin)
+}
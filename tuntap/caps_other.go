@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tuntap
+
+import (
+	"os"
+	"os/exec"
+)
+
+// CheckNetAdmin reports that there are no capabilities on this OS.
+func CheckNetAdmin() (NetAdmin, error) {
+	exe, _ := os.Executable()
+	return NetAdmin{Executable: exe, Unsupported: true}, nil
+}
+
+func setNetAdmin(_ *exec.Cmd) {}
@@ -0,0 +1,71 @@
+package tuntap
+
+import (
+	"strings"
+	"testing"
+)
+
+const statusWithoutCaps = `Name:	qemu-wrapper
+CapInh:	0000000000000000
+CapPrm:	0000000000000000
+CapEff:	0000000000000000
+CapBnd:	000001ffffffffff
+CapAmb:	0000000000000000
+NoNewPrivs:	0
+`
+
+const statusWithAmbient = `Name:	qemu-wrapper
+CapInh:	0000000000001000
+CapPrm:	0000000000001000
+CapEff:	0000000000001000
+CapBnd:	000001ffffffffff
+CapAmb:	0000000000001000
+NoNewPrivs:	0
+`
+
+func TestNetAdmin_parseProcStatus(t *testing.T) {
+	n := NetAdmin{Executable: "/usr/local/bin/qemu-wrapper"}
+	err := n.parseProcStatus([]byte(statusWithoutCaps))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n.Usable() {
+		t.Errorf("expected CAP_NET_ADMIN to be unusable")
+	}
+	if !strings.Contains(n.Missing(), "setcap cap_net_admin+ep /usr/local/bin/qemu-wrapper") {
+		t.Errorf("expected setcap hint, got %q", n.Missing())
+	}
+	n = NetAdmin{}
+	err = n.parseProcStatus([]byte(statusWithAmbient))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !n.Usable() || !n.Ambient {
+		t.Errorf("expected ambient CAP_NET_ADMIN, got %+v", n)
+	}
+	if n.Missing() != "" {
+		t.Errorf("expected nothing missing, got %q", n.Missing())
+	}
+}
+
+func TestNetAdmin_Missing(t *testing.T) {
+	n := NetAdmin{Executable: "/bin/qw", FileCaps: true, Bounding: true, NoNewPrivs: true}
+	if !strings.Contains(n.Missing(), "no_new_privs") {
+		t.Errorf("expected no_new_privs explanation, got %q", n.Missing())
+	}
+	n = NetAdmin{Executable: "/bin/qw"}
+	if !strings.Contains(n.Missing(), "bounding set") {
+		t.Errorf("expected bounding set explanation, got %q", n.Missing())
+	}
+}
+
+func Test_fileCapsHaveNetAdmin(t *testing.T) {
+	// vfs_cap_data v2 for cap_net_admin+ep
+	xattr := []byte{0x01, 0x00, 0x00, 0x02, 0x00, 0x10, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !fileCapsHaveNetAdmin(xattr) {
+		t.Errorf("expected cap_net_admin in file capabilities")
+	}
+	if fileCapsHaveNetAdmin(xattr[:4]) {
+		t.Errorf("expected short xattr to be rejected")
+	}
+}
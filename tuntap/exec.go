@@ -1,8 +1,12 @@
 package tuntap
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os/exec"
+	"path/filepath"
 )
 
 type Executor interface {
@@ -10,6 +14,7 @@ type Executor interface {
 }
 
 type executor struct {
+	netAdmin bool // pass CAP_NET_ADMIN on to the commands
 }
 
 func newExecutor() *executor {
@@ -18,9 +23,50 @@ func newExecutor() *executor {
 
 func (e *executor) Run(path string, args ...string) ([]byte, error) {
 	cmd := exec.Command(path, args...)
+	if e.netAdmin {
+		setNetAdmin(cmd)
+	}
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if lacksPrivileges(path, out, err) {
+			err = permissionError{err}
+		}
 		return nil, fmt.Errorf("executing %s: %w (output: %s)", cmd.Path, err, out)
 	}
 	return out, nil
 }
+
+// permissionMessages are printed by ip, bridge, tc and sudo when they lack privileges.
+var permissionMessages = [][]byte{
+	[]byte("Operation not permitted"),
+	[]byte("Permission denied"),
+	[]byte("sudo: a password is required"),
+	[]byte("sudo: a terminal is required"),
+}
+
+// lacksPrivileges tells whether a command failed because it was not allowed to run,
+// rather than because of what it was asked to do.
+func lacksPrivileges(path string, out []byte, err error) bool {
+	if filepath.Base(path) == "sudo" && errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	for _, msg := range permissionMessages {
+		if bytes.Contains(out, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionError marks a command that lacked privileges, it matches fs.ErrPermission.
+type permissionError struct {
+	error
+}
+
+func (e permissionError) Unwrap() error {
+	return e.error
+}
+
+func (e permissionError) Is(target error) bool {
+	return target == fs.ErrPermission
+}
@@ -0,0 +1,40 @@
+package tuntap
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"testing"
+)
+
+func Test_lacksPrivileges(t *testing.T) {
+	exit := errors.New("exit status 2")
+	tests := []struct {
+		path string
+		out  string
+		err  error
+		want bool
+	}{
+		{"ip", "RTNETLINK answers: Operation not permitted\n", exit, true},
+		{"sudo", "sudo: a terminal is required to read the password\n", exit, true},
+		{"sudo", "", exec.ErrNotFound, true},
+		{"ip", "", exec.ErrNotFound, false},
+		{"ip", "Error: argument \"tap9\" is wrong: Device does not exist\n", exit, false},
+	}
+	for _, tt := range tests {
+		if got := lacksPrivileges(tt.path, []byte(tt.out), tt.err); got != tt.want {
+			t.Errorf("%s %q: expected %v, got %v", tt.path, tt.out, tt.want, got)
+		}
+	}
+}
+
+func TestExecutor_permission(t *testing.T) {
+	_, err := newExecutor().Run("sh", "-c", "echo 'RTNETLINK answers: Operation not permitted'; exit 2")
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+	_, err = newExecutor().Run("sh", "-c", "exit 1")
+	if err == nil || errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected a plain error, got %v", err)
+	}
+}
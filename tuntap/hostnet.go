@@ -0,0 +1,248 @@
+package tuntap
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// nftTable is the nftables table holding the NAT chains of all bridges.
+// Besides one chain per bridge it holds empty marker chains that record which
+// forwarding sysctls were turned on and must be turned off again with the last bridge,
+// and which bridge addresses were added and must be removed with the bridge's last VM.
+const nftTable = "qemu-wrapper"
+
+// forwardingMarkers are the marker chains and the sysctls they stand for.
+var forwardingMarkers = []struct {
+	chain, sysctl string
+	ipv6          bool
+}{
+	{"restore-ipv4-forwarding", "net.ipv4.ip_forward", false},
+	{"restore-ipv6-forwarding", "net.ipv6.conf.all.forwarding", true},
+}
+
+// BridgeHost is the host side configuration of a bridge: addresses of the host
+// on the bridge and whether traffic from the bridge is masqueraded.
+type BridgeHost struct {
+	Addresses []netip.Prefix
+	NAT       bool
+	// NATInterface restricts the masquerading to traffic leaving through this interface.
+	// If empty everything leaving the host through another interface is masqueraded.
+	NATInterface string
+}
+
+// IsZero returns true if there is nothing to configure.
+func (h BridgeHost) IsZero() bool {
+	return len(h.Addresses) == 0 && !h.NAT
+}
+
+// SetBridgeHost adds the host addresses to the bridge and, with NAT, enables forwarding
+// and installs the masquerading chain. Addresses already on the bridge are left alone,
+// so VMs sharing the bridge can all call it. The ones it adds get a marker chain, so
+// whichever VM stops last knows to remove them.
+func (m *Manager) SetBridgeHost(bridge string, h BridgeHost) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bridges[bridge]; !ok {
+		return fmt.Errorf("bridge %s does not exist", bridge)
+	}
+	out, err := m.runPrivileged("ip", "-o", "addr", "show", "dev", bridge)
+	if err != nil {
+		return fmt.Errorf("listing addresses of %s: %w", bridge, err)
+	}
+	present := parseAddrs(out)
+	script := []string{"add", "table", "inet", nftTable}
+	for _, p := range h.Addresses {
+		if slices.ContainsFunc(present, func(a ifaceAddr) bool { return a.prefix == p.String() }) {
+			continue
+		}
+		_, err = m.runPrivileged("ip", "addr", "add", p.String(), "dev", bridge)
+		if err != nil {
+			return fmt.Errorf("adding %s to %s: %w", p, bridge, err)
+		}
+		script = append(script, ";", "add", "chain", "inet", nftTable, addrChain(bridge, p))
+	}
+	if h.NAT {
+		chains := m.nftChains()
+		for _, marker := range forwardingMarkers {
+			if marker.ipv6 && !h.hasIPv6() || slices.Contains(chains, marker.chain) {
+				continue
+			}
+			on, err := m.enableSysctl(marker.sysctl)
+			if err != nil {
+				return err
+			}
+			if on {
+				script = append(script, ";", "add", "chain", "inet", nftTable, marker.chain)
+			}
+		}
+		chain := natChain(bridge)
+		script = append(script,
+			";", "add", "chain", "inet", nftTable, chain, "{", "type", "nat", "hook", "postrouting", "priority", "srcnat", ";", "}",
+			";", "flush", "chain", "inet", nftTable, chain,
+			";", "add", "rule", "inet", nftTable, chain, "iifname", bridge)
+		if h.NATInterface != "" {
+			script = append(script, "oifname", h.NATInterface)
+		} else {
+			script = append(script, "oifname", "!=", bridge)
+		}
+		script = append(script, "masquerade")
+	}
+	if len(script) == 4 {
+		return nil // nothing added, no nat
+	}
+	_, err = m.runPrivileged("nft", script...)
+	if err != nil {
+		return fmt.Errorf("installing nft chains for %s: %w", bridge, err)
+	}
+	return nil
+}
+
+// ReleaseBridgeHost undoes SetBridgeHost once no other VM uses the bridge, that is when
+// the bridge has no taps besides the ones of this manager. Other ports, like uplinks or
+// the veth of a namespace, do not count. The addresses with a marker chain are removed,
+// whichever VM added them, the ones that were on the bridge before stay. With the last
+// NAT chain gone the forwarding sysctls are restored, and with the last chain the table
+// is deleted.
+func (m *Manager) ReleaseBridgeHost(bridge string, h BridgeHost) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out, err := m.runPrivileged("ip", "-o", "link", "show", "master", bridge, "type", "tun")
+	if err != nil {
+		return fmt.Errorf("listing taps of %s: %w", bridge, err)
+	}
+	for _, port := range parsePortNames(out) {
+		if t, ok := m.taps[port]; !ok || !t.mine {
+			return nil // still in use
+		}
+	}
+	chains := m.nftChains()
+	if len(chains) == 0 {
+		return nil // nothing of ours left
+	}
+	var errs []error
+	drop := func(chain string) {
+		_, err := m.runPrivileged("nft", "delete", "chain", "inet", nftTable, chain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("removing chain %s: %w", chain, err))
+			return
+		}
+		chains = slices.DeleteFunc(chains, func(c string) bool { return c == chain })
+	}
+	for _, p := range h.Addresses {
+		if !slices.Contains(chains, addrChain(bridge, p)) {
+			continue
+		}
+		_, err := m.runPrivileged("ip", "addr", "del", p.String(), "dev", bridge)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("removing %s from %s: %w", p, bridge, err))
+			continue
+		}
+		drop(addrChain(bridge, p))
+	}
+	if slices.Contains(chains, natChain(bridge)) {
+		drop(natChain(bridge))
+	}
+	if slices.ContainsFunc(chains, func(c string) bool { return strings.HasPrefix(c, "nat-") }) {
+		return errors.Join(errs...) // another bridge still needs forwarding
+	}
+	var restored []string
+	for _, marker := range forwardingMarkers {
+		if !slices.Contains(chains, marker.chain) {
+			continue
+		}
+		_, err = m.runPrivileged("sysctl", "-w", marker.sysctl+"=0")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring %s: %w", marker.sysctl, err))
+			continue
+		}
+		restored = append(restored, marker.chain)
+	}
+	if len(chains) > len(restored) {
+		// addresses of other bridges keep the table
+		for _, chain := range restored {
+			drop(chain)
+		}
+		return errors.Join(errs...)
+	}
+	_, err = m.runPrivileged("nft", "delete", "table", "inet", nftTable)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("removing nft table: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (h BridgeHost) hasIPv6() bool {
+	return slices.ContainsFunc(h.Addresses, func(p netip.Prefix) bool { return p.Addr().Is6() })
+}
+
+func natChain(bridge string) string {
+	return "nat-" + bridge
+}
+
+// addrChain is the marker chain of an address the wrapper added to a bridge. Colons
+// and slashes are not allowed in chain names.
+func addrChain(bridge string, p netip.Prefix) string {
+	return "addr-" + bridge + "-" + strings.NewReplacer(":", "_", "/", "-").Replace(p.String())
+}
+
+// nftChains returns the chains of the wrapper's table, none if the table does not exist.
+func (m *Manager) nftChains() []string {
+	out, err := m.runPrivileged("nft", "list", "table", "inet", nftTable)
+	if err != nil {
+		return nil
+	}
+	return parseNftChains(out)
+}
+
+// enableSysctl sets a boolean sysctl to 1 and returns true if it was 0 before.
+func (m *Manager) enableSysctl(key string) (bool, error) {
+	out, err := m.runPrivileged("sysctl", "-n", key)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", key, err)
+	}
+	if strings.TrimSpace(string(out)) != "0" {
+		return false, nil
+	}
+	_, err = m.runPrivileged("sysctl", "-w", key+"=1")
+	if err != nil {
+		return false, fmt.Errorf("enabling %s: %w", key, err)
+	}
+	return true, nil
+}
+
+var nftChainRe = regexp.MustCompile(`(?m)^\s*chain (\S+) \{`)
+
+// parseNftChains returns the chain names from nft list table.
+// Example output:
+//
+//	table inet qemu-wrapper {
+//		chain nat-br0 {
+//			type nat hook postrouting priority srcnat; policy accept;
+//			iifname "br0" oifname "eth0" masquerade
+//		}
+//	}
+func parseNftChains(listing []byte) []string {
+	var chains []string
+	for _, match := range nftChainRe.FindAllSubmatch(listing, -1) {
+		chains = append(chains, string(match[1]))
+	}
+	return chains
+}
+
+// parsePortNames returns the interface names from ip -o link show.
+func parsePortNames(listing []byte) []string {
+	var names []string
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,124 @@
+package tuntap
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestManager_BridgeHost(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "bridge-host.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m.taps["tap0"].mine = true // the only port of br0 belongs to this VM
+	h := BridgeHost{
+		Addresses:    []netip.Prefix{netip.MustParsePrefix("10.10.0.1/24")},
+		NAT:          true,
+		NATInterface: "eth0",
+	}
+	err = m.SetBridgeHost("br0", h)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.ReleaseBridgeHost("br0", h)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestManager_BridgeHost_present(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "bridge-host-present.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m.taps["tap0"].mine = true
+	h := BridgeHost{Addresses: []netip.Prefix{netip.MustParsePrefix("10.10.0.1/24")}}
+	err = m.SetBridgeHost("br0", h)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the address was on the bridge before, so it must stay
+	err = m.ReleaseBridgeHost("br0", h)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestManager_BridgeHost_shared(t *testing.T) {
+	r := replay(t, "bridge-host-shared.json")
+	var vms [2]*Manager
+	for i := range vms {
+		vms[i] = New()
+		vms[i].SetSudo(false)
+		vms[i].commander = r
+		if err := vms[i].Load(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	a, b := vms[0], vms[1]
+	a.taps["tap0"].mine = true
+	b.taps["tap1"].mine = true
+	h := BridgeHost{Addresses: []netip.Prefix{netip.MustParsePrefix("10.10.0.1/24")}}
+	for _, m := range vms {
+		if err := m.SetBridgeHost("br0", h); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	// a added the address but b stops last, so b must remove it
+	if err := a.ReleaseBridgeHost("br0", h); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := b.ReleaseBridgeHost("br0", h); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func Test_addrChain(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"10.10.0.1/24", "addr-br0-10.10.0.1-24"},
+		{"fd00::1/64", "addr-br0-fd00__1-64"},
+	}
+	for _, tt := range tests {
+		if got := addrChain("br0", netip.MustParsePrefix(tt.prefix)); got != tt.want {
+			t.Errorf("expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func TestManager_SetBridgeHost_noBridge(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "no-taps.json")
+	if err := m.Load(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.SetBridgeHost("br9", BridgeHost{NAT: true}); err == nil {
+		t.Errorf("expected error for missing bridge")
+	}
+}
+
+func Test_parseNftChains(t *testing.T) {
+	listing := []byte("table inet qemu-wrapper {\n\tchain restore-ipv4-forwarding {\n\t}\n\n\tchain nat-br0 {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n\t\tiifname \"br0\" oifname \"eth0\" masquerade\n\t}\n}\n")
+	want := []string{"restore-ipv4-forwarding", "nat-br0"}
+	if got := parseNftChains(listing); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func Test_parsePortNames(t *testing.T) {
+	listing := []byte("10: tap0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master br0 state UP\\    link/ether 12:85:f7:b0:07:54 brd ff:ff:ff:ff:ff:ff\n11: eth1.100@eth1: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master br0 state UP\n")
+	want := []string{"tap0", "eth1.100"}
+	if got := parsePortNames(listing); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -17,7 +18,7 @@ func split(input []byte) []string {
 	var interfaces []string
 	var tempInterface []string
 
-	r := regexp.MustCompile(`^\d+: [\w\d]+:`)
+	r := regexp.MustCompile(`^\d+: [\w.-]+:`)
 	// Loop through the lines
 	for _, line := range lines {
 
@@ -63,27 +64,64 @@ func parseTaps(listing []byte) []tap {
 		mac := match[3]
 		// force the mac to lower case:
 		mac = strings.ToLower(mac) //nolint:staticcheck
-		taps = append(taps, tap{name: name, mac: match[3]})
+		taps = append(taps, tap{name: name, mac: match[3], mtu: parseMTU(iface)})
 	}
 
 	return taps
 }
+
+// parseBridges parses the output of `ip -d link show type bridge`.
 func parseBridges(listing []byte) []bridge {
 	var bridges []bridge
-	// make a regular expression that will get the names and macs of the tap taps:
-	r := regexp.MustCompile(`(?is)(\d+): ([\w-]+):.*?link/ether ((?:[0-9a-f]{2}:){5}[0-9a-f]{2})`)
-	matches := r.FindAllStringSubmatch(string(listing), -1)
-
-	for _, match := range matches {
+	// make a regular expression that will get the names and macs of the bridges:
+	r := regexp.MustCompile(`(?is)(\d+): ([\w.-]+):.*?link/ether ((?:[0-9a-f]{2}:){5}[0-9a-f]{2})`)
+	filtering := regexp.MustCompile(`vlan_filtering (\d)`)
+	for _, iface := range split(listing) {
+		match := r.FindStringSubmatch(iface)
+		if len(match) == 0 {
+			continue
+		}
 		name := match[2]
 		mac := match[3]
 		// force the mac to lower case:
 		mac = strings.ToLower(mac) //nolint:staticcheck
-		bridges = append(bridges, bridge{name: name, mac: match[3]})
+		br := bridge{name: name, mac: match[3], mtu: parseMTU(iface)}
+		if f := filtering.FindStringSubmatch(iface); f != nil {
+			br.vlanFiltering = f[1] == "1"
+		}
+		bridges = append(bridges, br)
 	}
 	return bridges
 }
 
+var mtuRegexp = regexp.MustCompile(` mtu (\d+) `)
+
+// parseMTU returns the MTU from the ip link output of an interface, 0 if it isn't there.
+func parseMTU(iface string) int {
+	match := mtuRegexp.FindStringSubmatch(iface)
+	if match == nil {
+		return 0
+	}
+	mtu, _ := strconv.Atoi(match[1])
+	return mtu
+}
+
+// runPrivileged runs the command, through sudo if the manager is configured to use it.
+func (m *Manager) runPrivileged(path string, args ...string) ([]byte, error) {
+	if m.useSudo {
+		return m.run("sudo", append([]string{path}, args...)...)
+	}
+	return m.run(path, args...)
+}
+
+// runHost is runPrivileged in the host namespace, also when the manager has one.
+func (m *Manager) runHost(path string, args ...string) ([]byte, error) {
+	if m.useSudo {
+		return m.commander.Run("sudo", append([]string{path}, args...)...)
+	}
+	return m.commander.Run(path, args...)
+}
+
 // createBridge creates a bridge with the given name using the ip command.
 func (m *Manager) createBridge(name string) error {
 	var path string
@@ -91,12 +129,12 @@ func (m *Manager) createBridge(name string) error {
 	switch m.useSudo {
 	case true:
 		path = "sudo"
-		args = []string{"ip", "link", "add", "name", name, "type", "bridge"}
+		args = []string{"ip", "link", "add", "name", name, "type", "bridge", "vlan_filtering", "1"}
 	case false:
 		path = "ip"
-		args = []string{"link", "add", "name", name, "type", "bridge"}
+		args = []string{"link", "add", "name", name, "type", "bridge", "vlan_filtering", "1"}
 	}
-	_, err := m.commander.Run(path, args...)
+	_, err := m.run(path, args...)
 	if err != nil {
 		return fmt.Errorf("creating bridge: %w", err)
 	}
@@ -109,7 +147,7 @@ func (m *Manager) createBridge(name string) error {
 		path = "ip"
 		args = []string{"link", "set", "dev", name, "up"}
 	}
-	_, err = m.commander.Run(path, args...)
+	_, err = m.run(path, args...)
 	if err != nil {
 		return fmt.Errorf("setting link state up on bridge: %w", err)
 	}
@@ -118,10 +156,16 @@ func (m *Manager) createBridge(name string) error {
 
 // createTap creates a tap interface with the given name and mac address
 // using the ip command. it sets the interface to the UP state.
-func (m *Manager) createTap(name, mac string) error {
+func (m *Manager) createTap(name, mac string, multiQueue bool) error {
 	var path string
 	var args []string
 
+	// with a namespace the tap starts out in the host namespace, OpenTap moves it
+	run := m.run
+	if m.namespace != "" {
+		run = m.commander.Run
+	}
+
 	user, ok := os.LookupEnv("USER")
 	if !ok {
 		return fmt.Errorf("USER environment variable not set")
@@ -135,11 +179,14 @@ func (m *Manager) createTap(name, mac string) error {
 		path = "ip"
 		args = []string{"tuntap", "add", "dev", name, "mode", "tap"}
 	}
+	if multiQueue {
+		args = append(args, "multi_queue")
+	}
 	if user != "" {
 		args = append(args, "user", user)
 	}
 
-	_, err := m.commander.Run(path, args...)
+	_, err := run(path, args...)
 	if err != nil {
 		return fmt.Errorf("creating tap interface: %w", err)
 	}
@@ -153,7 +200,7 @@ func (m *Manager) createTap(name, mac string) error {
 			path = "ip"
 			args = []string{"link", "set", "dev", name, "address", mac}
 		}
-		_, err = m.commander.Run(path, args...)
+		_, err = run(path, args...)
 		if err != nil {
 			return fmt.Errorf("setting mac address on tap interface: %w", err)
 		}
@@ -167,7 +214,7 @@ func (m *Manager) createTap(name, mac string) error {
 		path = "ip"
 		args = []string{"link", "set", "dev", name, "up"}
 	}
-	_, err = m.commander.Run(path, args...)
+	_, err = run(path, args...)
 	if err != nil {
 		return fmt.Errorf("setting link state up on tap interface: %w", err)
 	}
@@ -178,7 +225,9 @@ func (m *Manager) createTap(name, mac string) error {
 }
 
 // delete tap will delete the tap interface with the given name.
-func (m *Manager) deleteTap(name string) error {
+// deleteTap deletes a tap. The kernel refuses to delete a multi-queue tap unless
+// multi_queue is given again.
+func (m *Manager) deleteTap(name string, multiQueue bool) error {
 	var path string
 	var args []string
 	switch m.useSudo {
@@ -189,7 +238,10 @@ func (m *Manager) deleteTap(name string) error {
 		path = "ip"
 		args = []string{"tuntap", "del", "dev", name, "mode", "tap"}
 	}
-	_, err := m.commander.Run(path, args...)
+	if multiQueue {
+		args = append(args, "multi_queue")
+	}
+	_, err := m.run(path, args...)
 	if err != nil {
 		return fmt.Errorf("deleting tap interfaces: %w", err)
 	}
@@ -207,13 +259,22 @@ func (m *Manager) addTapToBridge(useSudo bool, name, bridge string) error {
 		path = "ip"
 		args = []string{"link", "set", name, "master", bridge}
 	}
-	_, err := m.commander.Run(path, args...)
+	_, err := m.run(path, args...)
 	if err != nil {
 		return fmt.Errorf("adding tap to bridge: %w", err)
 	}
 	return nil
 }
 
+// releaseTap takes the tap out of its bridge.
+func (m *Manager) releaseTap(name string) error {
+	_, err := m.runPrivileged("ip", "link", "set", "dev", name, "nomaster")
+	if err != nil {
+		return fmt.Errorf("releasing %s from its bridge: %w", name, err)
+	}
+	return nil
+}
+
 func (m *Manager) listTaps() ([]tap, error) {
 	var path string
 	var args []string
@@ -225,7 +286,7 @@ func (m *Manager) listTaps() ([]tap, error) {
 		path = "ip"
 		args = []string{"link", "show", "type", "tun"}
 	}
-	out, err := m.commander.Run(path, args...)
+	out, err := m.run(path, args...)
 	if err != nil {
 		return nil, fmt.Errorf("listing taps: %w", err)
 	}
@@ -239,12 +300,12 @@ func (m *Manager) listBridges() ([]bridge, error) {
 	switch m.useSudo {
 	case true:
 		path = "sudo"
-		args = []string{"ip", "link", "show", "type", "bridge"}
+		args = []string{"ip", "-d", "link", "show", "type", "bridge"}
 	case false:
 		path = "ip"
-		args = []string{"link", "show", "type", "bridge"}
+		args = []string{"-d", "link", "show", "type", "bridge"}
 	}
-	out, err := m.commander.Run(path, args...)
+	out, err := m.run(path, args...)
 	if err != nil {
 		return nil, fmt.Errorf("listing bridges: %w", err)
 	}
@@ -263,10 +324,61 @@ func (m *Manager) listTapsOnBridge(br string) ([]tap, error) {
 		path = "ip"
 		args = []string{"link", "show", "master", br, "type", "tun"}
 	}
-	out, err := m.commander.Run(path, args...)
+	out, err := m.run(path, args...)
 	if err != nil {
 		return nil, fmt.Errorf("listing taps: %w", err)
 	}
 	taps := parseTaps(out)
 	return taps, nil
 }
+
+// listBridgeVlans returns the VLAN entries of all bridge ports.
+func (m *Manager) listBridgeVlans() (map[string][]vlanEntry, error) {
+	out, err := m.runPrivileged("bridge", "vlan", "show")
+	if err != nil {
+		return nil, fmt.Errorf("listing bridge vlans: %w", err)
+	}
+	return parseBridgeVlans(out), nil
+}
+
+// setPortVlans configures the VLANs of a bridge port.
+func (m *Manager) setPortVlans(port string, v PortVLANs) error {
+	for _, args := range bridgeVlanCommands(port, v) {
+		_, err := m.runPrivileged("bridge", args...)
+		if err != nil {
+			return fmt.Errorf("setting vlans on %s: %w", port, err)
+		}
+	}
+	return nil
+}
+
+// enableVlanFiltering turns on vlan_filtering on an existing bridge.
+func (m *Manager) enableVlanFiltering(bridge string) error {
+	_, err := m.runPrivileged("ip", "link", "set", "dev", bridge, "type", "bridge", "vlan_filtering", "1")
+	if err != nil {
+		return fmt.Errorf("enabling vlan filtering on %s: %w", bridge, err)
+	}
+	return nil
+}
+
+// setLinkState sets the interface administratively up or down.
+func (m *Manager) setLinkState(name string, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	_, err := m.runPrivileged("ip", "link", "set", "dev", name, state)
+	if err != nil {
+		return fmt.Errorf("setting %s %s: %w", name, state, err)
+	}
+	return nil
+}
+
+// setMTU sets the MTU of a tap or bridge.
+func (m *Manager) setMTU(name string, mtu int) error {
+	_, err := m.runPrivileged("ip", "link", "set", "dev", name, "mtu", strconv.Itoa(mtu))
+	if err != nil {
+		return fmt.Errorf("setting mtu %d on %s: %w", mtu, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package tuntap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lldpGroupBit is the bit of 01:80:c2:00:00:0e, the LLDP nearest bridge address,
+// in the group_fwd_mask of a bridge.
+const lldpGroupBit = 1 << 0x0e
+
+// ForwardLLDP makes the bridge forward LLDP frames between its ports, which Linux
+// bridges drop by default, so the VMs on it see each other as neighbors.
+// RestoreLLDP undoes it.
+func (m *Manager) ForwardLLDP(bridge string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(sysClassNet, bridge, "bridge", "group_fwd_mask"))
+	if err != nil {
+		return fmt.Errorf("reading group_fwd_mask of %s: %w", bridge, err)
+	}
+	mask, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 16)
+	if err != nil {
+		return fmt.Errorf("parsing group_fwd_mask of %s: %w", bridge, err)
+	}
+	if mask&lldpGroupBit != 0 {
+		return nil
+	}
+	_, err = m.runPrivileged("ip", "link", "set", "dev", bridge, "type", "bridge", "group_fwd_mask", fmt.Sprintf("%#x", mask|lldpGroupBit))
+	if err != nil {
+		return fmt.Errorf("forwarding lldp on %s: %w", bridge, err)
+	}
+	m.fwdMasks[bridge] = mask
+	return nil
+}
+
+// RestoreLLDP puts back the group_fwd_mask the bridge had before ForwardLLDP changed it.
+// It does nothing if ForwardLLDP left the bridge alone.
+func (m *Manager) RestoreLLDP(bridge string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mask, ok := m.fwdMasks[bridge]
+	if !ok {
+		return nil
+	}
+	_, err := m.runPrivileged("ip", "link", "set", "dev", bridge, "type", "bridge", "group_fwd_mask", fmt.Sprintf("%#x", mask))
+	if err != nil {
+		return fmt.Errorf("restoring group_fwd_mask of %s: %w", bridge, err)
+	}
+	delete(m.fwdMasks, bridge)
+	return nil
+}
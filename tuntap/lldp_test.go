@@ -0,0 +1,42 @@
+package tuntap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManager_ForwardLLDP(t *testing.T) {
+	old := sysClassNet
+	t.Cleanup(func() { sysClassNet = old })
+	sysClassNet = t.TempDir()
+	for bridge, mask := range map[string]string{"lab-1": "0x8\n", "lab-2": "0x4000\n"} {
+		dir := filepath.Join(sysClassNet, bridge, "bridge")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "group_fwd_mask"), []byte(mask), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "lldp.json")
+	if err := m.ForwardLLDP("lab-1"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	// already forwarding, nothing to run
+	if err := m.ForwardLLDP("lab-2"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := m.ForwardLLDP("lab-3"); err == nil {
+		t.Errorf("expected an error for a missing bridge")
+	}
+	if err := m.RestoreLLDP("lab-1"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	// left alone by ForwardLLDP, nothing to restore
+	if err := m.RestoreLLDP("lab-2"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package tuntap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MacvtapMode is the mode of a macvtap device, it decides how traffic between
+// macvtaps on the same parent link is handled.
+type MacvtapMode string
+
+const (
+	// MacvtapBridge switches traffic between macvtaps on the same parent locally.
+	MacvtapBridge MacvtapMode = "bridge"
+	// MacvtapVEPA sends all traffic to the external switch, which must reflect it.
+	MacvtapVEPA MacvtapMode = "vepa"
+	// MacvtapPrivate isolates the macvtaps on the same parent from each other.
+	MacvtapPrivate MacvtapMode = "private"
+	// MacvtapPassthru gives the whole parent link to a single macvtap.
+	MacvtapPassthru MacvtapMode = "passthru"
+)
+
+// ParseMacvtapMode parses a macvtap mode, the empty string is bridge mode.
+func ParseMacvtapMode(s string) (MacvtapMode, error) {
+	switch m := MacvtapMode(s); m {
+	case "":
+		return MacvtapBridge, nil
+	case MacvtapBridge, MacvtapVEPA, MacvtapPrivate, MacvtapPassthru:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown macvtap mode %q, expected bridge, vepa, private or passthru", s)
+}
+
+// macvtap is a macvtap device created by the manager.
+type macvtap struct {
+	name   string
+	parent string
+	mode   MacvtapMode
+	mac    string
+}
+
+// sysClassNet and devDir are variables so tests can point them at a fake tree.
+var (
+	sysClassNet = "/sys/class/net"
+	devDir      = "/dev"
+)
+
+// CreateMacvtap creates a macvtap device on the parent link and gives it the mac address.
+// The guest must use the same mac address, the macvtap only receives frames for it.
+func (m *Manager) CreateMacvtap(name, parent string, mode MacvtapMode, mac string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.macvtaps[name]; ok {
+		return fmt.Errorf("macvtap device %s already exists", name)
+	}
+	if _, err := ParseMacvtapMode(string(mode)); err != nil {
+		return err
+	}
+	_, err := m.runPrivileged("ip", "link", "add", "link", parent, "name", name, "type", "macvtap", "mode", string(mode))
+	if err != nil {
+		return fmt.Errorf("creating macvtap on %s: %w", parent, err)
+	}
+	_, err = m.runPrivileged("ip", "link", "set", "dev", name, "address", mac, "up")
+	if err != nil {
+		_, _ = m.runPrivileged("ip", "link", "del", "dev", name)
+		return fmt.Errorf("setting up macvtap %s: %w", name, err)
+	}
+	m.macvtaps[name] = &macvtap{name: name, parent: parent, mode: mode, mac: mac}
+	return nil
+}
+
+// OpenMacvtap opens the character device of a macvtap created by CreateMacvtap. The
+// file is handed to qemu with -netdev tap,fd=. The node /dev/tapN belongs to root,
+// with sudo it is given to the user first, otherwise the user needs access already.
+func (m *Manager) OpenMacvtap(name string) (*os.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strings.ContainsAny(name, "/.") {
+		return nil, fmt.Errorf("bad interface name %q", name)
+	}
+	if _, ok := m.macvtaps[name]; !ok {
+		return nil, fmt.Errorf("macvtap device %s does not exist", name)
+	}
+	data, err := os.ReadFile(filepath.Join(sysClassNet, name, "ifindex"))
+	if err != nil {
+		return nil, fmt.Errorf("reading ifindex of %s: %w", name, err)
+	}
+	ifindex, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing ifindex of %s: %w", name, err)
+	}
+	dev := filepath.Join(devDir, fmt.Sprintf("tap%d", ifindex))
+	// udev creates the device node shortly after the link appears.
+	for i := 0; ; i++ {
+		_, err = os.Stat(dev)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) || i == 20 {
+			return nil, fmt.Errorf("opening %s: %w", dev, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if m.useSudo {
+		_, err = m.runPrivileged("chown", strconv.Itoa(os.Getuid()), dev)
+		if err != nil {
+			return nil, fmt.Errorf("giving %s to the user: %w", dev, err)
+		}
+	}
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", dev, err)
+	}
+	return f, nil
+}
+
+// SetMacvtapMTU sets the mtu of a macvtap, the kernel refuses an mtu above the one of the parent link.
+func (m *Manager) SetMacvtapMTU(name string, mtu int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.macvtaps[name]; !ok {
+		return fmt.Errorf("macvtap device %s does not exist", name)
+	}
+	if err := validateMTU(mtu); err != nil {
+		return err
+	}
+	return m.setMTU(name, mtu)
+}
+
+// DeleteMacvtap deletes a macvtap device created by CreateMacvtap.
+func (m *Manager) DeleteMacvtap(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.macvtaps[name]; !ok {
+		return fmt.Errorf("macvtap device %s does not exist", name)
+	}
+	_, err := m.runPrivileged("ip", "link", "del", "dev", name)
+	if err != nil {
+		return fmt.Errorf("deleting macvtap %s: %w", name, err)
+	}
+	delete(m.macvtaps, name)
+	return nil
+}
@@ -0,0 +1,97 @@
+package tuntap
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseMacvtapMode(t *testing.T) {
+	mode, err := ParseMacvtapMode("")
+	if err != nil || mode != MacvtapBridge {
+		t.Errorf("expected bridge mode, got %q, %v", mode, err)
+	}
+	mode, err = ParseMacvtapMode("passthru")
+	if err != nil || mode != MacvtapPassthru {
+		t.Errorf("expected passthru mode, got %q, %v", mode, err)
+	}
+	if _, err := ParseMacvtapMode("source"); err == nil {
+		t.Errorf("expected error for unknown mode")
+	}
+}
+
+func TestManager_Macvtap(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "macvtap.json")
+	err := m.CreateMacvtap("mvt0", "eth0", MacvtapVEPA, "52:54:00:12:34:56")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.CreateMacvtap("mvt0", "eth0", MacvtapVEPA, "52:54:00:12:34:56"); err == nil {
+		t.Errorf("expected error creating mvt0 twice")
+	}
+	err = m.DeleteMacvtap("mvt0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.DeleteMacvtap("mvt0"); err == nil {
+		t.Errorf("expected error deleting mvt0 twice")
+	}
+}
+
+func TestManager_OpenMacvtap(t *testing.T) {
+	dir := t.TempDir()
+	oldSys, oldDev := sysClassNet, devDir
+	t.Cleanup(func() { sysClassNet, devDir = oldSys, oldDev })
+	sysClassNet = filepath.Join(dir, "sys")
+	devDir = filepath.Join(dir, "dev")
+	for _, d := range []string{filepath.Join(sysClassNet, "mvt0"), devDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(sysClassNet, "mvt0", "ifindex"), []byte("42\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(devDir, "tap42"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dev := filepath.Join(devDir, "tap42")
+	// with sudo the node is handed to the user before it is opened
+	recording := []invocation{{Path: "sudo", Args: []string{"chown", strconv.Itoa(os.Getuid()), dev}}}
+	data, err := json.Marshal(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "open.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReplayingExecutor(filepath.Join(dir, "open.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	m.SetSudo(true)
+	m.commander = r
+	if _, err := m.OpenMacvtap("mvt0"); err == nil {
+		t.Errorf("expected error for a macvtap the manager did not create")
+	}
+	m.macvtaps["mvt0"] = &macvtap{name: "mvt0", parent: "eth0", mode: MacvtapBridge}
+	f, err := m.OpenMacvtap("mvt0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_ = f.Close()
+	if f.Name() != dev {
+		t.Errorf("expected %s, got %s", dev, f.Name())
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("replay: %v", err)
+	}
+	if _, err := m.OpenMacvtap("../mvt0"); err == nil {
+		t.Errorf("expected error for bad name")
+	}
+}
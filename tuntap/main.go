@@ -1,11 +1,16 @@
 package tuntap
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"sync"
 )
 
+// defaultMTU is what the kernel gives new taps and bridges.
+const defaultMTU = 1500
+
 type tapMap map[string]*tap
 type bridgeMap map[string]*bridge
 
@@ -13,8 +18,12 @@ type Manager struct {
 	mu        sync.Mutex
 	taps      tapMap
 	bridges   bridgeMap
+	macvtaps  map[string]*macvtap
+	uplinks   map[string]*uplink
+	fwdMasks  map[string]uint64 // group_fwd_mask before ForwardLLDP, by bridge
 	useSudo   bool
 	commander Executor
+	namespace string // network namespace of the links, the host namespace if empty
 }
 
 func New() *Manager {
@@ -23,6 +32,9 @@ func New() *Manager {
 	return &Manager{
 		taps:      make(tapMap),
 		bridges:   make(bridgeMap),
+		macvtaps:  make(map[string]*macvtap),
+		uplinks:   make(map[string]*uplink),
+		fwdMasks:  make(map[string]uint64),
 		commander: exe,
 		mu:        sync.Mutex{},
 	}
@@ -40,6 +52,24 @@ func (m *Manager) SetSudo(useSudo bool) {
 	m.useSudo = useSudo
 }
 
+// UseNetAdmin switches the manager to run ip without sudo, passing on CAP_NET_ADMIN
+// held by this process. If the capability is not available, the returned error
+// explains what is missing and the manager is left unchanged.
+func (m *Manager) UseNetAdmin() error {
+	n, err := CheckNetAdmin()
+	if err != nil {
+		return fmt.Errorf("checking capabilities: %w", err)
+	}
+	if !n.Usable() {
+		return fmt.Errorf("no CAP_NET_ADMIN: %s", n.Missing())
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.useSudo = false
+	m.commander = &executor{netAdmin: true}
+	return nil
+}
+
 func (m *bridgeMap) String() string {
 	s := ""
 	for _, b := range *m {
@@ -67,19 +97,31 @@ func (m *Manager) Load() error {
 		return fmt.Errorf("listTaps: %w", err)
 	}
 	for _, t := range taps {
-		m.taps[t.name] = &tap{name: t.name, mac: t.mac}
+		m.taps[t.name] = &tap{name: t.name, mac: t.mac, mtu: t.mtu}
 	}
 	bridges, err := m.listBridges()
 	if err != nil {
 		return fmt.Errorf("listBridges: %w", err)
 	}
+	var portVlans map[string][]vlanEntry
+	for _, br := range bridges {
+		if br.vlanFiltering {
+			portVlans, err = m.listBridgeVlans()
+			if err != nil {
+				return fmt.Errorf("listBridgeVlans: %w", err)
+			}
+			break
+		}
+	}
 	// register the bridges
 	for _, br := range bridges {
 
 		mybr := &bridge{
-			name:   br.name,
-			mac:    br.mac,
-			ifaces: make([]*tap, 0),
+			name:          br.name,
+			mac:           br.mac,
+			mtu:           br.mtu,
+			ifaces:        make([]*tap, 0),
+			vlanFiltering: br.vlanFiltering,
 		}
 		// now list the taps on the bridge
 		brtaps, err := m.listTapsOnBridge(br.name)
@@ -94,6 +136,9 @@ func (m *Manager) Load() error {
 			}
 			brtap.bridge = mybr
 			mybr.ifaces = append(mybr.ifaces, brtap)
+			if mybr.vlanFiltering {
+				mybr.setVlans(brtap, portVLANsFromEntries(portVlans[t.name]))
+			}
 		}
 		m.bridges[br.name] = mybr
 	}
@@ -101,6 +146,16 @@ func (m *Manager) Load() error {
 }
 
 func (m *Manager) CreateTap(tapName string) error {
+	return m.addTap(tapName, false)
+}
+
+// CreateMultiQueueTap creates a tap device with the multi_queue flag, so qemu can
+// attach one queue per vCPU to it.
+func (m *Manager) CreateMultiQueueTap(tapName string) error {
+	return m.addTap(tapName, true)
+}
+
+func (m *Manager) addTap(tapName string, multiQueue bool) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if _, ok := m.taps[tapName]; ok {
@@ -112,13 +167,13 @@ func (m *Manager) CreateTap(tapName string) error {
 	}
 
 	mac := makeRandomMac(userName + tapName)
-	err := m.createTap(tapName, mac)
+	err := m.createTap(tapName, mac, multiQueue)
 	if err != nil {
 		// cleanup
-		_ = m.deleteTap(tapName)
+		_ = m.deleteTap(tapName, multiQueue)
 		return fmt.Errorf("creating tap device: %w", err)
 	}
-	m.taps[tapName] = &tap{name: tapName, mac: mac, mine: true}
+	m.taps[tapName] = &tap{name: tapName, mac: mac, mtu: defaultMTU, mine: true, multiQueue: multiQueue}
 	return nil
 }
 
@@ -148,12 +203,19 @@ func (m *Manager) DeleteTaps() error {
 			}
 		}
 	}
-	// now we delete all the taps from the manager and the underlying system:
-	for _, t := range m.taps {
+	// now we delete all the taps from the manager and the underlying system.
+	// sorted so the order of commands is predictable.
+	names := make([]string, 0, len(m.taps))
+	for name := range m.taps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		t := m.taps[name]
 		if !t.mine {
 			continue
 		}
-		err := m.deleteTap(t.name)
+		err := m.deleteTap(t.name, t.multiQueue)
 		if err != nil {
 			return fmt.Errorf("deleteTap: %w", err)
 		}
@@ -163,9 +225,14 @@ func (m *Manager) DeleteTaps() error {
 	return nil
 }
 
-func (m *Manager) AddTapToBridge(name, bridge string) error {
+// AddTapToBridge adds the tap to the bridge. With non-zero vlans the tap becomes an
+// access or trunk port, which requires vlan filtering on the bridge.
+func (m *Manager) AddTapToBridge(name, bridge string, vlans PortVLANs) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if err := vlans.Validate(); err != nil {
+		return fmt.Errorf("tap %s: %w", name, err)
+	}
 	t, ok := m.taps[name]
 	if !ok {
 		return fmt.Errorf("tap device %s does not exist", name)
@@ -174,15 +241,152 @@ func (m *Manager) AddTapToBridge(name, bridge string) error {
 	if !ok {
 		return fmt.Errorf("bridge %s does not exist", bridge)
 	}
+	if !vlans.IsZero() && !br.vlanFiltering {
+		return fmt.Errorf("bridge %s does not have vlan filtering enabled", bridge)
+	}
+	if br.mtu != 0 && t.mtu > br.mtu {
+		return fmt.Errorf("mtu %d of %s exceeds mtu %d of bridge %s", t.mtu, name, br.mtu, bridge)
+	}
 	err := br.addTap(t)
 	if err != nil {
 		return fmt.Errorf("addTapToBridge: %w", err)
 	}
 	err = m.addTapToBridge(m.useSudo, name, bridge)
 	if err != nil {
+		_ = br.removeTap(t)
 		return fmt.Errorf("addTapToBridge: %w", err)
 	}
 	m.taps[name].bridge = br
+	if !br.vlanFiltering {
+		return nil
+	}
+	if vlans.IsZero() {
+		// the kernel puts new ports untagged in the default vlan
+		br.setVlans(t, PortVLANs{Access: 1})
+		return nil
+	}
+	err = m.setPortVlans(name, vlans)
+	if err != nil {
+		// leave the tap off the bridge rather than in the default vlan
+		err = errors.Join(fmt.Errorf("setPortVlans: %w", err), m.releaseTap(name))
+		_ = br.removeTap(t)
+		t.bridge = nil
+		return err
+	}
+	br.setVlans(t, vlans)
+	return nil
+}
+
+// EnableVLANFiltering turns on vlan filtering on a bridge that was not created by the manager.
+func (m *Manager) EnableVLANFiltering(bridge string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	br, ok := m.bridges[bridge]
+	if !ok {
+		return fmt.Errorf("bridge %s does not exist", bridge)
+	}
+	if br.vlanFiltering {
+		return nil
+	}
+	err := m.enableVlanFiltering(bridge)
+	if err != nil {
+		return fmt.Errorf("enableVlanFiltering: %w", err)
+	}
+	br.vlanFiltering = true
+	for _, t := range br.ifaces {
+		br.setVlans(t, PortVLANs{Access: 1})
+	}
+	return nil
+}
+
+// GetVLANs returns the VLAN membership of a tap on a bridge with vlan filtering.
+func (m *Manager) GetVLANs(name string) (PortVLANs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.taps[name]
+	if !ok {
+		return PortVLANs{}, fmt.Errorf("tap device %s does not exist", name)
+	}
+	if t.bridge == nil || !t.bridge.vlanFiltering {
+		return PortVLANs{}, fmt.Errorf("tap device %s is not on a bridge with vlan filtering", name)
+	}
+	return t.bridge.vlans[name], nil
+}
+
+// SetLinkState takes the tap administratively down or up. While it is down the
+// host side has no carrier and no traffic reaches the VM.
+func (m *Manager) SetLinkState(name string, up bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.taps[name]; !ok {
+		return fmt.Errorf("tap device %s does not exist", name)
+	}
+	return m.setLinkState(name, up)
+}
+
+// SetTapMTU sets the MTU of a tap. It may not exceed the MTU of the bridge the tap is on.
+func (m *Manager) SetTapMTU(name string, mtu int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.taps[name]
+	if !ok {
+		return fmt.Errorf("tap device %s does not exist", name)
+	}
+	if err := validateMTU(mtu); err != nil {
+		return err
+	}
+	if t.bridge != nil && t.bridge.mtu != 0 && mtu > t.bridge.mtu {
+		return fmt.Errorf("mtu %d of %s exceeds mtu %d of bridge %s", mtu, name, t.bridge.mtu, t.bridge.name)
+	}
+	err := m.setMTU(name, mtu)
+	if err != nil {
+		return fmt.Errorf("setMTU: %w", err)
+	}
+	t.mtu = mtu
+	return nil
+}
+
+// SetBridgeMTU sets the MTU of a bridge. It may not be lower than the MTU of any tap on it.
+func (m *Manager) SetBridgeMTU(bridge string, mtu int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	br, ok := m.bridges[bridge]
+	if !ok {
+		return fmt.Errorf("bridge %s does not exist", bridge)
+	}
+	if err := validateMTU(mtu); err != nil {
+		return err
+	}
+	for _, t := range br.ifaces {
+		if t.mtu > mtu {
+			return fmt.Errorf("mtu %d of bridge %s is below mtu %d of %s", mtu, bridge, t.mtu, t.name)
+		}
+	}
+	err := m.setMTU(bridge, mtu)
+	if err != nil {
+		return fmt.Errorf("setMTU: %w", err)
+	}
+	br.mtu = mtu
+	return nil
+}
+
+// GetMTU returns the MTU of a tap or bridge.
+func (m *Manager) GetMTU(name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.taps[name]; ok {
+		return t.mtu, nil
+	}
+	if br, ok := m.bridges[name]; ok {
+		return br.mtu, nil
+	}
+	return 0, fmt.Errorf("%s is neither a tap device nor a bridge", name)
+}
+
+func validateMTU(mtu int) error {
+	if mtu < 68 || mtu > 65535 {
+		return fmt.Errorf("mtu %d out of range", mtu)
+	}
 	return nil
 }
 
@@ -210,7 +414,7 @@ func (m *Manager) CreateBridge(brname string) error {
 	if err != nil {
 		return fmt.Errorf("createBridge: %w", err)
 	}
-	m.bridges[brname] = &bridge{name: brname, ifaces: make([]*tap, 0)}
+	m.bridges[brname] = &bridge{name: brname, ifaces: make([]*tap, 0), mtu: defaultMTU, vlanFiltering: true}
 	return nil
 }
 
@@ -10,56 +10,44 @@ import (
 //go:embed testdata/tap-output.txt
 var tap_list_output []byte
 
-//go:embed testdata/bridge-listing.txt
-var bridge_list_output []byte
+//go:embed testdata/tap-with-tailscale.txt
+var tap_with_tailscale []byte
 
-//go:embed testdata/taps-on-bridge-br0.txt
-var bridge_tap_list_output_br0 []byte
+//go:embed testdata/bridge-vlan-show.txt
+var bridge_vlan_show_output []byte
 
-//go:embed testdata/taps-on-bridge-br1.txt
-var bridge_tap_list_output_br1 []byte
+//go:embed testdata/link-show-eth1.txt
+var link_show_eth1 []byte
 
-//go:embed testdata/tap-with-tailscale.txt
-var tap_with_tailscale []byte
+//go:embed testdata/addr-show-eth1.txt
+var addr_show_eth1 []byte
 
-type mockExecutor struct {
-	noOutput bool
-}
+//go:embed testdata/route-show-eth1.txt
+var route_show_eth1 []byte
 
-func (e *mockExecutor) Run(path string, args ...string) ([]byte, error) {
-	if e.noOutput {
-		return nil, nil
-	}
-	if path == "ip" && args[0] == "link" && args[1] == "show" && args[2] == "type" && args[3] == "tun" {
-		log.Println("mockExecutor listing taps:", path, args)
-		return tap_list_output, nil
-	}
-	if path == "ip" && args[0] == "link" && args[1] == "show" && args[2] == "type" && args[3] == "bridge" {
-		log.Println("mockExecutor listing bridges:", path, args)
-		return bridge_list_output, nil
-	}
-	if path == "ip" && args[0] == "link" && args[1] == "show" && args[2] == "master" && args[4] == "type" && args[5] == "tun" && args[3] == "br0" {
-		log.Println("mockExecutor listing taps on br0:", path, args)
-		return bridge_tap_list_output_br0, nil
-	}
-	if path == "ip" && args[0] == "link" && args[1] == "show" && args[2] == "master" && args[4] == "type" && args[5] == "tun" && args[3] == "br1" {
-		log.Println("mockExecutor listing taps on br1:", path, args)
-		return bridge_tap_list_output_br1, nil
-	}
-	if path == "ip" && args[0] == "tuntap" && args[1] == "del" && args[2] == "dev" && args[4] == "mode" && args[5] == "tap" {
-		log.Println("mockExecutor deleting tap:", args[3])
-		return nil, nil
+//go:embed testdata/route6-show-eth1.txt
+var route6_show_eth1 []byte
+
+// replay returns an executor serving the named recording from testdata and
+// checks that every recorded command was used when the test ends.
+func replay(t *testing.T, name string) *ReplayingExecutor {
+	t.Helper()
+	r, err := NewReplayingExecutor("testdata/" + name)
+	if err != nil {
+		t.Fatalf("loading recording: %v", err)
 	}
-	log.Println("mockExecutor unknown command:", path, args)
-	panic("unknown command")
+	t.Cleanup(func() {
+		if err := r.Done(); err != nil {
+			t.Errorf("replay: %v", err)
+		}
+	})
+	return r
 }
 
 func TestManager_Load(t *testing.T) {
-	mock := &mockExecutor{}
-
 	m := New()
 	m.SetSudo(false)
-	m.commander = mock
+	m.commander = replay(t, "load.json")
 	err := m.Load()
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
@@ -107,11 +95,40 @@ func TestManager_Load(t *testing.T) {
 	}
 }
 
+// TestManager_Load_recorded replays a Load recorded with Manager.Record on a host with
+// br0 holding tap0 and tap1 and tap2 on no bridge. The kernel had no vlan filtering,
+// load.json covers that.
+func TestManager_Load_recorded(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "load-recorded.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(m.taps) != 3 || len(m.bridges) != 1 {
+		t.Errorf("expected 3 taps and 1 bridge, got %d and %d", len(m.taps), len(m.bridges))
+	}
+	for _, name := range []string{"tap0", "tap1"} {
+		if br := m.taps[name].bridge; br == nil || br.name != "br0" {
+			t.Errorf("expected %s to be on br0, got %v", name, br)
+		}
+	}
+	if m.taps["tap2"].bridge != nil {
+		t.Errorf("expected tap2 on no bridge, got %s", m.taps["tap2"].bridge.name)
+	}
+	if m.taps["tap0"].mac != "3a:75:c0:83:6e:b8" {
+		t.Errorf("expected the mac of tap0 to be 3a:75:c0:83:6e:b8, got %s", m.taps["tap0"].mac)
+	}
+	if br := m.bridges["br0"]; br.vlanFiltering || br.mac != "3a:2b:ab:87:6c:6b" {
+		t.Errorf("expected br0 without vlan filtering and mac 3a:2b:ab:87:6c:6b, got %v %s", br.vlanFiltering, br.mac)
+	}
+}
+
 func TestManager_DeleteTaps(t *testing.T) {
-	mock := &mockExecutor{}
 	m := New()
 	m.SetSudo(false)
-	m.commander = mock
+	m.commander = replay(t, "delete-taps.json")
 	err := m.Load()
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
@@ -140,21 +157,20 @@ func TestManager_DeleteTaps(t *testing.T) {
 }
 
 func TestManager_NoTaps(t *testing.T) {
-	mock := &mockExecutor{
-		noOutput: true,
-	}
 	m := New()
 	m.SetSudo(false)
-	m.commander = mock
+	m.commander = replay(t, "no-taps.json")
 	err := m.Load()
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
-
+	if len(m.taps) != 0 || len(m.bridges) != 0 {
+		t.Errorf("expected no taps or bridges, got %d and %d", len(m.taps), len(m.bridges))
+	}
 }
 
 func Test_makeRandomMac(t *testing.T) {
 	for i := 0; i < 10; i++ {
-		fmt.Println(makeRandomMac())
+		fmt.Println(makeRandomMac(fmt.Sprintf("tap%d", i)))
 	}
 }
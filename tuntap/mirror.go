@@ -0,0 +1,153 @@
+package tuntap
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// MirrorDirection selects which traffic of the source is mirrored. On a tap,
+// ingress is what the VM sends and egress is what it receives.
+type MirrorDirection int
+
+const (
+	MirrorIngress MirrorDirection = 1 << iota
+	MirrorEgress
+	MirrorBoth = MirrorIngress | MirrorEgress
+)
+
+// ParseMirrorDirection parses ingress, egress or both.
+func ParseMirrorDirection(s string) (MirrorDirection, error) {
+	switch s {
+	case "ingress":
+		return MirrorIngress, nil
+	case "egress":
+		return MirrorEgress, nil
+	case "both", "":
+		return MirrorBoth, nil
+	}
+	return 0, fmt.Errorf("unknown mirror direction %q", s)
+}
+
+func (d MirrorDirection) String() string {
+	switch d {
+	case MirrorIngress:
+		return "ingress"
+	case MirrorEgress:
+		return "egress"
+	case MirrorBoth:
+		return "both"
+	}
+	return "none"
+}
+
+// mirror is a tc filter on the source copying packets to the target.
+type mirror struct {
+	target    string
+	direction MirrorDirection
+	pref      int // filter preference, identifies the filter on the source
+}
+
+// firstMirrorPref is where our filter preferences start, to stay clear of filters added by others.
+const firstMirrorPref = 40000
+
+// addMirrorFilters installs a clsact qdisc on the source and matchall filters with mirred actions.
+func (m *Manager) addMirrorFilters(source string, mr mirror) error {
+	_, err := m.runPrivileged("tc", "qdisc", "replace", "dev", source, "clsact")
+	if err != nil {
+		return fmt.Errorf("adding clsact to %s: %w", source, err)
+	}
+	for _, hook := range mr.direction.hooks() {
+		_, err := m.runPrivileged("tc", "filter", "add", "dev", source, hook, "pref", strconv.Itoa(mr.pref),
+			"matchall", "action", "mirred", "egress", "mirror", "dev", mr.target)
+		if err != nil {
+			return fmt.Errorf("mirroring %s %s to %s: %w", source, hook, mr.target, err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) delMirrorFilters(source string, mr mirror) error {
+	for _, hook := range mr.direction.hooks() {
+		_, err := m.runPrivileged("tc", "filter", "del", "dev", source, hook, "pref", strconv.Itoa(mr.pref))
+		if err != nil {
+			return fmt.Errorf("removing mirror of %s %s: %w", source, hook, err)
+		}
+	}
+	return nil
+}
+
+func (d MirrorDirection) hooks() []string {
+	var hooks []string
+	if d&MirrorIngress != 0 {
+		hooks = append(hooks, "ingress")
+	}
+	if d&MirrorEgress != 0 {
+		hooks = append(hooks, "egress")
+	}
+	return hooks
+}
+
+// AddMirror copies the traffic of the source tap to the target, a tap or another bridge port.
+// A source can be mirrored to several targets.
+func (m *Manager) AddMirror(source, target string, direction MirrorDirection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.taps[source]
+	if !ok {
+		return fmt.Errorf("tap device %s does not exist", source)
+	}
+	if direction&MirrorBoth == 0 || direction&^MirrorBoth != 0 {
+		return fmt.Errorf("bad mirror direction %d", direction)
+	}
+	if target == source {
+		return fmt.Errorf("cannot mirror %s to itself", source)
+	}
+	for _, mr := range t.mirrors {
+		if mr.target == target {
+			return fmt.Errorf("%s is already mirrored to %s", source, target)
+		}
+	}
+	mr := mirror{target: target, direction: direction, pref: firstMirrorPref}
+	for _, existing := range t.mirrors {
+		mr.pref = max(mr.pref, existing.pref+1)
+	}
+	err := m.addMirrorFilters(source, mr)
+	if err != nil {
+		// don't leave half a mirror behind
+		_ = m.delMirrorFilters(source, mr)
+		return fmt.Errorf("addMirror: %w", err)
+	}
+	t.mirrors = append(t.mirrors, mr)
+	return nil
+}
+
+// RemoveMirrors removes all mirrors set up on the source tap.
+func (m *Manager) RemoveMirrors(source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.taps[source]
+	if !ok {
+		return fmt.Errorf("tap device %s does not exist", source)
+	}
+	for len(t.mirrors) > 0 {
+		err := m.delMirrorFilters(source, t.mirrors[0])
+		if err != nil {
+			return fmt.Errorf("removeMirrors: %w", err)
+		}
+		t.mirrors = t.mirrors[1:]
+	}
+	return nil
+}
+
+// Mirrors returns the targets the source tap is mirrored to, with their direction.
+func (m *Manager) Mirrors(source string) map[string]MirrorDirection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mirrors := make(map[string]MirrorDirection)
+	if t, ok := m.taps[source]; ok {
+		for _, mr := range t.mirrors {
+			mirrors[mr.target] = mr.direction
+		}
+	}
+	return mirrors
+}
@@ -0,0 +1,53 @@
+package tuntap
+
+import "testing"
+
+func TestManager_AddMirror(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "mirror.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.AddMirror("tap0", "tap2", MirrorBoth)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.AddMirror("tap0", "tap3", MirrorIngress)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.AddMirror("tap0", "tap2", MirrorEgress); err == nil {
+		t.Errorf("expected error mirroring to the same target twice")
+	}
+	if err := m.AddMirror("tap0", "tap0", MirrorEgress); err == nil {
+		t.Errorf("expected error mirroring to itself")
+	}
+	mirrors := m.Mirrors("tap0")
+	if len(mirrors) != 2 || mirrors["tap2"] != MirrorBoth || mirrors["tap3"] != MirrorIngress {
+		t.Errorf("expected mirrors to tap2 and tap3, got %v", mirrors)
+	}
+	err = m.RemoveMirrors("tap0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(m.Mirrors("tap0")) != 0 {
+		t.Errorf("expected no mirrors left")
+	}
+}
+
+func TestParseMirrorDirection(t *testing.T) {
+	for _, s := range []string{"ingress", "egress", "both"} {
+		d, err := ParseMirrorDirection(s)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if d.String() != s {
+			t.Errorf("expected %s, got %s", s, d)
+		}
+	}
+	if _, err := ParseMirrorDirection("sideways"); err == nil {
+		t.Errorf("expected error for unknown direction")
+	}
+}
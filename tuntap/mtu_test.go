@@ -0,0 +1,46 @@
+package tuntap
+
+import "testing"
+
+func Test_parseMTU(t *testing.T) {
+	taps := parseTaps(tap_list_output)
+	for _, tap := range taps {
+		if tap.mtu != 1500 {
+			t.Errorf("expected mtu 1500 on %s, got %d", tap.name, tap.mtu)
+		}
+	}
+}
+
+func TestManager_SetMTU(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "mtu.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.SetTapMTU("tap2", 9000); err == nil {
+		t.Errorf("expected error raising tap mtu above the bridge mtu")
+	}
+	err = m.SetBridgeMTU("br1", 9000)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.SetTapMTU("tap2", 9000)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := m.SetBridgeMTU("br1", 1500); err == nil {
+		t.Errorf("expected error lowering bridge mtu below a tap mtu")
+	}
+	if err := m.SetTapMTU("tap2", 10); err == nil {
+		t.Errorf("expected error for mtu out of range")
+	}
+	mtu, err := m.GetMTU("tap2")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if mtu != 9000 {
+		t.Errorf("expected mtu 9000, got %d", mtu)
+	}
+}
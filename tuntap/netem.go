@@ -0,0 +1,250 @@
+package tuntap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Impairment describes how traffic sent to a VM through its tap is degraded,
+// using a netem qdisc, with a tbf qdisc below it for the rate limit.
+// Percentages are 0-100, the zero value is a perfect wire.
+type Impairment struct {
+	Delay     time.Duration
+	Jitter    time.Duration
+	Loss      float64
+	Duplicate float64
+	Reorder   float64
+	Corrupt   float64
+	Rate      string // tc rate, e.g. 10mbit
+}
+
+var tcRate = regexp.MustCompile(`^(?i)\d+(\.\d+)?([kmgt]i?)?(bit|bps)$`)
+
+func (imp Impairment) IsZero() bool {
+	return imp == Impairment{}
+}
+
+func (imp Impairment) Validate() error {
+	if imp.Delay < 0 || imp.Jitter < 0 {
+		return fmt.Errorf("negative delay")
+	}
+	if imp.Jitter > 0 && imp.Delay == 0 {
+		return fmt.Errorf("jitter requires a delay")
+	}
+	if imp.Reorder > 0 && imp.Delay == 0 {
+		return fmt.Errorf("reordering requires a delay")
+	}
+	for _, p := range []float64{imp.Loss, imp.Duplicate, imp.Reorder, imp.Corrupt} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("percentage %g out of range", p)
+		}
+	}
+	if imp.Rate != "" && !tcRate.MatchString(imp.Rate) {
+		return fmt.Errorf("bad rate %q, expected something like 10mbit", imp.Rate)
+	}
+	return nil
+}
+
+func (imp Impairment) String() string {
+	if imp.IsZero() {
+		return "none"
+	}
+	return strings.Join(imp.netemArgs(), " ")
+}
+
+// netemArgs returns the netem parameters for tc.
+func (imp Impairment) netemArgs() []string {
+	var args []string
+	if imp.Delay > 0 {
+		args = append(args, "delay", tcTime(imp.Delay))
+		if imp.Jitter > 0 {
+			args = append(args, tcTime(imp.Jitter))
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"loss", imp.Loss}, {"duplicate", imp.Duplicate}, {"reorder", imp.Reorder}, {"corrupt", imp.Corrupt}} {
+		if p.value > 0 {
+			args = append(args, p.name, strconv.FormatFloat(p.value, 'f', -1, 64)+"%")
+		}
+	}
+	if imp.Rate != "" {
+		args = append(args, "rate", imp.Rate)
+	}
+	return args
+}
+
+func tcTime(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10) + "us"
+}
+
+// parseQdiscs parses the output of `tc qdisc show dev <tap>` back into an impairment.
+// Example output:
+//
+//	qdisc netem 1: root refcnt 2 limit 1000 delay 100ms  10ms loss 1% duplicate 0.5% reorder 25% 50% corrupt 0.1%
+//	qdisc tbf 10: parent 1:1 rate 10Mbit burst 1600b lat 50ms
+func parseQdiscs(listing []byte) (Impairment, error) {
+	var imp Impairment
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "qdisc" {
+			continue
+		}
+		switch fields[1] {
+		case "netem":
+			err := imp.parseNetem(fields)
+			if err != nil {
+				return imp, err
+			}
+		case "tbf":
+			for i, f := range fields {
+				if f == "rate" && i+1 < len(fields) {
+					imp.Rate = strings.ToLower(fields[i+1])
+				}
+			}
+		}
+	}
+	return imp, nil
+}
+
+// hasNetemRoot reports whether the qdisc listing has netem as the root qdisc.
+func hasNetemRoot(listing []byte) bool {
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[0] == "qdisc" && fields[1] == "netem" && fields[3] == "root" {
+			return true
+		}
+	}
+	return false
+}
+
+func (imp *Impairment) parseNetem(fields []string) error {
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				return fields[i+1]
+			}
+			return ""
+		}
+		var err error
+		switch fields[i] {
+		case "delay":
+			imp.Delay, err = time.ParseDuration(next())
+			i++
+			// the jitter follows the delay if there is one
+			if jitter, jerr := time.ParseDuration(next()); jerr == nil {
+				imp.Jitter = jitter
+				i++
+			}
+		case "loss":
+			imp.Loss, err = parsePercent(next())
+			i++
+		case "duplicate":
+			imp.Duplicate, err = parsePercent(next())
+			i++
+		case "reorder":
+			imp.Reorder, err = parsePercent(next())
+			i++
+		case "corrupt":
+			imp.Corrupt, err = parsePercent(next())
+			i++
+		case "rate":
+			imp.Rate = strings.ToLower(next())
+			i++
+		}
+		if err != nil {
+			return fmt.Errorf("parsing netem %s: %w", fields[i-1], err)
+		}
+	}
+	return nil
+}
+
+func parsePercent(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+}
+
+// tbfBurst is the bucket size for the rate limit, enough for a jumbo frame.
+const tbfBurst = "32kbit"
+
+// setImpairment replaces the qdiscs on the tap with the impairment.
+func (m *Manager) setImpairment(name string, imp Impairment) error {
+	netem := imp
+	netem.Rate = "" // the rate limit goes into a tbf below netem
+	args := append([]string{"qdisc", "replace", "dev", name, "root", "handle", "1:", "netem"}, netem.netemArgs()...)
+	_, err := m.runPrivileged("tc", args...)
+	if err != nil {
+		return fmt.Errorf("setting netem on %s: %w", name, err)
+	}
+	if imp.Rate == "" {
+		// drop a rate limit left from an earlier impairment, if any
+		_, _ = m.runPrivileged("tc", "qdisc", "del", "dev", name, "parent", "1:1", "handle", "10:")
+		return nil
+	}
+	_, err = m.runPrivileged("tc", "qdisc", "replace", "dev", name, "parent", "1:1", "handle", "10:",
+		"tbf", "rate", imp.Rate, "burst", tbfBurst, "latency", "50ms")
+	if err != nil {
+		return fmt.Errorf("setting rate limit on %s: %w", name, err)
+	}
+	return nil
+}
+
+// clearImpairment removes the root qdisc from the tap, the kernel default comes back.
+// Without a netem root there is nothing to remove, so clearing twice is fine.
+func (m *Manager) clearImpairment(name string) error {
+	out, err := m.runPrivileged("tc", "qdisc", "show", "dev", name)
+	if err != nil {
+		return fmt.Errorf("listing qdiscs on %s: %w", name, err)
+	}
+	if !hasNetemRoot(out) {
+		return nil
+	}
+	_, err = m.runPrivileged("tc", "qdisc", "del", "dev", name, "root")
+	if err != nil {
+		return fmt.Errorf("clearing qdisc on %s: %w", name, err)
+	}
+	return nil
+}
+
+func (m *Manager) showImpairment(name string) (Impairment, error) {
+	out, err := m.runPrivileged("tc", "qdisc", "show", "dev", name)
+	if err != nil {
+		return Impairment{}, fmt.Errorf("listing qdiscs on %s: %w", name, err)
+	}
+	return parseQdiscs(out)
+}
+
+// SetImpairment applies or modifies the impairment of traffic sent to the VM on the tap.
+// The zero Impairment clears it.
+func (m *Manager) SetImpairment(name string, imp Impairment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.taps[name]; !ok {
+		return fmt.Errorf("tap device %s does not exist", name)
+	}
+	if err := imp.Validate(); err != nil {
+		return fmt.Errorf("impairment: %w", err)
+	}
+	if imp.IsZero() {
+		return m.clearImpairment(name)
+	}
+	return m.setImpairment(name, imp)
+}
+
+// GetImpairment inspects the qdiscs on the tap.
+func (m *Manager) GetImpairment(name string) (Impairment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.taps[name]; !ok {
+		return Impairment{}, fmt.Errorf("tap device %s does not exist", name)
+	}
+	return m.showImpairment(name)
+}
+
+// ClearImpairment removes any impairment from the tap.
+func (m *Manager) ClearImpairment(name string) error {
+	return m.SetImpairment(name, Impairment{})
+}
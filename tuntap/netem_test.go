@@ -0,0 +1,76 @@
+package tuntap
+
+import (
+	_ "embed"
+	"slices"
+	"testing"
+	"time"
+)
+
+//go:embed testdata/tc-qdisc-show.txt
+var tc_qdisc_show_output []byte
+
+func Test_parseQdiscs(t *testing.T) {
+	imp, err := parseQdiscs(tc_qdisc_show_output)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	want := Impairment{
+		Delay:     100 * time.Millisecond,
+		Jitter:    10 * time.Millisecond,
+		Loss:      1,
+		Duplicate: 0.5,
+		Reorder:   25,
+		Corrupt:   0.1,
+		Rate:      "10mbit",
+	}
+	if imp != want {
+		t.Errorf("expected %+v, got %+v", want, imp)
+	}
+	imp, err = parseQdiscs([]byte("qdisc fq_codel 0: root refcnt 2 limit 10240p flows 1024\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !imp.IsZero() {
+		t.Errorf("expected no impairment, got %+v", imp)
+	}
+}
+
+func TestImpairment_netemArgs(t *testing.T) {
+	imp := Impairment{Delay: 20 * time.Millisecond, Jitter: 2 * time.Millisecond, Loss: 0.5}
+	want := []string{"delay", "20000us", "2000us", "loss", "0.5%"}
+	if got := imp.netemArgs(); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestImpairment_Validate(t *testing.T) {
+	bad := []Impairment{
+		{Jitter: time.Millisecond},
+		{Reorder: 10},
+		{Loss: 101},
+		{Rate: "fast"},
+	}
+	for _, imp := range bad {
+		if imp.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", imp)
+		}
+	}
+	if err := (Impairment{Delay: time.Millisecond, Rate: "100Mbit"}).Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestManager_ClearImpairment(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "netem-clear.json")
+	m.taps["tap9"] = &tap{name: "tap9", mine: true}
+	if err := m.ClearImpairment("tap9"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	// nothing left to remove, only the listing runs
+	if err := m.ClearImpairment("tap9"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
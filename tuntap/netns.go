@@ -0,0 +1,163 @@
+package tuntap
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+)
+
+// netnsDir holds the named network namespaces of ip netns, a variable for tests.
+var netnsDir = "/run/netns"
+
+// Veth connects a namespace to the host.
+type Veth struct {
+	// Host is the end in the host namespace.
+	Host string
+	// Peer is the end in the namespace, named like the host end if empty.
+	Peer string
+	// Address is given to the host end if valid, for reaching the VMs from the host.
+	Address netip.Prefix
+	// Bridge in the namespace the peer is added to, if set.
+	Bridge string
+}
+
+// SetNamespace makes the manager do all link operations in the named network
+// namespace, through ip netns exec. That needs root or sudo, CAP_NET_ADMIN and the
+// privilege helper are not enough. Call it before Load.
+func (m *Manager) SetNamespace(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.namespace = name
+}
+
+// Namespace returns the network namespace of the manager, empty for the host namespace.
+func (m *Manager) Namespace() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.namespace
+}
+
+// NamespacePath returns the file of a named network namespace, for entering it.
+func NamespacePath(name string) string {
+	return filepath.Join(netnsDir, name)
+}
+
+// run runs the command in the namespace of the manager. A command through sudo
+// is run by sudo in the namespace.
+func (m *Manager) run(path string, args ...string) ([]byte, error) {
+	if m.namespace == "" {
+		return m.commander.Run(path, args...)
+	}
+	if path == "sudo" {
+		return m.commander.Run("sudo", append([]string{"ip", "netns", "exec", m.namespace}, args...)...)
+	}
+	return m.commander.Run("ip", append([]string{"netns", "exec", m.namespace, path}, args...)...)
+}
+
+// EnsureNamespace creates the namespace of the manager if it does not exist yet.
+// It is left in place when the VMs stop, like the bridges; ip netns del removes
+// it together with everything in it.
+func (m *Manager) EnsureNamespace() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.namespace == "" {
+		return errors.New("no namespace set")
+	}
+	_, err := os.Stat(filepath.Join(netnsDir, m.namespace))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("namespace %s: %w", m.namespace, err)
+	}
+	_, err = m.runHost("ip", "netns", "add", m.namespace)
+	if err != nil {
+		return fmt.Errorf("creating namespace %s: %w", m.namespace, err)
+	}
+	_, err = m.runPrivileged("ip", "link", "set", "dev", "lo", "up")
+	if err != nil {
+		return fmt.Errorf("setting up loopback in %s: %w", m.namespace, err)
+	}
+	return nil
+}
+
+// ConnectNamespace creates a veth pair between the host and the namespace, unless
+// the host end exists already.
+func (m *Manager) ConnectNamespace(v Veth) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.namespace == "" {
+		return errors.New("no namespace set")
+	}
+	if _, err := os.Stat(filepath.Join(sysClassNet, v.Host)); err == nil {
+		return nil
+	}
+	peer := v.Peer
+	if peer == "" {
+		peer = v.Host
+	}
+	_, err := m.runHost("ip", "link", "add", v.Host, "type", "veth", "peer", "name", peer, "netns", m.namespace)
+	if err != nil {
+		return fmt.Errorf("creating veth %s: %w", v.Host, err)
+	}
+	if v.Address.IsValid() {
+		_, err = m.runHost("ip", "addr", "add", v.Address.String(), "dev", v.Host)
+		if err != nil {
+			return fmt.Errorf("adding %s to %s: %w", v.Address, v.Host, err)
+		}
+	}
+	_, err = m.runHost("ip", "link", "set", "dev", v.Host, "up")
+	if err != nil {
+		return fmt.Errorf("setting up %s: %w", v.Host, err)
+	}
+	if v.Bridge != "" {
+		_, err = m.runPrivileged("ip", "link", "set", "dev", peer, "master", v.Bridge)
+		if err != nil {
+			return fmt.Errorf("adding %s to %s: %w", peer, v.Bridge, err)
+		}
+	}
+	_, err = m.runPrivileged("ip", "link", "set", "dev", peer, "up")
+	if err != nil {
+		return fmt.Errorf("setting up %s: %w", peer, err)
+	}
+	return nil
+}
+
+// OpenTap opens the queues of a tap created by the manager, for qemu to use with
+// -netdev tap,fd= or fds=. With a namespace the tap is moved into it once it is
+// open, as it can't be opened by name from the host after that. The files stay
+// attached to the tap.
+func (m *Manager) OpenTap(name string, queues int) ([]*os.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.taps[name]
+	if !ok || !t.mine {
+		return nil, fmt.Errorf("tap device %s was not created by us", name)
+	}
+	files, err := openTapQueues(name, queues)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", name, err)
+	}
+	if m.namespace == "" {
+		return files, nil
+	}
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+	_, err = m.runHost("ip", "link", "set", "dev", name, "netns", m.namespace)
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("moving %s to %s: %w", name, m.namespace, err)
+	}
+	_, err = m.runPrivileged("ip", "link", "set", "dev", name, "up")
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("setting link state up on %s: %w", name, err)
+	}
+	return files, nil
+}
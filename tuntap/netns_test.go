@@ -0,0 +1,52 @@
+package tuntap
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManager_Namespace(t *testing.T) {
+	t.Setenv("USER", "alice")
+	oldNetns, oldSys := netnsDir, sysClassNet
+	t.Cleanup(func() { netnsDir, sysClassNet = oldNetns, oldSys })
+	netnsDir, sysClassNet = t.TempDir(), t.TempDir()
+	m := New()
+	m.SetSudo(true)
+	m.commander = replay(t, "netns.json")
+	if err := m.EnsureNamespace(); err == nil {
+		t.Errorf("expected an error without a namespace")
+	}
+	m.SetNamespace("lab")
+	if err := m.EnsureNamespace(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// taps are created in the host namespace, OpenTap moves them
+	if err := m.CreateTap("tap9"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	v := Veth{Host: "lab0", Address: netip.MustParsePrefix("10.10.0.254/24"), Bridge: "br0"}
+	if err := m.ConnectNamespace(v); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// existing namespace and veth, nothing to run
+	if err := os.WriteFile(filepath.Join(netnsDir, "lab"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(sysClassNet, "lab0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.EnsureNamespace(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := m.ConnectNamespace(v); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := m.DeleteTaps(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if _, err := m.OpenTap("tap8", 1); err == nil {
+		t.Errorf("expected an error for a tap we did not create")
+	}
+}
@@ -0,0 +1,144 @@
+package tuntap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// invocation is a single recorded command execution.
+type invocation struct {
+	Path   string   `json:"path"`
+	Args   []string `json:"args"`
+	Output string   `json:"output,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func (i invocation) String() string {
+	return strings.Join(append([]string{i.Path}, i.Args...), " ")
+}
+
+// RecordingExecutor wraps another Executor and records every invocation,
+// together with its output, so it can be saved as testdata and replayed later.
+type RecordingExecutor struct {
+	mu          sync.Mutex
+	inner       Executor
+	invocations []invocation
+}
+
+// NewRecordingExecutor returns an Executor that runs commands through inner and records them.
+// If inner is nil the default executor is used.
+func NewRecordingExecutor(inner Executor) *RecordingExecutor {
+	if inner == nil {
+		inner = newExecutor()
+	}
+	return &RecordingExecutor{inner: inner}
+}
+
+// Record makes the manager record every command it runs from now on, through
+// the executor it uses at the moment, and returns the recording.
+func (m *Manager) Record() *RecordingExecutor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := NewRecordingExecutor(m.commander)
+	m.commander = r
+	return r
+}
+
+func (r *RecordingExecutor) Run(path string, args ...string) ([]byte, error) {
+	out, err := r.inner.Run(path, args...)
+	inv := invocation{Path: path, Args: slices.Clone(args), Output: string(out)}
+	if err != nil {
+		inv.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.invocations = append(r.invocations, inv)
+	r.mu.Unlock()
+	return out, err
+}
+
+// Save writes the recorded invocations to the given file.
+func (r *RecordingExecutor) Save(filename string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep the ip output readable in testdata
+	enc.SetIndent("", "  ")
+	err := enc.Encode(r.invocations)
+	if err != nil {
+		return fmt.Errorf("marshal recording: %w", err)
+	}
+	err = os.WriteFile(filename, buf.Bytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("write recording: %w", err)
+	}
+	return nil
+}
+
+// ReplayingExecutor serves back a recording made by RecordingExecutor.
+// Commands must arrive in the recorded order, any mismatch is an error.
+type ReplayingExecutor struct {
+	mu          sync.Mutex
+	invocations []invocation
+	pos         int
+	err         error
+}
+
+// NewReplayingExecutor loads a recording from the given file.
+func NewReplayingExecutor(filename string) (*ReplayingExecutor, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	var invocations []invocation
+	err = json.Unmarshal(data, &invocations)
+	if err != nil {
+		return nil, fmt.Errorf("parse recording %s: %w", filename, err)
+	}
+	return &ReplayingExecutor{invocations: invocations}, nil
+}
+
+func (r *ReplayingExecutor) Run(path string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := invocation{Path: path, Args: args}
+	if r.pos >= len(r.invocations) {
+		return nil, r.fail(fmt.Errorf("unexpected command %q, recording exhausted", got))
+	}
+	want := r.invocations[r.pos]
+	if want.Path != path || !slices.Equal(want.Args, args) {
+		return nil, r.fail(fmt.Errorf("command %d mismatch: got %q, want %q", r.pos, got, want))
+	}
+	r.pos++
+	if want.Error != "" {
+		return []byte(want.Output), errors.New(want.Error)
+	}
+	return []byte(want.Output), nil
+}
+
+// fail records the first mismatch so Done can report it.
+func (r *ReplayingExecutor) fail(err error) error {
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// Done returns an error if there was a mismatch or if recorded commands were never executed.
+func (r *ReplayingExecutor) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.pos != len(r.invocations) {
+		return fmt.Errorf("%d recorded commands not executed, next is %q", len(r.invocations)-r.pos, r.invocations[r.pos])
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package tuntap
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayingExecutor_roundtrip(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "load.json")
+	rec := m.Record()
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "recording.json")
+	err = rec.Save(filename)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	r, err := NewReplayingExecutor(filename)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m = New()
+	m.SetSudo(false)
+	m.commander = r
+	err = m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(m.taps) != 4 || len(m.bridges) != 2 {
+		t.Errorf("expected 4 taps and 2 bridges, got %d and %d", len(m.taps), len(m.bridges))
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestReplayingExecutor_mismatch(t *testing.T) {
+	r, err := NewReplayingExecutor("testdata/load.json")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, err = r.Run("ip", "link", "show", "type", "bridge")
+	if err == nil {
+		t.Fatalf("expected mismatch error")
+	}
+	if r.Done() == nil {
+		t.Errorf("expected Done to report the mismatch")
+	}
+}
@@ -3,10 +3,13 @@ package tuntap
 import "fmt"
 
 type tap struct {
-	name   string
-	mac    string
-	bridge *bridge
-	mine   bool // true if this tap was created by us
+	name       string
+	mac        string
+	bridge     *bridge
+	mtu        int
+	mine       bool // true if this tap was created by us
+	multiQueue bool // created with multi_queue, which deleting it has to repeat
+	mirrors    []mirror
 }
 
 func (t *tap) String() string {
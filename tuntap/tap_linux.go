@@ -0,0 +1,52 @@
+package tuntap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	iffTap        = 0x0002
+	iffNoPI       = 0x1000
+	iffVnetHdr    = 0x4000
+	iffMultiQueue = 0x0100
+	tunSetIff     = 0x400454ca
+)
+
+// openTapQueues attaches to an existing tap through /dev/net/tun, once per queue.
+func openTapQueues(name string, queues int) ([]*os.File, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return nil, fmt.Errorf("bad interface name %q", name)
+	}
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], name)
+	ifr.flags = iffTap | iffNoPI | iffVnetHdr
+	if queues > 1 {
+		ifr.flags |= iffMultiQueue
+	}
+	var files []*os.File
+	for i := 0; i < queues; i++ {
+		f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+		if err == nil {
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tunSetIff, uintptr(unsafe.Pointer(&ifr)))
+			if errno != 0 {
+				_ = f.Close()
+				err = errno
+			}
+		}
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tuntap
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openTapQueues(_ string, _ int) ([]*os.File, error) {
+	return nil, fmt.Errorf("tap devices are not supported on %s", runtime.GOOS)
+}
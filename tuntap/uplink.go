@@ -0,0 +1,276 @@
+package tuntap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// uplink is an existing interface that was enslaved to a managed bridge.
+// It holds what is needed to put the interface back the way it was.
+type uplink struct {
+	name   string
+	bridge string
+	wasUp  bool
+	addrs  []ifaceAddr // addresses moved to the bridge
+	routes []route     // routes moved to the bridge
+}
+
+// ifaceAddr is a global address of an interface.
+type ifaceAddr struct {
+	prefix string // address with prefix length
+	brd    string // ipv4 broadcast, if any
+}
+
+func (a ifaceAddr) args(verb, dev string) []string {
+	args := []string{"addr", verb, a.prefix}
+	if a.brd != "" && verb == "add" {
+		args = append(args, "brd", a.brd)
+	}
+	return append(args, "dev", dev)
+}
+
+// route is a route through an interface as printed by ip route show dev, without the dev.
+type route struct {
+	family string
+	spec   []string
+}
+
+// args replaces rather than adds the route: IPv6 routes through a gateway outlive the
+// address they were learned with, so the route may still be on the other interface.
+func (r route) args(dev string) []string {
+	args := []string{"route", "replace"}
+	if r.family == "inet6" {
+		args = []string{"-6", "route", "replace"}
+	}
+	return append(append(args, r.spec...), "dev", dev)
+}
+
+// AddUplink enslaves an existing interface, a physical NIC, vlan sub-interface or bond, to the bridge.
+// With migrate the global addresses of the interface and the routes through it are moved to the bridge.
+// It refuses to touch the interface the current SSH session is routed through unless
+// its addresses are migrated, since the host would otherwise lose the route.
+func (m *Manager) AddUplink(iface, bridge string, migrate bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bridges[bridge]; !ok {
+		return fmt.Errorf("bridge %s does not exist", bridge)
+	}
+	if _, ok := m.uplinks[iface]; ok {
+		return fmt.Errorf("%s is already an uplink", iface)
+	}
+	if _, ok := m.taps[iface]; ok {
+		return fmt.Errorf("%s is a tap device, not an uplink", iface)
+	}
+	if _, ok := m.bridges[iface]; ok {
+		return fmt.Errorf("%s is a bridge, not an uplink", iface)
+	}
+	out, err := m.runPrivileged("ip", "-o", "link", "show", "dev", iface)
+	if err != nil {
+		return fmt.Errorf("showing %s: %w", iface, err)
+	}
+	master, up := parseLinkState(out)
+	if master != "" {
+		return fmt.Errorf("%s is already enslaved to %s", iface, master)
+	}
+	dev, err := m.sshRouteDevice()
+	if err != nil {
+		return err
+	}
+	if dev == iface && !migrate {
+		return fmt.Errorf("the ssh session is routed through %s, enslaving it without migrating its addresses would cut it off", iface)
+	}
+	u := &uplink{name: iface, bridge: bridge, wasUp: up}
+	if migrate {
+		out, err = m.runPrivileged("ip", "-o", "addr", "show", "dev", iface)
+		if err != nil {
+			return fmt.Errorf("listing addresses of %s: %w", iface, err)
+		}
+		u.addrs = parseAddrs(out)
+		for _, family := range []string{"inet", "inet6"} {
+			args := []string{"route", "show", "dev", iface}
+			if family == "inet6" {
+				args = append([]string{"-6"}, args...)
+			}
+			out, err = m.runPrivileged("ip", args...)
+			if err != nil {
+				return fmt.Errorf("listing routes of %s: %w", iface, err)
+			}
+			u.routes = append(u.routes, parseRoutes(family, out)...)
+		}
+	}
+	_, err = m.runPrivileged("ip", "link", "set", iface, "master", bridge)
+	if err != nil {
+		return fmt.Errorf("enslaving %s to %s: %w", iface, bridge, err)
+	}
+	err = m.moveAddresses(u, iface, bridge)
+	if err == nil && !up {
+		err = m.setLinkState(iface, true)
+	}
+	if err != nil {
+		return errors.Join(err, m.restoreUplink(u))
+	}
+	m.uplinks[iface] = u
+	return nil
+}
+
+// RemoveUplink takes an interface out of its bridge and restores its addresses, routes and link state.
+func (m *Manager) RemoveUplink(iface string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uplinks[iface]
+	if !ok {
+		return fmt.Errorf("%s is not an uplink", iface)
+	}
+	err := m.restoreUplink(u)
+	if err != nil {
+		return err
+	}
+	delete(m.uplinks, iface)
+	return nil
+}
+
+// Uplinks returns the names of the uplinks, sorted.
+func (m *Manager) Uplinks() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.uplinks))
+	for name := range m.uplinks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// moveAddresses moves the addresses and then the routes of the uplink from one interface to another.
+// Deleting an address also removes the IPv4 routes using it, so they are added back afterwards.
+func (m *Manager) moveAddresses(u *uplink, from, to string) error {
+	for _, a := range u.addrs {
+		_, err := m.runPrivileged("ip", a.args("del", from)...)
+		if err != nil {
+			return fmt.Errorf("removing %s from %s: %w", a.prefix, from, err)
+		}
+		_, err = m.runPrivileged("ip", a.args("add", to)...)
+		if err != nil {
+			return fmt.Errorf("adding %s to %s: %w", a.prefix, to, err)
+		}
+	}
+	for _, r := range u.routes {
+		_, err := m.runPrivileged("ip", r.args(to)...)
+		if err != nil {
+			return fmt.Errorf("adding route %s to %s: %w", strings.Join(r.spec, " "), to, err)
+		}
+	}
+	return nil
+}
+
+// restoreUplink undoes AddUplink, it carries on after errors to restore as much as possible.
+func (m *Manager) restoreUplink(u *uplink) error {
+	var errs []error
+	for _, a := range u.addrs {
+		// the address may never have made it to the bridge
+		_, _ = m.runPrivileged("ip", a.args("del", u.bridge)...)
+	}
+	_, err := m.runPrivileged("ip", "link", "set", "dev", u.name, "nomaster")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("releasing %s from %s: %w", u.name, u.bridge, err))
+	}
+	for _, a := range u.addrs {
+		_, err := m.runPrivileged("ip", a.args("add", u.name)...)
+		if err != nil && !strings.Contains(err.Error(), "File exists") {
+			errs = append(errs, fmt.Errorf("restoring %s on %s: %w", a.prefix, u.name, err))
+		}
+	}
+	for _, r := range u.routes {
+		_, err := m.runPrivileged("ip", r.args(u.name)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restoring route %s on %s: %w", strings.Join(r.spec, " "), u.name, err))
+		}
+	}
+	if !u.wasUp {
+		if err := m.setLinkState(u.name, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sshRouteDevice returns the interface the client of the current SSH session is reached through,
+// or the empty string outside of an SSH session.
+func (m *Manager) sshRouteDevice() (string, error) {
+	fields := strings.Fields(os.Getenv("SSH_CONNECTION"))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	out, err := m.runPrivileged("ip", "route", "get", fields[0])
+	if err != nil {
+		return "", fmt.Errorf("finding the route of the ssh session: %w", err)
+	}
+	return fieldAfter(strings.Fields(string(out)), "dev"), nil
+}
+
+// parseLinkState returns the master and the administrative state of an interface from ip -o link show.
+// Example output:
+// 4: eth1: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc mq master br0 state UP mode DEFAULT group default qlen 1000\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+func parseLinkState(listing []byte) (master string, up bool) {
+	fields := strings.Fields(string(listing))
+	if len(fields) > 2 {
+		flags := strings.Split(strings.Trim(fields[2], "<>"), ",")
+		up = slices.Contains(flags, "UP")
+	}
+	return fieldAfter(fields, "master"), up
+}
+
+// parseAddrs returns the global addresses from ip -o addr show. Link local addresses are
+// left alone, the kernel gives every interface its own.
+// Example output:
+// 4: eth1    inet 192.0.2.2/24 brd 192.0.2.255 scope global eth1\       valid_lft forever preferred_lft forever
+func parseAddrs(listing []byte) []ifaceAddr {
+	var addrs []ifaceAddr
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+		if fieldAfter(fields, "scope") != "global" {
+			continue
+		}
+		addrs = append(addrs, ifaceAddr{prefix: fields[3], brd: fieldAfter(fields, "brd")})
+	}
+	return addrs
+}
+
+// parseRoutes returns the routes from ip route show dev that are not created by the kernel
+// for an address. Attributes that ip prints but does not accept back are dropped.
+func parseRoutes(family string, listing []byte) []route {
+	var routes []route
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fieldAfter(fields, "proto") == "kernel" {
+			continue
+		}
+		var spec []string
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "expires":
+				i++
+			case "linkdown", "dead", "offload", "trap", "rt_offload", "rt_trap":
+			default:
+				spec = append(spec, fields[i])
+			}
+		}
+		routes = append(routes, route{family: family, spec: spec})
+	}
+	return routes
+}
+
+// fieldAfter returns the field following the keyword, or the empty string.
+func fieldAfter(fields []string, keyword string) string {
+	i := slices.Index(fields, keyword)
+	if i < 0 || i+1 >= len(fields) {
+		return ""
+	}
+	return fields[i+1]
+}
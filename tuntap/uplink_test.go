@@ -0,0 +1,90 @@
+package tuntap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestManager_Uplink(t *testing.T) {
+	t.Setenv("SSH_CONNECTION", "203.0.113.9 50000 192.0.2.2 22")
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "uplink.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.AddUplink("eth1", "br0", true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(m.Uplinks(), []string{"eth1"}) {
+		t.Errorf("expected uplink eth1, got %v", m.Uplinks())
+	}
+	if err := m.AddUplink("eth1", "br1", true); err == nil {
+		t.Errorf("expected error adding eth1 twice")
+	}
+	err = m.RemoveUplink("eth1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(m.Uplinks()) != 0 {
+		t.Errorf("expected no uplinks, got %v", m.Uplinks())
+	}
+}
+
+func TestManager_AddUplink_sshRoute(t *testing.T) {
+	t.Setenv("SSH_CONNECTION", "203.0.113.9 50000 198.51.100.7 22")
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "uplink-ssh.json") // the ssh client is routed through eth1
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.AddUplink("eth1", "br0", false)
+	if err == nil || !strings.Contains(err.Error(), "ssh session") {
+		t.Errorf("expected the ssh session to be protected, got %v", err)
+	}
+	if len(m.Uplinks()) != 0 {
+		t.Errorf("expected no uplinks, got %v", m.Uplinks())
+	}
+}
+
+func Test_parseLinkState(t *testing.T) {
+	master, up := parseLinkState(link_show_eth1)
+	if master != "" || !up {
+		t.Errorf("expected eth1 up without master, got %q %v", master, up)
+	}
+	master, up = parseLinkState([]byte("6: eth2: <BROADCAST,MULTICAST> mtu 1500 qdisc noop master br1 state DOWN mode DEFAULT"))
+	if master != "br1" || up {
+		t.Errorf("expected eth2 down on br1, got %q %v", master, up)
+	}
+}
+
+func Test_parseAddrs(t *testing.T) {
+	addrs := parseAddrs(addr_show_eth1)
+	want := []ifaceAddr{
+		{prefix: "198.51.100.7/24", brd: "198.51.100.255"},
+		{prefix: "2001:db8:10::7/64"},
+	}
+	if !slices.Equal(addrs, want) {
+		t.Errorf("expected %v, got %v", want, addrs)
+	}
+}
+
+func Test_parseRoutes(t *testing.T) {
+	routes := parseRoutes("inet6", route6_show_eth1)
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(routes))
+	}
+	want := "2001:db8:99::/48 via 2001:db8:10::1 proto ra metric 1024 pref medium"
+	if got := strings.Join(routes[0].spec, " "); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	routes = parseRoutes("inet", route_show_eth1)
+	if len(routes) != 2 {
+		t.Errorf("expected 2 routes, got %d", len(routes))
+	}
+}
@@ -0,0 +1,33 @@
+package tuntap
+
+import (
+	"fmt"
+	"os"
+)
+
+// VhostNetDevice is the character device qemu opens for vhost=on.
+const VhostNetDevice = "/dev/vhost-net"
+
+// VhostNetAvailable returns nil if the current user can open the vhost-net device.
+// Without it qemu refuses to start a tap netdev with vhost=on.
+func VhostNetAvailable() error {
+	f, err := os.OpenFile(VhostNetDevice, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("vhost-net not usable: %w", err)
+	}
+	return f.Close()
+}
+
+// TapQueues returns the number of queues to give a tap for the given vCPU count.
+// The kernel limits a multi-queue tap to 256 queues.
+func TapQueues(cpus int) int {
+	switch {
+	case cpus < 1:
+		return 1
+	case cpus > maxTapQueues:
+		return maxTapQueues
+	}
+	return cpus
+}
+
+const maxTapQueues = 256
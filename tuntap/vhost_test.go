@@ -0,0 +1,41 @@
+package tuntap
+
+import "testing"
+
+func TestManager_CreateMultiQueueTap(t *testing.T) {
+	t.Setenv("USER", "alice")
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "multiqueue.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.CreateMultiQueueTap("tap9")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !m.taps["tap9"].mine {
+		t.Errorf("expected tap9 to be ours")
+	}
+	err = m.DeleteTaps()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestTapQueues(t *testing.T) {
+	tests := []struct {
+		cpus, want int
+	}{
+		{0, 1},
+		{1, 1},
+		{4, 4},
+		{1024, 256},
+	}
+	for _, tt := range tests {
+		if got := TapQueues(tt.cpus); got != tt.want {
+			t.Errorf("expected %d queues for %d cpus, got %d", tt.want, tt.cpus, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package tuntap
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PortVLANs is the VLAN membership of a port on a bridge with vlan_filtering.
+// The zero value leaves the port in the bridge's default VLAN.
+type PortVLANs struct {
+	Access uint16   // untagged VLAN of an access port
+	Trunk  []uint16 // tagged VLANs of a trunk port
+	Native uint16   // untagged VLAN of a trunk port, 0 for none
+}
+
+func (v PortVLANs) IsZero() bool {
+	return v.Access == 0 && len(v.Trunk) == 0 && v.Native == 0
+}
+
+func (v PortVLANs) Validate() error {
+	if v.Access != 0 && (len(v.Trunk) > 0 || v.Native != 0) {
+		return fmt.Errorf("a port is either an access port or a trunk")
+	}
+	if v.Native != 0 && len(v.Trunk) == 0 {
+		return fmt.Errorf("native vlan requires a trunk")
+	}
+	for _, vid := range append(slices.Clone(v.Trunk), v.Access, v.Native) {
+		if vid > 4094 {
+			return fmt.Errorf("vlan %d out of range", vid)
+		}
+	}
+	if slices.Contains(v.Trunk, 0) {
+		return fmt.Errorf("vlan 0 cannot be carried on a trunk")
+	}
+	return nil
+}
+
+func (v PortVLANs) String() string {
+	switch {
+	case v.Access != 0:
+		return fmt.Sprintf("access %d", v.Access)
+	case len(v.Trunk) > 0:
+		s := "trunk"
+		for _, vid := range v.Trunk {
+			s += fmt.Sprintf(" %d", vid)
+		}
+		if v.Native != 0 {
+			s += fmt.Sprintf(" native %d", v.Native)
+		}
+		return s
+	default:
+		return "default"
+	}
+}
+
+// vlanEntry is a line of `bridge vlan show`.
+type vlanEntry struct {
+	vid      uint16
+	pvid     bool
+	untagged bool
+}
+
+// parseBridgeVlans parses the output of `bridge vlan show` into the entries per port.
+// Example output:
+//
+//	port              vlan-id
+//	br0               1 PVID Egress Untagged
+//	tap1              10
+//	                  20
+//	                  30 PVID Egress Untagged
+func parseBridgeVlans(listing []byte) map[string][]vlanEntry {
+	ports := make(map[string][]vlanEntry)
+	var current string
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			if fields[0] == "port" {
+				continue // header
+			}
+			current = fields[0]
+			fields = fields[1:]
+			if len(fields) == 0 {
+				continue
+			}
+		}
+		if current == "" {
+			continue
+		}
+		first, last, ok := parseVidRange(fields[0])
+		if !ok {
+			continue
+		}
+		flags := strings.Join(fields[1:], " ")
+		for vid := first; vid <= last; vid++ {
+			ports[current] = append(ports[current], vlanEntry{
+				vid:      vid,
+				pvid:     strings.Contains(flags, "PVID"),
+				untagged: strings.Contains(flags, "Untagged"),
+			})
+		}
+	}
+	return ports
+}
+
+// parseVidRange parses "10" or "10-20".
+func parseVidRange(s string) (uint16, uint16, bool) {
+	from, to, isRange := strings.Cut(s, "-")
+	first, err := strconv.ParseUint(from, 10, 12)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return uint16(first), uint16(first), true
+	}
+	last, err := strconv.ParseUint(to, 10, 12)
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return uint16(first), uint16(last), true
+}
+
+// portVLANsFromEntries turns the entries of a port into its access or trunk configuration.
+func portVLANsFromEntries(entries []vlanEntry) PortVLANs {
+	var v PortVLANs
+	var native uint16
+	for _, e := range entries {
+		if e.pvid && e.untagged {
+			native = e.vid
+			continue
+		}
+		v.Trunk = append(v.Trunk, e.vid)
+	}
+	if len(v.Trunk) == 0 {
+		v.Access = native
+		return v
+	}
+	v.Native = native
+	return v
+}
+
+// bridgeVlanCommands returns the bridge(8) arguments that give a port the VLAN configuration.
+// The default VLAN 1 is removed first, so the port carries only what was asked for.
+func bridgeVlanCommands(port string, v PortVLANs) [][]string {
+	cmds := [][]string{{"vlan", "del", "dev", port, "vid", "1"}}
+	if v.Access != 0 {
+		return append(cmds, []string{"vlan", "add", "dev", port, "vid", fmt.Sprint(v.Access), "pvid", "untagged"})
+	}
+	for _, vid := range v.Trunk {
+		cmds = append(cmds, []string{"vlan", "add", "dev", port, "vid", fmt.Sprint(vid)})
+	}
+	if v.Native != 0 {
+		cmds = append(cmds, []string{"vlan", "add", "dev", port, "vid", fmt.Sprint(v.Native), "pvid", "untagged"})
+	}
+	return cmds
+}
@@ -0,0 +1,135 @@
+package tuntap
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_parseBridgeVlans(t *testing.T) {
+	ports := parseBridgeVlans(bridge_vlan_show_output)
+	if len(ports) != 3 {
+		t.Fatalf("expected 3 ports, got %d", len(ports))
+	}
+	tap3 := portVLANsFromEntries(ports["tap3"])
+	if !slices.Equal(tap3.Trunk, []uint16{10, 20, 21, 22}) || tap3.Native != 30 {
+		t.Errorf("expected trunk 10 20 21 22 native 30, got %s", tap3)
+	}
+	tap2 := portVLANsFromEntries(ports["tap2"])
+	if tap2.Access != 10 {
+		t.Errorf("expected access 10, got %s", tap2)
+	}
+}
+
+func TestPortVLANs_Validate(t *testing.T) {
+	bad := []PortVLANs{
+		{Access: 10, Trunk: []uint16{20}},
+		{Native: 10},
+		{Access: 5000},
+		{Trunk: []uint16{4095}},
+		{Trunk: []uint16{0, 10}},
+	}
+	for _, v := range bad {
+		if v.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", v)
+		}
+	}
+	if err := (PortVLANs{Trunk: []uint16{10, 20}, Native: 1}).Validate(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestManager_Load_vlans(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "load.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if m.bridges["br0"].vlanFiltering || !m.bridges["br1"].vlanFiltering {
+		t.Errorf("expected vlan filtering on br1 only")
+	}
+	v, err := m.GetVLANs("tap2")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if v.Access != 10 {
+		t.Errorf("expected tap2 to be access port in vlan 10, got %s", v)
+	}
+	_, err = m.GetVLANs("tap0")
+	if err == nil {
+		t.Errorf("expected error for tap on bridge without vlan filtering")
+	}
+}
+
+func TestManager_EnableVLANFiltering(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "vlan-filtering.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	err = m.EnableVLANFiltering("br0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the ports already on the bridge end up in the default vlan
+	v, err := m.GetVLANs("tap0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if v.Access != 1 {
+		t.Errorf("expected tap0 to be access port in vlan 1, got %s", v)
+	}
+	// br1 filters already, so nothing is run
+	err = m.EnableVLANFiltering("br1")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestManager_AddTapToBridge_vlans(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "add-tap-vlans.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m.taps["tap9"] = &tap{name: "tap9", mac: "00:16:3e:00:00:09", mine: true}
+	err = m.AddTapToBridge("tap9", "br0", PortVLANs{Access: 10})
+	if err == nil {
+		t.Fatalf("expected error adding vlans on a bridge without vlan filtering")
+	}
+	want := PortVLANs{Trunk: []uint16{10, 20}, Native: 30}
+	err = m.AddTapToBridge("tap9", "br1", want)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err := m.GetVLANs("tap9")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(got.Trunk, want.Trunk) || got.Native != want.Native {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestManager_AddTapToBridge_vlanError(t *testing.T) {
+	m := New()
+	m.SetSudo(false)
+	m.commander = replay(t, "add-tap-vlans-fail.json")
+	err := m.Load()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	m.taps["tap9"] = &tap{name: "tap9", mac: "00:16:3e:00:00:09", mine: true}
+	err = m.AddTapToBridge("tap9", "br1", PortVLANs{Trunk: []uint16{10, 20}, Native: 30})
+	if err == nil {
+		t.Fatalf("expected error setting the vlans")
+	}
+	if m.taps["tap9"].bridge != nil || slices.ContainsFunc(m.bridges["br1"].ifaces, func(t *tap) bool { return t.name == "tap9" }) {
+		t.Errorf("expected tap9 to be off br1")
+	}
+}
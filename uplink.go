@@ -0,0 +1,38 @@
+package main
+
+import "fmt"
+
+// uplinkConfig enslaves an existing host interface to the bridge of the VM.
+type uplinkConfig struct {
+	// Interface is the host interface, a physical NIC, vlan sub-interface or bond.
+	Interface string `json:"interface"`
+	// Bridge is the managed bridge, the one given with -bridge if empty.
+	Bridge string `json:"bridge,omitempty"`
+	// MigrateAddress moves the addresses and routes of the interface to the bridge.
+	MigrateAddress bool `json:"migrate_address,omitempty"`
+}
+
+// applyUplinks enslaves the uplinks from the config, they are restored on teardown.
+func (r *Runner) applyUplinks() error {
+	if len(r.config.Uplinks) == 0 {
+		return nil
+	}
+	if r.backend != "tap" {
+		return fmt.Errorf("uplinks need tap networking, network is %s", r.backend)
+	}
+	for _, c := range r.config.Uplinks {
+		bridge := c.Bridge
+		if bridge == "" {
+			bridge = r.bridge
+		}
+		err := r.tt.AddUplink(c.Interface, bridge, c.MigrateAddress)
+		if err != nil {
+			return fmt.Errorf("uplink %s: %w", c.Interface, err)
+		}
+		fmt.Printf("Uplink %s enslaved to %s\n", c.Interface, bridge)
+		r.addCleanup(func() error {
+			return r.tt.RemoveUplink(c.Interface)
+		})
+	}
+	return nil
+}
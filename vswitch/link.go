@@ -0,0 +1,153 @@
+package vswitch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+)
+
+// maxFrame is large enough for jumbo frames.
+const maxFrame = 65536
+
+// link carries frames between the switch and one qemu instance.
+type link interface {
+	readFrame() ([]byte, error)
+	writeFrame([]byte) error
+	Close() error
+}
+
+// streamLink speaks the qemu -netdev stream protocol: each frame is
+// preceded by its length as a 32-bit big endian integer.
+type streamLink struct {
+	conn net.Conn
+}
+
+func (l *streamLink) readFrame() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(l.conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxFrame {
+		return nil, fmt.Errorf("frame too large (%d bytes)", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(l.conn, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (l *streamLink) writeFrame(frame []byte) error {
+	buf := make([]byte, 4, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	_, err := l.conn.Write(append(buf, frame...))
+	return err
+}
+
+func (l *streamLink) Close() error {
+	return l.conn.Close()
+}
+
+// dgramLink speaks the qemu -netdev dgram protocol, one frame per datagram.
+// Frames go back to whoever sent the last one.
+type dgramLink struct {
+	conn   *net.UnixConn
+	mu     sync.Mutex
+	remote *net.UnixAddr
+}
+
+func (l *dgramLink) readFrame() ([]byte, error) {
+	buf := make([]byte, maxFrame)
+	n, addr, err := l.conn.ReadFromUnix(buf)
+	if err != nil {
+		return nil, err
+	}
+	if addr != nil && addr.Name != "" {
+		l.mu.Lock()
+		l.remote = addr
+		l.mu.Unlock()
+	}
+	return buf[:n], nil
+}
+
+func (l *dgramLink) writeFrame(frame []byte) error {
+	l.mu.Lock()
+	remote := l.remote
+	l.mu.Unlock()
+	if remote == nil {
+		// nobody has talked to us yet
+		return nil
+	}
+	_, err := l.conn.WriteToUnix(frame, remote)
+	return err
+}
+
+func (l *dgramLink) Close() error {
+	return l.conn.Close()
+}
+
+// ListenStream makes the port available on a unix stream socket at path, for
+// qemu -netdev stream,server=off,addr.type=unix,addr.path=<path>. One VM can be
+// connected at a time, when it disconnects the port waits for the next one.
+func (s *Switch) ListenStream(ctx context.Context, cfg PortConfig, path string) error {
+	_ = os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("port %s: listen: %w", cfg.Name, err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+		_ = os.Remove(path)
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("vswitch: port %s: accept: %v", cfg.Name, err)
+				}
+				return
+			}
+			p, err := s.addPort(cfg, &streamLink{conn: conn})
+			if err != nil {
+				log.Printf("vswitch: %v", err)
+				_ = conn.Close()
+				continue
+			}
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			s.run(p)
+			stop()
+		}
+	}()
+	return nil
+}
+
+// ListenDgram makes the port available on a unix datagram socket at path, for
+// qemu -netdev dgram,local.type=unix,local.path=<vm socket>,remote.type=unix,remote.path=<path>.
+func (s *Switch) ListenDgram(ctx context.Context, cfg PortConfig, path string) error {
+	_ = os.Remove(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("port %s: listen: %w", cfg.Name, err)
+	}
+	p, err := s.addPort(cfg, &dgramLink{conn: conn})
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+		_ = os.Remove(path)
+	}()
+	go s.run(p)
+	return nil
+}
@@ -0,0 +1,255 @@
+// Package vswitch is a userspace Ethernet switch for connecting qemu instances
+// without taps or bridges. Each port is a unix socket that qemu connects to
+// with -netdev stream or -netdev dgram.
+package vswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	etherTypeVLAN = 0x8100
+	defaultVLAN   = 1
+	fdbMaxAge     = 5 * time.Minute
+	queueLength   = 256
+)
+
+// PortConfig describes a switch port.
+type PortConfig struct {
+	Name string `json:"name"`
+	// Segment isolates ports from each other, frames never cross segments.
+	Segment string `json:"segment,omitempty"`
+	// VLAN is the access VLAN, untagged frames on the port belong to it. Defaults to 1.
+	VLAN uint16 `json:"vlan,omitempty"`
+	// Trunk makes the port carry the listed VLANs tagged instead.
+	Trunk []uint16 `json:"trunk,omitempty"`
+	// Native is the VLAN for untagged frames on a trunk port, 0 drops them.
+	Native uint16 `json:"native,omitempty"`
+}
+
+// Frame is a frame as it passes through the switch, without any VLAN tag.
+type Frame struct {
+	Port    string
+	Segment string
+	VLAN    uint16
+	Data    []byte
+}
+
+func (f Frame) String() string {
+	if len(f.Data) < 14 {
+		return fmt.Sprintf("%s: runt frame (%d bytes)", f.Port, len(f.Data))
+	}
+	return fmt.Sprintf("%s vlan %d: %s > %s type 0x%04x len %d", f.Port, f.VLAN,
+		mac(f.Data[6:12]), mac(f.Data[0:6]), binary.BigEndian.Uint16(f.Data[12:14]), len(f.Data))
+}
+
+type mac [6]byte
+
+func (m mac) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+func (m mac) multicast() bool {
+	return m[0]&1 != 0
+}
+
+type fdbKey struct {
+	segment string
+	vlan    uint16
+	mac     mac
+}
+
+type fdbEntry struct {
+	port *port
+	seen time.Time
+}
+
+// Switch forwards frames between its ports, learning where MAC addresses live.
+type Switch struct {
+	mu    sync.Mutex
+	ports map[string]*port
+	fdb   map[fdbKey]fdbEntry
+	// Observer, if set, is called with every frame the switch accepts.
+	Observer func(Frame)
+	now      func() time.Time
+}
+
+type port struct {
+	PortConfig
+	out  chan []byte
+	link link
+}
+
+// New returns a switch without ports.
+func New() *Switch {
+	return &Switch{
+		ports: make(map[string]*port),
+		fdb:   make(map[fdbKey]fdbEntry),
+		now:   time.Now,
+	}
+}
+
+// carries returns true if the port is a member of the VLAN.
+func (p *port) carries(vlan uint16) bool {
+	if len(p.Trunk) > 0 {
+		return slices.Contains(p.Trunk, vlan) || vlan == p.Native
+	}
+	return vlan == p.accessVLAN()
+}
+
+func (p *port) accessVLAN() uint16 {
+	if p.VLAN == 0 {
+		return defaultVLAN
+	}
+	return p.VLAN
+}
+
+// classify figures out which VLAN an incoming frame belongs to and strips the tag.
+func (p *port) classify(frame []byte) (uint16, []byte, bool) {
+	tagged := binary.BigEndian.Uint16(frame[12:14]) == etherTypeVLAN
+	if len(p.Trunk) == 0 {
+		// access ports only take untagged frames
+		return p.accessVLAN(), frame, !tagged
+	}
+	if !tagged {
+		return p.Native, frame, p.Native != 0
+	}
+	if len(frame) < 18 {
+		return 0, nil, false
+	}
+	vlan := binary.BigEndian.Uint16(frame[14:16]) & 0x0fff
+	untagged := make([]byte, 0, len(frame)-4)
+	untagged = append(untagged, frame[:12]...)
+	untagged = append(untagged, frame[16:]...)
+	return vlan, untagged, slices.Contains(p.Trunk, vlan)
+}
+
+// egress returns the frame as it should be sent out on the port.
+func (p *port) egress(vlan uint16, frame []byte) []byte {
+	if len(p.Trunk) == 0 || vlan == p.Native {
+		return frame
+	}
+	tagged := make([]byte, 0, len(frame)+4)
+	tagged = append(tagged, frame[:12]...)
+	tagged = binary.BigEndian.AppendUint16(tagged, etherTypeVLAN)
+	tagged = binary.BigEndian.AppendUint16(tagged, vlan)
+	tagged = append(tagged, frame[12:]...)
+	return tagged
+}
+
+// input handles a frame received on a port.
+func (s *Switch) input(in *port, frame []byte) {
+	if len(frame) < 14 {
+		return
+	}
+	vlan, frame, ok := in.classify(frame)
+	if !ok {
+		return
+	}
+	var dst, src mac
+	copy(dst[:], frame[0:6])
+	copy(src[:], frame[6:12])
+	now := s.now()
+
+	s.mu.Lock()
+	if !src.multicast() {
+		s.fdb[fdbKey{in.Segment, vlan, src}] = fdbEntry{port: in, seen: now}
+	}
+	var outs []*port
+	entry, known := s.fdb[fdbKey{in.Segment, vlan, dst}]
+	if known && !dst.multicast() && now.Sub(entry.seen) < fdbMaxAge {
+		if entry.port != in {
+			outs = append(outs, entry.port)
+		}
+	} else {
+		for _, p := range s.ports {
+			if p != in && p.Segment == in.Segment && p.carries(vlan) {
+				outs = append(outs, p)
+			}
+		}
+	}
+	// sending happens under the lock so a port can't go away meanwhile,
+	// it never blocks as full queues drop the frame.
+	for _, p := range outs {
+		select {
+		case p.out <- p.egress(vlan, frame):
+		default:
+			// the port is not keeping up, drop like a real switch would
+		}
+	}
+	observer := s.Observer
+	s.mu.Unlock()
+
+	if observer != nil {
+		observer(Frame{Port: in.Name, Segment: in.Segment, VLAN: vlan, Data: frame})
+	}
+}
+
+// addPort adds a port that is reachable through the given link.
+func (s *Switch) addPort(cfg PortConfig, l link) (*port, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ports[cfg.Name]; ok {
+		return nil, fmt.Errorf("port %s already exists", cfg.Name)
+	}
+	if len(cfg.Trunk) == 0 && cfg.Native != 0 {
+		return nil, fmt.Errorf("port %s: native vlan is only valid on trunk ports", cfg.Name)
+	}
+	p := &port{PortConfig: cfg, out: make(chan []byte, queueLength), link: l}
+	s.ports[cfg.Name] = p
+	return p, nil
+}
+
+// removePort takes the port out of the switch and forgets the addresses learned on it.
+func (s *Switch) removePort(p *port) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ports, p.Name)
+	for k, e := range s.fdb {
+		if e.port == p {
+			delete(s.fdb, k)
+		}
+	}
+	close(p.out)
+}
+
+// run moves frames between the link and the switch until the link fails.
+func (s *Switch) run(p *port) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for frame := range p.out {
+			if err := p.link.writeFrame(frame); err != nil {
+				log.Printf("vswitch: port %s: write: %v", p.Name, err)
+			}
+		}
+	}()
+	for {
+		frame, err := p.link.readFrame()
+		if err != nil {
+			log.Printf("vswitch: port %s: %v", p.Name, err)
+			break
+		}
+		s.input(p, frame)
+	}
+	_ = p.link.Close()
+	s.removePort(p)
+	<-done
+}
+
+// Ports returns the names of the ports on the switch.
+func (s *Switch) Ports() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.ports))
+	for name := range s.ports {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,161 @@
+package vswitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func frame(dst, src byte, payload string) []byte {
+	f := []byte{0x52, 0x54, 0, 0, 0, dst, 0x52, 0x54, 0, 0, 0, src, 0x08, 0x00}
+	return append(f, payload...)
+}
+
+var broadcast = frame(0xff, 1, "hello")
+
+func init() {
+	copy(broadcast[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+func addTestPort(t *testing.T, s *Switch, cfg PortConfig) *port {
+	t.Helper()
+	p, err := s.addPort(cfg, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return p
+}
+
+// received drains the frames queued for the port.
+func received(p *port) [][]byte {
+	var frames [][]byte
+	for {
+		select {
+		case f := <-p.out:
+			frames = append(frames, f)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestSwitch_learning(t *testing.T) {
+	s := New()
+	a := addTestPort(t, s, PortConfig{Name: "a"})
+	b := addTestPort(t, s, PortConfig{Name: "b"})
+	c := addTestPort(t, s, PortConfig{Name: "c"})
+	// unknown destination is flooded
+	s.input(a, frame(2, 1, "to b"))
+	if len(received(b)) != 1 || len(received(c)) != 1 || len(received(a)) != 0 {
+		t.Fatalf("expected frame to be flooded to b and c")
+	}
+	// b answers, a has been learned so only a gets it
+	s.input(b, frame(1, 2, "to a"))
+	if len(received(a)) != 1 || len(received(c)) != 0 {
+		t.Fatalf("expected reply to go to a only")
+	}
+	// now b is known as well
+	s.input(a, frame(2, 1, "to b again"))
+	if len(received(b)) != 1 || len(received(c)) != 0 {
+		t.Fatalf("expected frame to go to b only")
+	}
+}
+
+func TestSwitch_segments(t *testing.T) {
+	s := New()
+	a := addTestPort(t, s, PortConfig{Name: "a", Segment: "one"})
+	b := addTestPort(t, s, PortConfig{Name: "b", Segment: "one"})
+	c := addTestPort(t, s, PortConfig{Name: "c", Segment: "two"})
+	s.input(a, broadcast)
+	if len(received(b)) != 1 {
+		t.Errorf("expected b to get the broadcast")
+	}
+	if len(received(c)) != 0 {
+		t.Errorf("expected c in another segment to be isolated")
+	}
+}
+
+func TestSwitch_vlans(t *testing.T) {
+	s := New()
+	var observed []Frame
+	s.Observer = func(f Frame) { observed = append(observed, f) }
+	a := addTestPort(t, s, PortConfig{Name: "a", VLAN: 10})
+	c := addTestPort(t, s, PortConfig{Name: "c", VLAN: 20})
+	trunk := addTestPort(t, s, PortConfig{Name: "trunk", Trunk: []uint16{10, 20}})
+
+	s.input(a, broadcast)
+	if len(received(c)) != 0 {
+		t.Errorf("expected vlan 20 port to be isolated from vlan 10")
+	}
+	got := received(trunk)
+	if len(got) != 1 {
+		t.Fatalf("expected trunk to get one frame, got %d", len(got))
+	}
+	if binary.BigEndian.Uint16(got[0][12:14]) != etherTypeVLAN || binary.BigEndian.Uint16(got[0][14:16]) != 10 {
+		t.Errorf("expected frame tagged with vlan 10, got % x", got[0][:16])
+	}
+	// tagged frame for vlan 20 from the trunk reaches c untagged
+	s.input(trunk, trunk.egress(20, frame(0xff, 3, "vlan 20")))
+	got = received(c)
+	if len(got) != 1 || !bytes.Equal(got[0], frame(0xff, 3, "vlan 20")) {
+		t.Errorf("expected untagged frame on c, got %v", got)
+	}
+	if len(received(a)) != 0 {
+		t.Errorf("expected vlan 10 port to be isolated from vlan 20")
+	}
+	// tagged frames on access ports and untagged frames on trunks without native vlan are dropped
+	s.input(a, trunk.egress(10, broadcast))
+	s.input(trunk, broadcast)
+	if len(received(trunk))+len(received(a))+len(received(c)) != 0 {
+		t.Errorf("expected frames to be dropped")
+	}
+	if len(observed) != 2 || observed[0].VLAN != 10 || observed[1].VLAN != 20 {
+		t.Errorf("expected two observed frames on vlan 10 and 20, got %v", observed)
+	}
+}
+
+func TestSwitch_ListenStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New()
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		err := s.ListenStream(ctx, PortConfig{Name: name}, filepath.Join(dir, name+".sock"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	a, err := net.Dial("unix", filepath.Join(dir, "a.sock"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer a.Close()
+	b, err := net.Dial("unix", filepath.Join(dir, "b.sock"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer b.Close()
+	// wait for both ports to be connected
+	for i := 0; len(s.Ports()) != 2; i++ {
+		if i == 100 {
+			t.Fatalf("ports did not connect, have %v", s.Ports())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	la, lb := &streamLink{conn: a}, &streamLink{conn: b}
+	if err := la.writeFrame(broadcast); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_ = b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := lb.readFrame()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !bytes.Equal(got, broadcast) {
+		t.Errorf("expected broadcast frame, got % x", got)
+	}
+}
@@ -0,0 +1,171 @@
+// Package ztp serves day-0 configurations to routers doing zero touch provisioning.
+// A router DHCPs, gets the location of its configuration in options 66 and 67,
+// and fetches it over TFTP or HTTP.
+package ztp
+
+import (
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fetch records a router fetching, or failing to fetch, a file.
+type Fetch struct {
+	Time     time.Time
+	Protocol string // tftp or http
+	Client   netip.Addr
+	VM       string
+	File     string
+	Bytes    int64
+	Err      error
+}
+
+func (f Fetch) String() string {
+	vm := f.VM
+	if vm == "" {
+		vm = "-"
+	}
+	status := "ok"
+	if f.Err != nil {
+		status = f.Err.Error()
+	}
+	return fmt.Sprintf("%s %s %s %s %d bytes: %s", f.Protocol, f.Client, vm, f.File, f.Bytes, status)
+}
+
+// Server looks up the configurations in a directory. A configuration is a file named
+// after the serial number, the MAC address (lower case, colon separated) or the VM name
+// of a router, optionally with an extension: 52:54:00:12:34:56.cfg or r1.py.
+type Server struct {
+	Dir string
+	// Boot is tftp to advertise a file name with the server in option 66, or
+	// http to advertise a URL in option 67.
+	Boot string
+	// ServerIP and HTTPPort make up the advertised location.
+	ServerIP netip.Addr
+	HTTPPort int
+	// VMByIP returns the VM that has the address, for the fetch log. It may be nil.
+	VMByIP func(ip netip.Addr) string
+	// OnFetch is called for every fetch. It may be nil.
+	OnFetch func(Fetch)
+
+	mu      sync.Mutex
+	offered map[netip.Addr]string // the file offered to each lease address
+}
+
+// safeKey also keeps glob characters out of the lookup.
+var safeKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// Lookup returns the name of the configuration file of the first key that has one.
+func (s *Server) Lookup(keys ...string) (string, bool) {
+	for _, key := range keys {
+		if !safeKey.MatchString(key) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.Dir, key)); err == nil {
+			return key, true
+		}
+		matches, _ := filepath.Glob(filepath.Join(s.Dir, key+".*"))
+		if len(matches) > 0 {
+			return filepath.Base(matches[0]), true
+		}
+	}
+	return "", false
+}
+
+// Options returns the DHCP options pointing the client to its configuration,
+// for use as dhcp.Server.Options.
+func (s *Server) Options(req *dhcp.Message, l dhcp.Lease) map[byte][]byte {
+	file, ok := s.Lookup(Serial(req), strings.ToLower(l.MAC), l.VM)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	if s.offered == nil {
+		s.offered = make(map[netip.Addr]string)
+	}
+	s.offered[l.IP] = file
+	s.mu.Unlock()
+	if s.Boot == "http" {
+		url := fmt.Sprintf("http://%s/%s", net.JoinHostPort(s.ServerIP.String(), fmt.Sprint(s.HTTPPort)), file)
+		return map[byte][]byte{dhcp.OptBootfile: []byte(url)}
+	}
+	return map[byte][]byte{
+		dhcp.OptTFTPServer: []byte(s.ServerIP.String()),
+		dhcp.OptBootfile:   []byte(file),
+	}
+}
+
+// Serial returns the serial number a router sends as client identifier, option 61
+// with type 0, or the empty string.
+func Serial(req *dhcp.Message) string {
+	id := req.Options[dhcp.OptClientID]
+	if len(id) < 2 || id[0] != 0 {
+		return ""
+	}
+	return string(id[1:])
+}
+
+// Open opens a configuration file for the TFTP server. A client only gets the file
+// offered to it by Options, so routers can't read each other's configurations.
+func (s *Server) Open(client netip.Addr, name string) (io.ReadCloser, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || strings.ContainsAny(name, `/\`) || name != path.Clean(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("bad file name %q", name)
+	}
+	s.mu.Lock()
+	offered := s.offered[client]
+	s.mu.Unlock()
+	if name != offered {
+		return nil, fmt.Errorf("%s was not offered %s: %w", client, name, fs.ErrNotExist)
+	}
+	return os.Open(filepath.Join(s.Dir, name))
+}
+
+// TFTPDone logs a finished TFTP transfer.
+func (s *Server) TFTPDone(client netip.Addr, file string, n int64, err error) {
+	s.log(Fetch{Protocol: "tftp", Client: client, File: file, Bytes: n, Err: err})
+}
+
+// ServeHTTP serves the configuration files over HTTP.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f := Fetch{Protocol: "http", File: strings.TrimPrefix(r.URL.Path, "/")}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		f.Client = ap.Addr().Unmap()
+	}
+	defer func() { s.log(f) }()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		f.Err = fmt.Errorf("method %s", r.Method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file, err := s.Open(f.Client, f.File)
+	if err != nil {
+		f.Err = err
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	f.Bytes, f.Err = io.Copy(w, file)
+}
+
+func (s *Server) log(f Fetch) {
+	f.Time = time.Now()
+	if s.VMByIP != nil && f.Client.IsValid() {
+		f.VM = s.VMByIP(f.Client)
+	}
+	if s.OnFetch != nil {
+		s.OnFetch(f)
+	}
+}
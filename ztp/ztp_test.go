@@ -0,0 +1,91 @@
+package ztp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/perbu/qemu-wrapper/dhcp"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"FDO1234X":              "hostname by-serial\n",
+		"52:54:00:12:34:56.cfg": "hostname by-mac\n",
+		"r1.py":                 "print('r1')\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Server{Dir: dir, ServerIP: netip.MustParseAddr("10.10.0.1"), HTTPPort: 8080}
+}
+
+func TestServer_Lookup(t *testing.T) {
+	s := testServer(t)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"FDO1234X", "52:54:00:12:34:56", "r1"}, "FDO1234X"},
+		{[]string{"", "52:54:00:12:34:56", "r1"}, "52:54:00:12:34:56.cfg"},
+		{[]string{"", "52:54:00:00:00:01", "r1"}, "r1.py"},
+		{[]string{"*", "../r1"}, ""},
+	}
+	for _, tt := range tests {
+		got, _ := s.Lookup(tt.keys...)
+		if got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.keys, tt.want, got)
+		}
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	s := testServer(t)
+	req := &dhcp.Message{Options: map[byte][]byte{dhcp.OptClientID: append([]byte{0}, "FDO1234X"...)}}
+	opts := s.Options(req, dhcp.Lease{MAC: "52:54:00:12:34:56", VM: "r1"})
+	if string(opts[dhcp.OptTFTPServer]) != "10.10.0.1" || string(opts[dhcp.OptBootfile]) != "FDO1234X" {
+		t.Errorf("unexpected tftp options %q", opts)
+	}
+	s.Boot = "http"
+	opts = s.Options(&dhcp.Message{}, dhcp.Lease{MAC: "52:54:00:AA:BB:CC", VM: "r1"})
+	if want := "http://10.10.0.1:8080/r1.py"; string(opts[dhcp.OptBootfile]) != want {
+		t.Errorf("expected %s, got %q", want, opts[dhcp.OptBootfile])
+	}
+	if opts := s.Options(&dhcp.Message{}, dhcp.Lease{MAC: "52:54:00:AA:BB:CC", VM: "r2"}); opts != nil {
+		t.Errorf("expected no options without a config, got %q", opts)
+	}
+}
+
+func TestServer_ServeHTTP(t *testing.T) {
+	s := testServer(t)
+	var fetches []Fetch
+	s.OnFetch = func(f Fetch) { fetches = append(fetches, f) }
+	s.VMByIP = func(netip.Addr) string { return "r1" }
+	// httptest requests come from 192.0.2.1
+	s.Options(&dhcp.Message{}, dhcp.Lease{MAC: "52:54:00:AA:BB:CC", IP: netip.MustParseAddr("192.0.2.1"), VM: "r1"})
+	for path, status := range map[string]int{
+		"/r1.py":         http.StatusOK,
+		"/FDO1234X":      http.StatusNotFound, // another router's
+		"/../etc/passwd": http.StatusNotFound,
+		"/missing":       http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+	if len(fetches) != 4 || fetches[0].VM != "r1" {
+		t.Errorf("expected 4 logged fetches by r1, got %v", fetches)
+	}
+	// unknown clients get nothing
+	if _, err := s.Open(netip.MustParseAddr("10.10.0.99"), "r1.py"); err == nil {
+		t.Errorf("expected an error for a client without an offer")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/perbu/qemu-wrapper/dhcp"
+	"github.com/perbu/qemu-wrapper/ztp"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ztpConfig enables zero touch provisioning on the bridge of a DHCP server.
+type ztpConfig struct {
+	// Dir holds the day-0 configurations, named by serial, MAC address or VM name.
+	Dir string `json:"dir"`
+	// Boot is tftp (the default) or http, how the configuration is advertised.
+	Boot     string `json:"boot,omitempty"`
+	HTTPPort int    `json:"http_port,omitempty"`
+}
+
+// startZTP serves the configurations over HTTP on the server address and advertises
+// them in the DHCP replies, until the context is cancelled. The returned server
+// is for the TFTP server to read the configurations from.
+func startZTP(ctx context.Context, c ztpConfig, bridge string, srv *dhcp.Server, serverIP netip.Addr) (*ztp.Server, error) {
+	if c.Boot == "" {
+		c.Boot = "tftp"
+	}
+	if c.Boot != "tftp" && c.Boot != "http" {
+		return nil, fmt.Errorf("ztp boot must be tftp or http, not %q", c.Boot)
+	}
+	if c.HTTPPort == 0 {
+		c.HTTPPort = 80
+	}
+	if st, err := os.Stat(c.Dir); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("ztp dir %q is not a directory", c.Dir)
+	}
+	// listen before advertising, a port in use or needing privileges is an error
+	ln, err := net.Listen("tcp", net.JoinHostPort(serverIP.String(), strconv.Itoa(c.HTTPPort)))
+	if err != nil {
+		return nil, fmt.Errorf("ztp http: %w", err)
+	}
+	logger, closeLog, err := ztpLogger(bridge)
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	z := &ztp.Server{
+		Dir:      c.Dir,
+		Boot:     c.Boot,
+		ServerIP: serverIP,
+		HTTPPort: c.HTTPPort,
+		VMByIP:   func(ip netip.Addr) string { return leaseVM(srv, ip) },
+		OnFetch:  func(f ztp.Fetch) { logger.Println(f) },
+	}
+	srv.Options = z.Options
+
+	hs := &http.Server{Handler: z}
+	go func() {
+		err := hs.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ztp: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = hs.Close()
+		closeLog()
+	}()
+	fmt.Printf("ZTP configs from %s over %s, http on %s\n", c.Dir, c.Boot, ln.Addr())
+	return z, nil
+}
+
+// ztpLogger logs fetches with timestamps to stdout and to <bridge>.ztp.log in the state dir.
+func ztpLogger(bridge string) (*log.Logger, func(), error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, bridge+".ztp.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ztp log: %w", err)
+	}
+	logger := log.New(io.MultiWriter(os.Stdout, f), "", log.Ldate|log.Ltime)
+	return logger, func() { _ = f.Close() }, nil
+}